@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/jesand/webcp/crawl"
+	"io"
+	"os"
+)
+
+// The formats in which the check command can report broken links
+var REPORT_FORMATS = []string{"text", "json", "junit"}
+
+// Write the check command's report to the --report file, or to stdout
+func writeCheckReport(stats *crawl.Stats, config Config) error {
+	if config.Report == "" {
+		return WriteLinkReport(os.Stdout, config.ReportFormat, stats.Links)
+	}
+	file, err := os.Create(config.Report)
+	if err != nil {
+		return err
+	}
+	if err = WriteLinkReport(file, config.ReportFormat, stats.Links); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Report the broken links among the checked links, and the links which were
+// skipped, in the given format
+func WriteLinkReport(w io.Writer, format string, results []crawl.LinkResult) error {
+	var links, broken, skipped []crawl.LinkResult
+	for _, link := range results {
+		if link.Skipped != "" {
+			skipped = append(skipped, link)
+			continue
+		}
+		links = append(links, link)
+		if link.Broken() {
+			broken = append(broken, link)
+		}
+	}
+	switch format {
+	case "json":
+		return writeJSONLinkReport(w, links, broken, skipped)
+	case "junit":
+		return writeJUnitLinkReport(w, links, broken, skipped)
+	default:
+		return writeTextLinkReport(w, links, broken, skipped)
+	}
+}
+
+// Report broken and skipped links as text
+func writeTextLinkReport(w io.Writer, links, broken, skipped []crawl.LinkResult) error {
+	summary := fmt.Sprintf("Checked %d links, %d broken", len(links), len(broken))
+	if len(skipped) > 0 {
+		summary += fmt.Sprintf(", %d skipped", len(skipped))
+	}
+	if _, err := fmt.Fprintln(w, summary); err != nil {
+		return err
+	}
+	for _, link := range broken {
+		fmt.Fprintf(w, "\n%s - %s\n", link.URL, link.Problem())
+		for _, ref := range link.Referrers {
+			fmt.Fprintf(w, "  linked from %s (%q)\n", ref.Page, ref.Text)
+		}
+	}
+	for _, link := range skipped {
+		fmt.Fprintf(w, "\n%s - skipped: %s\n", link.URL, link.Skipped)
+		for _, ref := range link.Referrers {
+			fmt.Fprintf(w, "  linked from %s (%q)\n", ref.Page, ref.Text)
+		}
+	}
+	return nil
+}
+
+// Report broken and skipped links as JSON
+func writeJSONLinkReport(w io.Writer, links, broken, skipped []crawl.LinkResult) error {
+	if broken == nil {
+		broken = []crawl.LinkResult{}
+	}
+	if skipped == nil {
+		skipped = []crawl.LinkResult{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Checked int                `json:"checked"`
+		Broken  []crawl.LinkResult `json:"broken"`
+		Skipped []crawl.LinkResult `json:"skipped"`
+	}{len(links), broken, skipped})
+}
+
+// A JUnit XML test suite, with a test case for each checked link
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+// A JUnit XML test case
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+// A failed JUnit XML test case
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// A skipped JUnit XML test case
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// Report broken links as JUnit XML, so a build can fail on them, with the
+// skipped links as skipped tests
+func writeJUnitLinkReport(w io.Writer, links, broken, skipped []crawl.LinkResult) error {
+	suite := junitSuite{
+		Name:     SW + " check",
+		Tests:    len(links) + len(skipped),
+		Failures: len(broken),
+		Skipped:  len(skipped),
+	}
+	for _, link := range links {
+		test := junitCase{
+			ClassName: "links",
+			Name:      link.URL,
+		}
+		if link.Broken() {
+			test.Failure = &junitFailure{Message: link.Problem()}
+			for _, ref := range link.Referrers {
+				test.Failure.Text += fmt.Sprintf("linked from %s (%q)\n", ref.Page, ref.Text)
+			}
+		}
+		suite.Cases = append(suite.Cases, test)
+	}
+	for _, link := range skipped {
+		suite.Cases = append(suite.Cases, junitCase{
+			ClassName: "links",
+			Name:      link.URL,
+			Skipped:   &junitSkipped{Message: link.Skipped},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/jesand/webcp/crawl"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestWriteLinkReport(t *testing.T) {
+	Convey("Given the results of checking some links", t, func() {
+		links := []crawl.LinkResult{
+			{
+				URL:    "http://domain.com/missing.html",
+				Status: 404,
+				Referrers: []crawl.Referrer{
+					{Page: "http://domain.com/", Text: "Missing"},
+				},
+			},
+			{URL: "http://domain.com/ok.html", Status: 200},
+			{URL: "http://nowhere.invalid/", Error: "no such host"},
+		}
+		var buff bytes.Buffer
+
+		Convey("When I write a text report", func() {
+			So(WriteLinkReport(&buff, "text", links), ShouldBeNil)
+
+			Convey("Then it lists the broken links and their referrers", func() {
+				So(buff.String(), ShouldEqual, `Checked 3 links, 2 broken
+
+http://domain.com/missing.html - 404 Not Found
+  linked from http://domain.com/ ("Missing")
+
+http://nowhere.invalid/ - no such host
+`)
+			})
+		})
+
+		Convey("When I write a JSON report", func() {
+			So(WriteLinkReport(&buff, "json", links), ShouldBeNil)
+			var report struct {
+				Checked int
+				Broken  []crawl.LinkResult
+			}
+			So(json.Unmarshal(buff.Bytes(), &report), ShouldBeNil)
+
+			Convey("Then it lists the broken links", func() {
+				So(report.Checked, ShouldEqual, 3)
+				So(report.Broken, ShouldResemble, []crawl.LinkResult{links[0], links[2]})
+			})
+		})
+
+		Convey("When some links were skipped", func() {
+			links = append(links, crawl.LinkResult{
+				URL:       "http://domain.com/calendar/",
+				Skipped:   crawl.SkipQuota,
+				Referrers: []crawl.Referrer{{Page: "http://domain.com/", Text: "Calendar"}},
+			})
+
+			Convey("Then the text report lists them with the reason", func() {
+				So(WriteLinkReport(&buff, "text", links), ShouldBeNil)
+				So(buff.String(), ShouldStartWith, "Checked 3 links, 2 broken, 1 skipped\n")
+				So(buff.String(), ShouldEndWith, `
+http://domain.com/calendar/ - skipped: quota
+  linked from http://domain.com/ ("Calendar")
+`)
+			})
+
+			Convey("Then the JSON report lists them", func() {
+				So(WriteLinkReport(&buff, "json", links), ShouldBeNil)
+				var report struct {
+					Checked int
+					Skipped []crawl.LinkResult
+				}
+				So(json.Unmarshal(buff.Bytes(), &report), ShouldBeNil)
+				So(report.Checked, ShouldEqual, 3)
+				So(report.Skipped, ShouldResemble, []crawl.LinkResult{links[3]})
+			})
+
+			Convey("Then the JUnit report has them as skipped tests", func() {
+				So(WriteLinkReport(&buff, "junit", links), ShouldBeNil)
+				var suite junitSuite
+				So(xml.Unmarshal(buff.Bytes(), &suite), ShouldBeNil)
+				So(suite.Tests, ShouldEqual, 4)
+				So(suite.Failures, ShouldEqual, 2)
+				So(suite.Skipped, ShouldEqual, 1)
+				So(suite.Cases[3].Skipped.Message, ShouldEqual, crawl.SkipQuota)
+			})
+		})
+
+		Convey("When I write a JUnit report", func() {
+			So(WriteLinkReport(&buff, "junit", links), ShouldBeNil)
+			var suite junitSuite
+			So(xml.Unmarshal(buff.Bytes(), &suite), ShouldBeNil)
+
+			Convey("Then each broken link is a failed test", func() {
+				So(buff.String(), ShouldStartWith, xml.Header)
+				So(suite.Tests, ShouldEqual, 3)
+				So(suite.Failures, ShouldEqual, 2)
+				So(suite.Cases[0].Name, ShouldEqual, "http://domain.com/missing.html")
+				So(suite.Cases[0].Failure.Message, ShouldEqual, "404 Not Found")
+				So(suite.Cases[0].Failure.Text, ShouldContainSubstring, `linked from http://domain.com/ ("Missing")`)
+				So(suite.Cases[1].Failure, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,926 @@
+package main
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/jesand/webcp/crawl"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Crawl settings, which may be loaded from a TOML or YAML file and overridden
+// on the command line
+type Config struct {
+	Seeds             []string              `toml:"seeds" yaml:"seeds"`
+	SeedsFile         string                `toml:"seeds_file" yaml:"seeds_file"`
+	Dest              string                `toml:"dest" yaml:"dest"`
+	Check             bool                  `toml:"-" yaml:"-"`
+	Bandwidth         int64                 `toml:"bandwidth" yaml:"bandwidth"`
+	CACert            string                `toml:"ca_cert" yaml:"ca_cert"`
+	ClientCert        string                `toml:"client_cert" yaml:"client_cert"`
+	ClientKey         string                `toml:"client_key" yaml:"client_key"`
+	ControlAddr       string                `toml:"control_addr" yaml:"control_addr"`
+	ConnectTimeout    float64               `toml:"connect_timeout" yaml:"connect_timeout"`
+	Cookies           string                `toml:"cookies" yaml:"cookies"`
+	Dedup             string                `toml:"dedup" yaml:"dedup"`
+	DedupSkipParse    bool                  `toml:"dedup_skip_parse" yaml:"dedup_skip_parse"`
+	Delay             float64               `toml:"delay" yaml:"delay"`
+	FolderScope       bool                  `toml:"folder_scope" yaml:"folder_scope"`
+	Graph             string                `toml:"graph" yaml:"graph"`
+	GraphFormat       string                `toml:"graph_format" yaml:"graph_format"`
+	HeaderTimeout     float64               `toml:"header_timeout" yaml:"header_timeout"`
+	Headers           []string              `toml:"headers" yaml:"headers,omitempty"`
+	HostConcurrency   int                   `toml:"host_concurrency" yaml:"host_concurrency"`
+	HostLimits        string                `toml:"host_limits" yaml:"host_limits"`
+	Insecure          bool                  `toml:"insecure" yaml:"insecure"`
+	Manifest          string                `toml:"manifest" yaml:"manifest"`
+	MaxBytes          int64                 `toml:"max_bytes" yaml:"max_bytes"`
+	MaxDepth          int                   `toml:"max_depth" yaml:"max_depth"`
+	MaxHops           int                   `toml:"max_hops" yaml:"max_hops"`
+	MaxPages          int                   `toml:"max_pages" yaml:"max_pages"`
+	MaxQueryVariants  int                   `toml:"max_query_variants" yaml:"max_query_variants"`
+	MaxSegmentRepeats int                   `toml:"max_segment_repeats" yaml:"max_segment_repeats"`
+	MaxSimilarPages   int                   `toml:"max_similar_pages" yaml:"max_similar_pages"`
+	MaxURLLength      int                   `toml:"max_url_length" yaml:"max_url_length"`
+	MetricsFile       string                `toml:"metrics_file" yaml:"metrics_file"`
+	MinRate           int64                 `toml:"min_rate" yaml:"min_rate"`
+	MinRateWindow     float64               `toml:"min_rate_window" yaml:"min_rate_window"`
+	NoProxy           []string              `toml:"no_proxy" yaml:"no_proxy,omitempty"`
+	Offline           string                `toml:"offline" yaml:"offline"`
+	Proxy             string                `toml:"proxy" yaml:"proxy"`
+	Quotas            []string              `toml:"quotas" yaml:"quotas,omitempty"`
+	Report            string                `toml:"report" yaml:"report"`
+	ReportFormat      string                `toml:"report_format" yaml:"report_format"`
+	Requisites        bool                  `toml:"requisites" yaml:"requisites"`
+	Resume            string                `toml:"resume" yaml:"resume"`
+	Retries           int                   `toml:"retries" yaml:"retries"`
+	Source            string                `toml:"source" yaml:"source"`
+	StatsJSON         string                `toml:"stats_json" yaml:"stats_json"`
+	Timeout           float64               `toml:"timeout" yaml:"timeout"`
+	TLSTimeout        float64               `toml:"tls_timeout" yaml:"tls_timeout"`
+	UserAgent         string                `toml:"user_agent" yaml:"user_agent"`
+	Wayback           bool                  `toml:"wayback" yaml:"wayback"`
+	WaybackAfter      string                `toml:"wayback_after" yaml:"wayback_after"`
+	WaybackArchive    string                `toml:"wayback_archive" yaml:"wayback_archive"`
+	WaybackAt         string                `toml:"wayback_at" yaml:"wayback_at"`
+	WaybackBefore     string                `toml:"wayback_before" yaml:"wayback_before"`
+	WaybackCDX        string                `toml:"wayback_cdx" yaml:"wayback_cdx"`
+	WaybackCollapse   bool                  `toml:"wayback_collapse" yaml:"wayback_collapse"`
+	WaybackEnumerate  bool                  `toml:"wayback_enumerate" yaml:"wayback_enumerate"`
+	WaybackMIME       string                `toml:"wayback_mime" yaml:"wayback_mime"`
+	WaybackStatus     string                `toml:"wayback_status" yaml:"wayback_status"`
+	WaybackStrategy   string                `toml:"wayback_strategy" yaml:"wayback_strategy"`
+	Workers           int                   `toml:"workers" yaml:"workers"`
+	Hosts             map[string]HostConfig `toml:"hosts" yaml:"hosts,omitempty"`
+	Auth              map[string]AuthConfig `toml:"auth" yaml:"auth,omitempty"`
+	Login             *LoginConfig          `toml:"login,omitempty" yaml:"login,omitempty"`
+	Archives          []ArchiveConfig       `toml:"archives" yaml:"archives,omitempty"`
+}
+
+// Request limits, headers and connection settings for a single host. The
+// connection settings given replace those for every host. As in a host
+// limits file, limits left out keep the defaults, and a limit of 0 removes it.
+type HostConfig struct {
+	Rate        *float64          `toml:"rate" yaml:"rate,omitempty"`
+	Burst       *int              `toml:"burst" yaml:"burst,omitempty"`
+	Concurrency *int              `toml:"concurrency" yaml:"concurrency,omitempty"`
+	Headers     map[string]string `toml:"headers" yaml:"headers,omitempty"`
+	Proxy       string            `toml:"proxy" yaml:"proxy,omitempty"`
+	CACert      string            `toml:"ca_cert" yaml:"ca_cert,omitempty"`
+	ClientCert  string            `toml:"client_cert" yaml:"client_cert,omitempty"`
+	ClientKey   string            `toml:"client_key" yaml:"client_key,omitempty"`
+	Insecure    bool              `toml:"insecure" yaml:"insecure,omitempty"`
+}
+
+// Get the rate limits given for a host, and whether any were given
+func (host HostConfig) limit() (limit crawl.HostLimit, ok bool) {
+	if host.Rate != nil {
+		limit.Rate = *host.Rate
+		if limit.Rate == 0 {
+			limit.Rate = crawl.NoLimit
+		}
+	}
+	if host.Burst != nil {
+		limit.Burst = *host.Burst
+		if limit.Burst == 0 {
+			limit.Burst = crawl.NoLimit
+		}
+	}
+	if host.Concurrency != nil {
+		limit.Concurrency = *host.Concurrency
+		if limit.Concurrency == 0 {
+			limit.Concurrency = crawl.NoLimit
+		}
+	}
+	return limit, host.Rate != nil || host.Burst != nil || host.Concurrency != nil
+}
+
+// Ask whether a host has its own connection settings
+func (host HostConfig) hasConnection() bool {
+	return host.Proxy != "" || host.CACert != "" || host.ClientCert != "" ||
+		host.ClientKey != "" || host.Insecure
+}
+
+// Credentials for a single host: a username and password for HTTP basic
+// auth, or a bearer token. Each secret may instead be read from the named
+// environment variable, to keep it out of the config file.
+type AuthConfig struct {
+	Username    string `toml:"username" yaml:"username,omitempty"`
+	Password    string `toml:"password" yaml:"password,omitempty"`
+	PasswordEnv string `toml:"password_env" yaml:"password_env,omitempty"`
+	Token       string `toml:"token" yaml:"token,omitempty"`
+	TokenEnv    string `toml:"token_env" yaml:"token_env,omitempty"`
+}
+
+// A login form to submit before the crawl begins. Each field's value is
+// given directly, or read from the named environment variable.
+type LoginConfig struct {
+	URL       string            `toml:"url" yaml:"url"`
+	Fields    map[string]string `toml:"fields" yaml:"fields,omitempty"`
+	FieldsEnv map[string]string `toml:"fields_env" yaml:"fields_env,omitempty"`
+}
+
+// A Memento archive to crawl from, such as a pywb or OpenWayback instance.
+// The name defaults to the TimeGate's host, and the TimeMap to the
+// TimeGate's URL followed by "timemap/link/".
+type ArchiveConfig struct {
+	Name     string `toml:"name" yaml:"name,omitempty"`
+	TimeGate string `toml:"timegate" yaml:"timegate"`
+	TimeMap  string `toml:"timemap" yaml:"timemap,omitempty"`
+}
+
+// Get the settings used when neither the config file nor the command line
+// provide a value
+func DefaultConfig() Config {
+	return Config{
+		ConnectTimeout:    30,
+		Dedup:             crawl.DedupNone.String(),
+		Delay:             5,
+		HeaderTimeout:     60,
+		HostConcurrency:   1,
+		MaxDepth:          5,
+		MaxQueryVariants:  crawl.DefaultTrapLimits.QueryVariants,
+		MaxSegmentRepeats: crawl.DefaultTrapLimits.SegmentRepeats,
+		MaxSimilarPages:   crawl.DefaultTrapLimits.SimilarPages,
+		MaxURLLength:      crawl.DefaultTrapLimits.URLLength,
+		MinRate:           100,
+		MinRateWindow:     30,
+		ReportFormat:      REPORT_FORMATS[0],
+		Retries:           2,
+		TLSTimeout:        10,
+		UserAgent:         USER_AGENT,
+		WaybackStatus:     "200",
+		Workers:           1,
+	}
+}
+
+// Build the configuration for a parsed command line, starting from the
+// defaults and applying the --config file and then the other options
+func LoadConfig(args map[string]interface{}) (config Config, err error) {
+	config = DefaultConfig()
+	if path, ok := args["--config"].(string); ok {
+		if err = config.Load(path); err != nil {
+			return
+		}
+	}
+	err = config.ApplyArgs(args)
+	return
+}
+
+// Load settings from a TOML or YAML file, chosen by the file extension
+func (config *Config) Load(path string) error {
+	if configFormat(path) == "yaml" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		} else if err := yaml.UnmarshalStrict(data, config); err != nil {
+			return fmt.Errorf("Invalid config file %s - %v", path, err)
+		}
+		return nil
+	}
+
+	meta, err := toml.DecodeFile(path, config)
+	if err != nil {
+		return fmt.Errorf("Invalid config file %s - %v", path, err)
+	} else if keys := meta.Undecoded(); len(keys) > 0 {
+		return fmt.Errorf("Unknown setting %q in config file %s", keys[0].String(), path)
+	}
+	return nil
+}
+
+// Override settings with the options given on the command line
+func (config *Config) ApplyArgs(args map[string]interface{}) error {
+
+	// The positional arguments are the seeds followed by the destination, or
+	// just the seeds when checking links
+	positional, _ := args["<url>"].([]string)
+	if args["check"] == true {
+		config.Check = true
+		config.Dest = ""
+		if len(positional) > 0 {
+			config.Seeds = positional
+		}
+	} else if len(positional) > 0 {
+		config.Dest = positional[len(positional)-1]
+		if len(positional) > 1 {
+			config.Seeds = positional[:len(positional)-1]
+		}
+	}
+
+	strArg(args, "--seeds-file", &config.SeedsFile)
+	strArg(args, "--ca-cert", &config.CACert)
+	strArg(args, "--client-cert", &config.ClientCert)
+	strArg(args, "--client-key", &config.ClientKey)
+	strArg(args, "--control-addr", &config.ControlAddr)
+	strArg(args, "--cookies", &config.Cookies)
+	strArg(args, "--dedup", &config.Dedup)
+	strArg(args, "--graph", &config.Graph)
+	strArg(args, "--graph-format", &config.GraphFormat)
+	strArg(args, "--host-limits", &config.HostLimits)
+	strArg(args, "--manifest", &config.Manifest)
+	strArg(args, "--metrics-file", &config.MetricsFile)
+	strArg(args, "--offline", &config.Offline)
+	strArg(args, "--proxy", &config.Proxy)
+	strArg(args, "--report", &config.Report)
+	strArg(args, "--report-format", &config.ReportFormat)
+	strArg(args, "--resume", &config.Resume)
+	strArg(args, "--source", &config.Source)
+	strArg(args, "--stats-json", &config.StatsJSON)
+	strArg(args, "--user-agent", &config.UserAgent)
+	strArg(args, "--wayback-after", &config.WaybackAfter)
+	strArg(args, "--wayback-archive", &config.WaybackArchive)
+	strArg(args, "--wayback-at", &config.WaybackAt)
+	strArg(args, "--wayback-before", &config.WaybackBefore)
+	strArg(args, "--wayback-cdx", &config.WaybackCDX)
+	strArg(args, "--wayback-mime", &config.WaybackMIME)
+	strArg(args, "--wayback-status", &config.WaybackStatus)
+	strArg(args, "--wayback-strategy", &config.WaybackStrategy)
+	if headers, ok := args["--header"].([]string); ok && len(headers) > 0 {
+		config.Headers = append(config.Headers, headers...)
+	}
+	if quotas, ok := args["--quota"].([]string); ok && len(quotas) > 0 {
+		config.Quotas = append(config.Quotas, quotas...)
+	}
+	if memento, ok := args["--memento"].(string); ok {
+		config.Archives = nil
+		for _, timeGate := range strings.Split(memento, ",") {
+			config.Archives = append(config.Archives, ArchiveConfig{TimeGate: timeGate})
+		}
+	}
+	if noProxy, ok := args["--no-proxy"].(string); ok {
+		config.NoProxy = strings.Split(noProxy, ",")
+	}
+	boolArg(args, "--dedup-skip-parse", &config.DedupSkipParse)
+	boolArg(args, "--folder-scope", &config.FolderScope)
+	boolArg(args, "--insecure", &config.Insecure)
+	boolArg(args, "--requisites", &config.Requisites)
+	boolArg(args, "--wayback", &config.Wayback)
+	boolArg(args, "--wayback-collapse", &config.WaybackCollapse)
+	boolArg(args, "--wayback-enumerate", &config.WaybackEnumerate)
+	for name, dst := range map[string]interface{}{
+		"--bandwidth":           &config.Bandwidth,
+		"--connect-timeout":     &config.ConnectTimeout,
+		"--delay":               &config.Delay,
+		"--header-timeout":      &config.HeaderTimeout,
+		"--host-concurrency":    &config.HostConcurrency,
+		"--max-bytes":           &config.MaxBytes,
+		"--max-depth":           &config.MaxDepth,
+		"--max-hops":            &config.MaxHops,
+		"--max-pages":           &config.MaxPages,
+		"--max-query-variants":  &config.MaxQueryVariants,
+		"--max-segment-repeats": &config.MaxSegmentRepeats,
+		"--max-similar-pages":   &config.MaxSimilarPages,
+		"--max-url-length":      &config.MaxURLLength,
+		"--min-rate":            &config.MinRate,
+		"--min-rate-window":     &config.MinRateWindow,
+		"--retries":             &config.Retries,
+		"--timeout":             &config.Timeout,
+		"--tls-timeout":         &config.TLSTimeout,
+		"--workers":             &config.Workers,
+	} {
+		if err := numArg(args, name, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate the settings, and build a crawler from them
+func (config Config) Crawler() (crawler crawl.Crawler, reterr error) {
+	var (
+		dedupMode, dedupErr    = crawl.ParseDedupMode(config.Dedup)
+		seeds                  []crawl.Seed
+		wbAfterDate, wbAftErr  = ParseDate(config.WaybackAfter)
+		wbBeforeDate, wbBefErr = ParseDate(config.WaybackBefore)
+		wbAtDate, wbAtErr      = ParseDate(config.WaybackAt)
+		wbStrategy             crawl.CaptureStrategy
+		source                 crawl.SourcePolicy
+		archives               []crawl.MementoArchive
+		limits                 map[string]crawl.HostLimit
+	)
+
+	if dedupErr != nil {
+		reterr = fmt.Errorf("Invalid --dedup %q - %v", config.Dedup, dedupErr)
+		return
+	}
+
+	graphFormat := crawl.GraphFormatFor(config.Graph)
+	if config.GraphFormat != "" {
+		var err error
+		if graphFormat, err = crawl.ParseGraphFormat(config.GraphFormat); err != nil {
+			reterr = fmt.Errorf("Invalid --graph-format %q - %v", config.GraphFormat, err)
+			return
+		}
+	}
+
+	if config.Delay < 0 {
+		reterr = fmt.Errorf("Invalid --delay %v", config.Delay)
+		return
+	}
+
+	for name, timeout := range map[string]float64{
+		"--connect-timeout": config.ConnectTimeout,
+		"--header-timeout":  config.HeaderTimeout,
+		"--min-rate-window": config.MinRateWindow,
+		"--timeout":         config.Timeout,
+		"--tls-timeout":     config.TLSTimeout,
+	} {
+		if timeout < 0 {
+			reterr = fmt.Errorf("Invalid %s %v", name, timeout)
+			return
+		}
+	}
+
+	if config.MinRate < 0 {
+		reterr = fmt.Errorf("Invalid --min-rate %v", config.MinRate)
+		return
+	}
+
+	if config.MaxDepth < 0 {
+		reterr = fmt.Errorf("Invalid --max-depth %v", config.MaxDepth)
+		return
+	}
+
+	if config.MaxHops < 0 {
+		reterr = fmt.Errorf("Invalid --max-hops %v", config.MaxHops)
+		return
+	}
+
+	if config.MaxPages < 0 {
+		reterr = fmt.Errorf("Invalid --max-pages %v", config.MaxPages)
+		return
+	}
+
+	if config.MaxBytes < 0 {
+		reterr = fmt.Errorf("Invalid --max-bytes %v", config.MaxBytes)
+		return
+	}
+
+	for name, limit := range map[string]int{
+		"--max-query-variants":  config.MaxQueryVariants,
+		"--max-segment-repeats": config.MaxSegmentRepeats,
+		"--max-similar-pages":   config.MaxSimilarPages,
+		"--max-url-length":      config.MaxURLLength,
+	} {
+		if limit < 0 {
+			reterr = fmt.Errorf("Invalid %s %v", name, limit)
+			return
+		}
+	}
+
+	if config.Workers < 1 {
+		reterr = fmt.Errorf("Invalid --workers %v", config.Workers)
+		return
+	}
+
+	if config.HostConcurrency < 0 {
+		reterr = fmt.Errorf("Invalid --host-concurrency %v", config.HostConcurrency)
+		return
+	}
+
+	if config.Bandwidth < 0 {
+		reterr = fmt.Errorf("Invalid --bandwidth %v", config.Bandwidth)
+		return
+	}
+
+	if config.Retries < 0 {
+		reterr = fmt.Errorf("Invalid --retries %v", config.Retries)
+		return
+	}
+
+	if config.HostLimits != "" {
+		if limits, reterr = crawl.LoadHostLimits(config.HostLimits); reterr != nil {
+			return
+		}
+	}
+	for host, hostConfig := range config.Hosts {
+		limit, ok := hostConfig.limit()
+		if !ok {
+			continue
+		}
+		if limits == nil {
+			limits = make(map[string]crawl.HostLimit)
+		}
+		limits[host] = limit
+	}
+
+	var headers http.Header
+	for _, line := range config.Headers {
+		name, value, err := crawl.ParseHeader(line)
+		if err != nil {
+			reterr = fmt.Errorf("Invalid --header - %v", err)
+			return
+		}
+		if headers == nil {
+			headers = make(http.Header)
+		}
+		headers.Add(name, value)
+	}
+	var quotas []crawl.Quota
+	for _, spec := range config.Quotas {
+		quota, err := crawl.ParseQuota(spec)
+		if err != nil {
+			reterr = fmt.Errorf("Invalid --quota %q - %v", spec, err)
+			return
+		}
+		quotas = append(quotas, quota)
+	}
+
+	var hostHeaders map[string]http.Header
+	for host, hostConfig := range config.Hosts {
+		for name, value := range hostConfig.Headers {
+			if hostHeaders == nil {
+				hostHeaders = make(map[string]http.Header)
+			}
+			if hostHeaders[host] == nil {
+				hostHeaders[host] = make(http.Header)
+			}
+			hostHeaders[host].Set(name, value)
+		}
+	}
+
+	connection, err := config.connection(HostConfig{})
+	if err != nil {
+		reterr = err
+		return
+	}
+	var hostConnections map[string]crawl.Connection
+	for host, hostConfig := range config.Hosts {
+		if !hostConfig.hasConnection() {
+			continue
+		}
+		conn, err := config.connection(hostConfig)
+		if err != nil {
+			reterr = fmt.Errorf("Invalid connection settings for %s - %v", host, err)
+			return
+		}
+		if hostConnections == nil {
+			hostConnections = make(map[string]crawl.Connection)
+		}
+		hostConnections[host] = conn
+	}
+
+	var credentials map[string]crawl.Credential
+	for host, auth := range config.Auth {
+		cred, err := auth.Credential()
+		if err != nil {
+			reterr = fmt.Errorf("Invalid credentials for %s - %v", host, err)
+			return
+		}
+		if credentials == nil {
+			credentials = make(map[string]crawl.Credential)
+		}
+		credentials[host] = cred
+	}
+
+	var login *crawl.FormLogin
+	if config.Login != nil {
+		if login, reterr = config.Login.FormLogin(); reterr != nil {
+			return
+		}
+	}
+
+	var cookies *crawl.CookieJar
+	if config.Cookies != "" {
+		if cookies, reterr = crawl.LoadCookies(config.Cookies); reterr != nil {
+			return
+		}
+	}
+
+	if !validReportFormat(config.ReportFormat) {
+		reterr = fmt.Errorf("Invalid --report-format %q", config.ReportFormat)
+		return
+	}
+
+	if config.Dest == "" && !config.Check {
+		reterr = fmt.Errorf("<dest> is required")
+		return
+	}
+
+	for _, line := range config.Seeds {
+		seed, err := crawl.ParseSeed(line)
+		if err != nil {
+			reterr = err
+			return
+		}
+		seeds = append(seeds, seed)
+	}
+	if config.SeedsFile != "" {
+		fileSeeds, err := crawl.LoadSeeds(config.SeedsFile)
+		if err != nil {
+			reterr = err
+			return
+		}
+		seeds = append(seeds, fileSeeds...)
+	}
+	if len(seeds) == 0 {
+		reterr = fmt.Errorf("At least one <url> is required")
+		return
+	}
+
+	if config.Source != "" {
+		var err error
+		if source, err = crawl.ParseSourcePolicy(config.Source); err != nil {
+			reterr = fmt.Errorf("Invalid --source %q - %v", config.Source, err)
+			return
+		}
+	} else if config.Wayback {
+		source = crawl.SourceWayback
+	}
+
+	var waybackFilter crawl.CDXFilter
+	if source.UsesWayback() || config.WaybackEnumerate {
+		if config.WaybackAt != "" && wbAtErr != nil {
+			reterr = fmt.Errorf("Invalid --wayback-at date %q", config.WaybackAt)
+			return
+		}
+		if config.WaybackStrategy != "" {
+			var err error
+			if wbStrategy, err = crawl.ParseCaptureStrategy(config.WaybackStrategy); err != nil {
+				reterr = fmt.Errorf("Invalid --wayback-strategy %q - %v", config.WaybackStrategy, err)
+				return
+			}
+		} else if config.WaybackAt != "" {
+			wbStrategy = crawl.CaptureClosest
+		}
+		if wbStrategy == crawl.CaptureClosest && config.WaybackAt == "" {
+			reterr = fmt.Errorf("--wayback-strategy=closest needs --wayback-at")
+			return
+		}
+		if config.WaybackBefore != "" && wbBefErr != nil {
+			reterr = fmt.Errorf("Invalid --wayback-before date %q", config.WaybackBefore)
+			return
+		}
+		if config.WaybackAfter != "" && wbAftErr != nil {
+			reterr = fmt.Errorf("Invalid --wayback-after date %q", config.WaybackAfter)
+			return
+		}
+		if config.WaybackBefore != "" && config.WaybackAfter != "" && !wbBeforeDate.After(wbAfterDate) {
+			reterr = fmt.Errorf("--wayback-after %q is after --wayback-before %q",
+				config.WaybackAfter, config.WaybackBefore)
+			return
+		}
+		for _, archiveConfig := range config.Archives {
+			archive, err := archiveConfig.MementoArchive()
+			if err != nil {
+				reterr = err
+				return
+			}
+			archives = append(archives, archive)
+		}
+	} else {
+		wbAfterDate = time.Time{}
+		wbAtDate = time.Time{}
+		wbBeforeDate = time.Time{}
+	}
+	if config.WaybackEnumerate {
+		waybackFilter = crawl.CDXFilter{
+			Status:         config.WaybackStatus,
+			MIMEType:       config.WaybackMIME,
+			CollapseDigest: config.WaybackCollapse,
+		}
+	}
+
+	var fetcher crawl.Fetcher
+	if config.Offline != "" {
+		if fetcher, reterr = crawl.OpenFetcher(config.Offline); reterr != nil {
+			return
+		}
+		config.Bandwidth = 0
+		config.Delay = 0
+		config.HostConcurrency = 0
+		limits = nil
+	}
+
+	crawler = crawl.Crawler{
+		Archives:        archives,
+		Bandwidth:       config.Bandwidth,
+		CheckLinks:      config.Check,
+		Connection:      connection,
+		ControlAddr:     config.ControlAddr,
+		Cookies:         cookies,
+		Credentials:     credentials,
+		Dedup:           dedupMode,
+		DedupSkipParse:  config.DedupSkipParse,
+		FetchDelay:      seconds(config.Delay),
+		Fetcher:         fetcher,
+		Folder:          config.Dest,
+		FolderScope:     config.FolderScope,
+		Headers:         headers,
+		Graph:           config.Graph,
+		GraphFormat:     graphFormat,
+		HostConcurrency: config.HostConcurrency,
+		HostConnections: hostConnections,
+		HostLimits:      limits,
+		HostHeaders:     hostHeaders,
+		Login:           login,
+		Manifest:        config.Manifest,
+		MaxBytes:        config.MaxBytes,
+		MaxDepth:        config.MaxDepth,
+		MaxHops:         config.MaxHops,
+		MaxPages:        config.MaxPages,
+		MetricsFile:     config.MetricsFile,
+		NoProxy:         config.NoProxy,
+		Quotas:          quotas,
+		Requisites:      config.Requisites,
+		Resume:          config.Resume,
+		Retries:         config.Retries,
+		Seeds:           seeds,
+		Source:          source,
+		Timeouts: crawl.Timeouts{
+			Connect:       seconds(config.ConnectTimeout),
+			TLSHandshake:  seconds(config.TLSTimeout),
+			Header:        seconds(config.HeaderTimeout),
+			Total:         seconds(config.Timeout),
+			MinRate:       config.MinRate,
+			MinRateWindow: seconds(config.MinRateWindow),
+		},
+		Traps: crawl.TrapLimits{
+			URLLength:      config.MaxURLLength,
+			SegmentRepeats: config.MaxSegmentRepeats,
+			QueryVariants:  config.MaxQueryVariants,
+			SimilarPages:   config.MaxSimilarPages,
+		},
+		UserAgent:        config.UserAgent,
+		WaybackAfter:     wbAfterDate,
+		WaybackArchive:   config.WaybackArchive,
+		WaybackAt:        wbAtDate,
+		WaybackBefore:    wbBeforeDate,
+		WaybackCDX:       config.WaybackCDX,
+		WaybackEnumerate: config.WaybackEnumerate,
+		WaybackFilter:    waybackFilter,
+		WaybackStrategy:  wbStrategy,
+		Workers:          config.Workers,
+	}
+	return
+}
+
+// Write the settings in the given format, either "toml" or "yaml". Secrets
+// are left out: passwords, tokens, login form fields and secretHeaders given
+// in the config file or on the command line must be added back, while those
+// read from the environment are kept.
+func (config Config) Dump(w io.Writer, format string) error {
+	config = config.redacted()
+	if format == "yaml" {
+		data, err := yaml.Marshal(config)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return toml.NewEncoder(w).Encode(config)
+}
+
+// Build the connection settings for a host, starting from those for every
+// host
+func (config Config) connection(host HostConfig) (conn crawl.Connection, err error) {
+	pick := func(hostValue, value string) string {
+		if hostValue != "" {
+			return hostValue
+		}
+		return value
+	}
+	if proxy := pick(host.Proxy, config.Proxy); proxy != "" {
+		if conn.Proxy, err = parseProxy(proxy); err != nil {
+			return
+		}
+	}
+	conn.TLS, err = crawl.LoadTLSConfig(pick(host.CACert, config.CACert),
+		pick(host.ClientCert, config.ClientCert), pick(host.ClientKey, config.ClientKey),
+		host.Insecure || config.Insecure)
+	return
+}
+
+// Parse a proxy URL
+func parseProxy(proxy string) (*url.URL, error) {
+	site, err := url.Parse(proxy)
+	if err != nil || site.Host == "" {
+		return nil, fmt.Errorf("Invalid --proxy %q", proxy)
+	}
+	switch site.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return site, nil
+	default:
+		return nil, fmt.Errorf("Invalid --proxy %q - use an http, https or socks5 URL", proxy)
+	}
+}
+
+// The request headers which carry credentials, left out of a dumped config
+var secretHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// Ask whether a request header carries credentials
+func secretHeader(name string) bool {
+	name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+	for _, secret := range secretHeaders {
+		if name == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// Copy the settings without any secrets
+func (config Config) redacted() Config {
+	var headers []string
+	for _, line := range config.Headers {
+		if !secretHeader(strings.SplitN(line, ":", 2)[0]) {
+			headers = append(headers, line)
+		}
+	}
+	config.Headers = headers
+	if config.Hosts != nil {
+		hosts := make(map[string]HostConfig, len(config.Hosts))
+		for name, host := range config.Hosts {
+			if host.Headers != nil {
+				headers := make(map[string]string, len(host.Headers))
+				for header, value := range host.Headers {
+					if !secretHeader(header) {
+						headers[header] = value
+					}
+				}
+				host.Headers = headers
+			}
+			hosts[name] = host
+		}
+		config.Hosts = hosts
+	}
+	if config.Auth != nil {
+		auth := make(map[string]AuthConfig, len(config.Auth))
+		for host, cred := range config.Auth {
+			cred.Password = ""
+			cred.Token = ""
+			auth[host] = cred
+		}
+		config.Auth = auth
+	}
+	if config.Login != nil {
+		login := *config.Login
+		login.Fields = nil
+		config.Login = &login
+	}
+	return config
+}
+
+// Build the credential, reading any secrets from the environment
+func (auth AuthConfig) Credential() (cred crawl.Credential, err error) {
+	cred = crawl.Credential{
+		Username: auth.Username,
+		Password: auth.Password,
+		Token:    auth.Token,
+	}
+	if auth.PasswordEnv != "" {
+		if cred.Password, err = lookupEnv(auth.PasswordEnv); err != nil {
+			return
+		}
+	}
+	if auth.TokenEnv != "" {
+		if cred.Token, err = lookupEnv(auth.TokenEnv); err != nil {
+			return
+		}
+	}
+	err = cred.Validate()
+	return
+}
+
+// Build the login form, reading any fields from the environment
+func (login LoginConfig) FormLogin() (*crawl.FormLogin, error) {
+	site, err := url.Parse(login.URL)
+	if err != nil || !site.IsAbs() {
+		return nil, fmt.Errorf("Invalid login URL %q", login.URL)
+	}
+	form := &crawl.FormLogin{URL: site, Fields: make(url.Values)}
+	for name, value := range login.Fields {
+		form.Fields.Set(name, value)
+	}
+	for name, env := range login.FieldsEnv {
+		value, err := lookupEnv(env)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid login field %s - %v", name, err)
+		}
+		form.Fields.Set(name, value)
+	}
+	return form, nil
+}
+
+// Describe the Memento archive, checking its URLs
+func (archive ArchiveConfig) MementoArchive() (crawl.MementoArchive, error) {
+	timeGate, err := url.Parse(archive.TimeGate)
+	if err != nil || !timeGate.IsAbs() || timeGate.Host == "" {
+		return crawl.MementoArchive{}, fmt.Errorf("Invalid archive TimeGate %q", archive.TimeGate)
+	}
+	if archive.TimeMap != "" {
+		timeMap, err := url.Parse(archive.TimeMap)
+		if err != nil || !timeMap.IsAbs() || timeMap.Host == "" {
+			return crawl.MementoArchive{}, fmt.Errorf("Invalid archive TimeMap %q", archive.TimeMap)
+		}
+	}
+	name := archive.Name
+	if name == "" {
+		name = timeGate.Host
+	}
+	return crawl.MementoArchive{
+		Name:     name,
+		TimeGate: archive.TimeGate,
+		TimeMap:  archive.TimeMap,
+	}, nil
+}
+
+// Convert a number of seconds to a duration
+func seconds(secs float64) time.Duration {
+	return time.Duration(float64(time.Second) * secs)
+}
+
+// Get the value of an environment variable which must be set
+func lookupEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("Environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// Ask whether the check command can write reports in a format
+func validReportFormat(format string) bool {
+	for _, valid := range REPORT_FORMATS {
+		if format == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the format of a config file from its extension
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	default:
+		return "toml"
+	}
+}
+
+// Copy a string option to dst, if it was given
+func strArg(args map[string]interface{}, name string, dst *string) {
+	if value, ok := args[name].(string); ok {
+		*dst = value
+	}
+}
+
+// Set a flag in dst, if it was given
+func boolArg(args map[string]interface{}, name string, dst *bool) {
+	if value, ok := args[name].(bool); ok && value {
+		*dst = true
+	}
+}
+
+// Parse a numeric option into dst, which must point to an int, int64 or
+// float64, if it was given
+func numArg(args map[string]interface{}, name string, dst interface{}) error {
+	value, ok := args[name].(string)
+	if !ok {
+		return nil
+	}
+	var err error
+	switch dst := dst.(type) {
+	case *int:
+		*dst, err = strconv.Atoi(value)
+	case *int64:
+		*dst, err = strconv.ParseInt(value, 10, 64)
+	case *float64:
+		*dst, err = strconv.ParseFloat(value, 64)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid %s %q - %v", name, value, err)
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jesand/webcp/crawl"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	const (
+		URL = "http://www.noplace.com/path/to/file.html"
+
+		TOML_CONFIG = `seeds = ["http://www.noplace.com/path/to/file.html"]
+dest = "."
+delay = 1.5
+max_depth = 3
+wayback = true
+wayback_after = "2013"
+
+[hosts."slow.com"]
+rate = 0.1
+`
+
+		YAML_CONFIG = `seeds: [http://www.noplace.com/path/to/file.html]
+dest: .
+delay: 1.5
+max_depth: 3
+wayback: true
+wayback_after: "2013"
+hosts:
+  slow.com:
+    rate: 0.1
+`
+	)
+	var expected crawl.Crawler
+	resetExpected := func() {
+		expected = crawl.Crawler{
+			FetchDelay:      1500 * time.Millisecond,
+			Folder:          ".",
+			HostConcurrency: 1,
+			HostLimits: map[string]crawl.HostLimit{
+				"slow.com": crawl.HostLimit{Rate: 0.1},
+			},
+			MaxDepth:      3,
+			Retries:       2,
+			Timeouts:      DEFAULT_TIMEOUTS,
+			Traps:         DEFAULT_TRAPS,
+			Source:        crawl.SourceWayback,
+			UserAgent:     USER_AGENT,
+			WaybackAfter:  time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC),
+			WaybackBefore: time.Time{},
+			Workers:       1,
+		}
+		seed, _ := crawl.ParseSeed(URL)
+		expected.Seeds = []crawl.Seed{seed}
+	}
+	var tmp string
+
+	Convey("Given a TOML config file", t, func() {
+		resetExpected()
+		tmp, _ = ioutil.TempDir("", "webcp")
+		Reset(func() {
+			os.RemoveAll(tmp)
+		})
+		path := filepath.Join(tmp, "crawl.toml")
+		So(ioutil.WriteFile(path, []byte(TOML_CONFIG), 0644), ShouldBeNil)
+
+		Convey("When I run with just the config file", func() {
+			crawler, err := ParseArgs([]string{"--config=" + path})
+
+			Convey("Then the crawler uses its settings", func() {
+				So(err, ShouldBeNil)
+				So(crawler, ShouldResemble, expected)
+			})
+		})
+
+		Convey("When I override its settings on the command line", func() {
+			crawler, err := ParseArgs([]string{"--config=" + path, "--max-depth=1", "--workers=2"})
+
+			Convey("Then the crawler uses the command line settings", func() {
+				So(err, ShouldBeNil)
+				expected.MaxDepth = 1
+				expected.Workers = 2
+				So(crawler, ShouldResemble, expected)
+			})
+		})
+
+		Convey("When I ask for the statistics on the command line", func() {
+			config, err := LoadConfig(parseUsage([]string{"--config=" + path, "--stats-json=stats.json"}))
+
+			Convey("Then the config names the statistics file", func() {
+				So(err, ShouldBeNil)
+				So(config.StatsJSON, ShouldEqual, "stats.json")
+			})
+		})
+
+		Convey("When I ask for the link graph on the command line", func() {
+			crawler, err := ParseArgs([]string{"--config=" + path, "--graph=links.dot"})
+
+			Convey("Then its format comes from the file extension", func() {
+				So(err, ShouldBeNil)
+				So(crawler.Graph, ShouldEqual, "links.dot")
+				So(crawler.GraphFormat, ShouldEqual, crawl.GraphDOT)
+			})
+		})
+
+		Convey("When I ask for the link graph in an unknown format", func() {
+			_, err := ParseArgs([]string{"--config=" + path, "--graph=links.txt", "--graph-format=svg"})
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the config file holds quotas", func() {
+			quotasPath := filepath.Join(tmp, "quotas.toml")
+			So(ioutil.WriteFile(quotasPath, []byte(`quotas = ["example.com/calendar/=100"]
+`+TOML_CONFIG), 0644), ShouldBeNil)
+			crawler, err := ParseArgs([]string{"--config=" + quotasPath, "--quota=~[?&]page=[0-9]+=50"})
+
+			Convey("Then the command line adds to them", func() {
+				So(err, ShouldBeNil)
+				So(len(crawler.Quotas), ShouldEqual, 2)
+				So(crawler.Quotas[0].String(), ShouldEqual, "example.com/calendar/=100")
+				So(crawler.Quotas[1].String(), ShouldEqual, "~[?&]page=[0-9]+=50")
+			})
+		})
+
+		Convey("When the config file holds headers for hosts", func() {
+			headersPath := filepath.Join(tmp, "headers.toml")
+			So(ioutil.WriteFile(headersPath, []byte(TOML_CONFIG+`headers = { "X-Key" = "abc" }
+
+[hosts."wiki.example.com".headers]
+"x-team" = "wiki"
+`), 0644), ShouldBeNil)
+			crawler, err := ParseArgs([]string{"--config=" + headersPath})
+
+			Convey("Then the crawler sends each host its own headers", func() {
+				So(err, ShouldBeNil)
+				So(crawler.HostHeaders, ShouldResemble, map[string]http.Header{
+					"slow.com":         {"X-Key": {"abc"}},
+					"wiki.example.com": {"X-Team": {"wiki"}},
+				})
+				So(crawler.HostLimits, ShouldResemble, expected.HostLimits)
+			})
+		})
+
+		Convey("When the config file sets a host's limit to 0", func() {
+			limitsPath := filepath.Join(tmp, "limits.toml")
+			So(ioutil.WriteFile(limitsPath, []byte(TOML_CONFIG+`
+[hosts."internal.com"]
+concurrency = 0
+`), 0644), ShouldBeNil)
+			crawler, err := ParseArgs([]string{"--config=" + limitsPath})
+
+			Convey("Then the limit is removed for that host, as in a host limits file", func() {
+				So(err, ShouldBeNil)
+				So(crawler.HostLimits, ShouldResemble, map[string]crawl.HostLimit{
+					"slow.com":     {Rate: 0.1},
+					"internal.com": {Concurrency: crawl.NoLimit},
+				})
+			})
+		})
+
+		Convey("When the config file holds connection settings for a host", func() {
+			connPath := filepath.Join(tmp, "conn.toml")
+			So(ioutil.WriteFile(connPath, []byte(TOML_CONFIG+`proxy = "http://proxy.corp:3128"
+
+[hosts."self-signed.corp"]
+insecure = true
+`), 0644), ShouldBeNil)
+			crawler, err := ParseArgs([]string{"--config=" + connPath, "--proxy=http://default.corp:8080"})
+
+			Convey("Then the host's settings replace those for every host", func() {
+				So(err, ShouldBeNil)
+				So(crawler.Connection.Proxy.String(), ShouldEqual, "http://default.corp:8080")
+				So(crawler.Connection.TLS, ShouldBeNil)
+				So(len(crawler.HostConnections), ShouldEqual, 2)
+				So(crawler.HostConnections["slow.com"].Proxy.String(), ShouldEqual, "http://proxy.corp:3128")
+				So(crawler.HostConnections["slow.com"].TLS, ShouldBeNil)
+				So(crawler.HostConnections["self-signed.corp"].Proxy.String(), ShouldEqual, "http://default.corp:8080")
+				So(crawler.HostConnections["self-signed.corp"].TLS.InsecureSkipVerify, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I give a cookies file which doesn't exist", func() {
+			_, err := ParseArgs([]string{"--config=" + path, "--cookies=" + filepath.Join(tmp, "missing.txt")})
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the config file holds credentials", func() {
+			os.Setenv("WEBCP_TEST_TOKEN", "t0ken")
+			os.Setenv("WEBCP_TEST_PASSWORD", "secret")
+			Reset(func() {
+				os.Unsetenv("WEBCP_TEST_TOKEN")
+				os.Unsetenv("WEBCP_TEST_PASSWORD")
+			})
+			authPath := filepath.Join(tmp, "auth.toml")
+			So(ioutil.WriteFile(authPath, []byte(TOML_CONFIG+`
+[auth."wiki.example.com"]
+username = "me"
+password = "hunter2"
+
+[auth."api.example.com"]
+token_env = "WEBCP_TEST_TOKEN"
+
+[login]
+url = "https://wiki.example.com/login"
+fields = { user = "me" }
+fields_env = { pass = "WEBCP_TEST_PASSWORD" }
+`), 0644), ShouldBeNil)
+
+			Convey("Then the crawler sends them", func() {
+				crawler, err := ParseArgs([]string{"--config=" + authPath})
+				So(err, ShouldBeNil)
+				So(crawler.Credentials, ShouldResemble, map[string]crawl.Credential{
+					"wiki.example.com": {Username: "me", Password: "hunter2"},
+					"api.example.com":  {Token: "t0ken"},
+				})
+				So(crawler.Login.URL.String(), ShouldEqual, "https://wiki.example.com/login")
+				So(crawler.Login.Fields.Get("user"), ShouldEqual, "me")
+				So(crawler.Login.Fields.Get("pass"), ShouldEqual, "secret")
+			})
+
+			Convey("Then dumping the config leaves out the secrets", func() {
+				config, err := LoadConfig(parseUsage([]string{"config", "dump", "--config=" + authPath}))
+				So(err, ShouldBeNil)
+				var buff bytes.Buffer
+				So(config.Dump(&buff, "toml"), ShouldBeNil)
+				So(buff.String(), ShouldNotContainSubstring, "hunter2")
+				So(buff.String(), ShouldContainSubstring, "WEBCP_TEST_TOKEN")
+				So(buff.String(), ShouldContainSubstring, "WEBCP_TEST_PASSWORD")
+				So(config.Auth["wiki.example.com"].Password, ShouldEqual, "hunter2")
+			})
+
+			Convey("Then dumping the config leaves out headers holding credentials", func() {
+				headersPath := filepath.Join(tmp, "secret-headers.toml")
+				So(ioutil.WriteFile(headersPath, []byte(`headers = ["Accept-Language: en-US"]
+`+TOML_CONFIG+`
+[hosts."wiki.example.com".headers]
+cookie = "session=s3ssion"
+x-team = "wiki"
+`), 0644), ShouldBeNil)
+				config, err := LoadConfig(parseUsage([]string{"config", "dump", "--config=" + headersPath,
+					"--header=Authorization: Bearer t0ken"}))
+				So(err, ShouldBeNil)
+				var buff bytes.Buffer
+				So(config.Dump(&buff, "toml"), ShouldBeNil)
+				So(buff.String(), ShouldNotContainSubstring, "t0ken")
+				So(buff.String(), ShouldNotContainSubstring, "s3ssion")
+				So(buff.String(), ShouldContainSubstring, "Accept-Language: en-US")
+				So(buff.String(), ShouldContainSubstring, "wiki")
+				So(config.Headers, ShouldResemble, []string{"Accept-Language: en-US", "Authorization: Bearer t0ken"})
+				So(config.Hosts["wiki.example.com"].Headers["cookie"], ShouldEqual, "session=s3ssion")
+			})
+
+			Convey("Then a missing environment variable is an error", func() {
+				os.Unsetenv("WEBCP_TEST_TOKEN")
+				_, err := ParseArgs([]string{"--config=" + authPath})
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the config file lists Memento archives", func() {
+			archivesPath := filepath.Join(tmp, "archives.toml")
+			So(ioutil.WriteFile(archivesPath, []byte(TOML_CONFIG+`
+[[archives]]
+name = "partner"
+timegate = "http://pywb.partner.org/coll/"
+
+[[archives]]
+timegate = "http://archive.example.com/wayback/"
+timemap = "http://archive.example.com/wayback/timemap/link/"
+`), 0644), ShouldBeNil)
+
+			Convey("Then the crawler asks them in order", func() {
+				crawler, err := ParseArgs([]string{"--config=" + archivesPath})
+				So(err, ShouldBeNil)
+				So(crawler.Archives, ShouldResemble, []crawl.MementoArchive{
+					{Name: "partner", TimeGate: "http://pywb.partner.org/coll/"},
+					{Name: "archive.example.com", TimeGate: "http://archive.example.com/wayback/",
+						TimeMap: "http://archive.example.com/wayback/timemap/link/"},
+				})
+			})
+
+			Convey("Then --memento replaces them", func() {
+				crawler, err := ParseArgs([]string{"--config=" + archivesPath, "--memento=http://other.org/tg/"})
+				So(err, ShouldBeNil)
+				So(crawler.Archives, ShouldResemble, []crawl.MementoArchive{
+					{Name: "other.org", TimeGate: "http://other.org/tg/"},
+				})
+			})
+
+			Convey("Then I can load the dumped config", func() {
+				config, err := LoadConfig(parseUsage([]string{"config", "dump", "--config=" + archivesPath}))
+				So(err, ShouldBeNil)
+				var buff bytes.Buffer
+				So(config.Dump(&buff, "toml"), ShouldBeNil)
+				dumped := filepath.Join(tmp, "dumped.toml")
+				So(ioutil.WriteFile(dumped, buff.Bytes(), 0644), ShouldBeNil)
+				crawler, err := ParseArgs([]string{"--config=" + dumped})
+				So(err, ShouldBeNil)
+				So(len(crawler.Archives), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When a Memento archive has an invalid TimeGate", func() {
+			_, err := ParseArgs([]string{"--config=" + path, "--memento=pywb/coll/"})
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I dump the config", func() {
+			config, err := LoadConfig(parseUsage([]string{"config", "dump", "--config=" + path, "--delay=2"}))
+			So(err, ShouldBeNil)
+			var buff bytes.Buffer
+			So(config.Dump(&buff, "toml"), ShouldBeNil)
+
+			Convey("Then I can load the dumped config", func() {
+				dumped := filepath.Join(tmp, "dumped.toml")
+				So(ioutil.WriteFile(dumped, buff.Bytes(), 0644), ShouldBeNil)
+				crawler, err := ParseArgs([]string{"--config=" + dumped})
+				So(err, ShouldBeNil)
+				expected.FetchDelay = 2 * time.Second
+				So(crawler, ShouldResemble, expected)
+			})
+		})
+	})
+
+	Convey("Given a YAML config file", t, func() {
+		resetExpected()
+		tmp, _ = ioutil.TempDir("", "webcp")
+		Reset(func() {
+			os.RemoveAll(tmp)
+		})
+		path := filepath.Join(tmp, "crawl.yaml")
+		So(ioutil.WriteFile(path, []byte(YAML_CONFIG), 0644), ShouldBeNil)
+
+		Convey("When I run with just the config file", func() {
+			crawler, err := ParseArgs([]string{"--config=" + path})
+
+			Convey("Then the crawler uses its settings", func() {
+				So(err, ShouldBeNil)
+				So(crawler, ShouldResemble, expected)
+			})
+		})
+
+		Convey("When I dump the config", func() {
+			config, err := LoadConfig(parseUsage([]string{"config", "dump", "--config=" + path}))
+			So(err, ShouldBeNil)
+			var buff bytes.Buffer
+			So(config.Dump(&buff, "yaml"), ShouldBeNil)
+
+			Convey("Then I can load the dumped config", func() {
+				dumped := filepath.Join(tmp, "dumped.yml")
+				So(ioutil.WriteFile(dumped, buff.Bytes(), 0644), ShouldBeNil)
+				crawler, err := ParseArgs([]string{"--config=" + dumped})
+				So(err, ShouldBeNil)
+				So(crawler, ShouldResemble, expected)
+			})
+		})
+	})
+
+	Convey("Given a config file with an unknown setting", t, func() {
+		tmp, _ = ioutil.TempDir("", "webcp")
+		Reset(func() {
+			os.RemoveAll(tmp)
+		})
+		path := filepath.Join(tmp, "crawl.toml")
+		So(ioutil.WriteFile(path, []byte(TOML_CONFIG+"\nmax_dpeth = 2\n"), 0644), ShouldBeNil)
+		_, err := ParseArgs([]string{"--config=" + path})
+
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a config file with an invalid setting", t, func() {
+		tmp, _ = ioutil.TempDir("", "webcp")
+		Reset(func() {
+			os.RemoveAll(tmp)
+		})
+		path := filepath.Join(tmp, "crawl.yml")
+		So(ioutil.WriteFile(path, []byte(YAML_CONFIG+"workers: 0\n"), 0644), ShouldBeNil)
+		_, err := ParseArgs([]string{"--config=" + path})
+
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a missing config file", t, func() {
+		_, err := ParseArgs([]string{"--config=/no/such/file.toml"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
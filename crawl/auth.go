@@ -0,0 +1,105 @@
+package crawl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Credentials to send with every request to a host. Set either a username
+// and password for HTTP basic auth, or a bearer token.
+type Credential struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// Describe the credential without revealing its secrets, so it is safe to
+// print
+func (cred Credential) String() string {
+	if cred.Token != "" {
+		return "bearer token"
+	}
+	return "basic auth for " + cred.Username
+}
+
+// Check that the credential has exactly one kind of secret
+func (cred Credential) Validate() error {
+	if cred.Token != "" && (cred.Username != "" || cred.Password != "") {
+		return errors.New("Give either a username and password or a token, not both")
+	} else if cred.Token == "" && cred.Username == "" {
+		return errors.New("A username or token is required")
+	}
+	return nil
+}
+
+// A login form to submit before the crawl begins. The cookies set by the
+// response log the crawl in.
+type FormLogin struct {
+
+	// The URL to which the form is posted
+	URL *url.URL
+
+	// The form's fields, such as the username and password
+	Fields url.Values
+}
+
+// The maximum number of redirects to follow for a single request, matching
+// http.Client's default
+const maxRedirects = 10
+
+// Add the credentials for a request's host, if any
+func (crawler *Crawler) authorize(req *http.Request) {
+	cred, ok := crawler.Credentials[req.URL.Host]
+	if !ok {
+		return
+	} else if cred.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cred.Token)
+	} else {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+}
+
+// Decide whether to follow a redirect. The credentials and headers sent with
+// the original request are replaced with those for the new host, so they
+// never reach a host they weren't configured for.
+func (crawler *Crawler) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("Stopped after %d redirects", maxRedirects)
+	}
+	crawler.redirectHeaders(req, via)
+	req.Header.Del("Authorization")
+	crawler.authorize(req)
+	return nil
+}
+
+// Submit the login form to the live site, even when crawling an archive,
+// keeping the cookies it sets
+func (crawler *Crawler) login() error {
+	req, err := http.NewRequest("POST", crawler.Login.URL.String(),
+		strings.NewReader(crawler.Login.Fields.Encode()))
+	if err != nil {
+		return err
+	}
+	crawler.setHeaders(req)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	crawler.authorize(req)
+	req, watch := crawler.Timeouts.watch(req)
+	defer watch.close()
+	resp, err := crawler.liveFetcher().Do(req)
+	if err != nil {
+		return watch.error(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, watch.body(resp.Body)); err != nil {
+		return watch.error(err)
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s returned %s", crawler.Login.URL, resp.Status)
+	}
+	return nil
+}
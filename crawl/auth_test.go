@@ -0,0 +1,161 @@
+package crawl
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuth(t *testing.T) {
+	Convey("Given a credential", t, func() {
+		basic := Credential{Username: "me", Password: "secret"}
+		bearer := Credential{Token: "t0ken"}
+
+		Convey("Then printing it doesn't reveal its secrets", func() {
+			So(fmt.Sprint(basic), ShouldEqual, "basic auth for me")
+			So(fmt.Sprintf("%+v", bearer), ShouldEqual, "bearer token")
+		})
+
+		Convey("Then it must have exactly one kind of secret", func() {
+			So(basic.Validate(), ShouldBeNil)
+			So(bearer.Validate(), ShouldBeNil)
+			So(Credential{}.Validate(), ShouldNotBeNil)
+			So(Credential{Username: "me", Token: "t0ken"}.Validate(), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a private host which redirects to another host", t, func() {
+		var otherAuth string
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			otherAuth = r.Header.Get("Authorization")
+		}))
+		Reset(func() {
+			other.Close()
+		})
+		var archiveRequests int32
+		archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&archiveRequests, 1)
+			http.NotFound(w, r)
+		}))
+		Reset(func() {
+			archive.Close()
+		})
+		private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/slow-login":
+				select {
+				case <-time.After(time.Second):
+				case <-r.Context().Done():
+				}
+			case "/login":
+				if r.PostFormValue("user") != "me" || r.PostFormValue("pass") != "secret" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			case "/away":
+				http.Redirect(w, r, other.URL+"/", http.StatusFound)
+			default:
+				if user, pass, ok := r.BasicAuth(); !ok || user != "me" || pass != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			}
+		}))
+		Reset(func() {
+			private.Close()
+		})
+		privateURL, _ := url.Parse(private.URL)
+		loginURL, _ := privateURL.Parse("/login")
+
+		crawler := Crawler{
+			Credentials: map[string]Credential{
+				privateURL.Host: {Username: "me", Password: "secret"},
+			},
+			queue:   NewQueue(),
+			limiter: NewRateLimiter(HostLimit{}, nil, 0),
+			stats:   NewStats(),
+		}
+		So(crawler.initCookies(), ShouldBeNil)
+		crawler.client = crawler.newClient()
+
+		Convey("When I fetch a page from the private host", func() {
+			resp, _, err := crawler.get(privateURL)
+
+			Convey("Then I send its credentials", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When I follow a redirect to the other host", func() {
+			away, _ := privateURL.Parse("/away")
+			resp, _, err := crawler.get(away)
+
+			Convey("Then I don't send it the credentials", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Request.URL.String(), ShouldEqual, other.URL+"/")
+				So(otherAuth, ShouldEqual, "")
+			})
+		})
+
+		Convey("When I log in with the right fields", func() {
+			crawler.Login = &FormLogin{
+				URL:    loginURL,
+				Fields: url.Values{"user": {"me"}, "pass": {"secret"}},
+			}
+			err := crawler.login()
+
+			Convey("Then I keep the session cookie", func() {
+				So(err, ShouldBeNil)
+				So(len(crawler.Cookies.Cookies(privateURL)), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I log in while crawling from the Wayback Machine", func() {
+			crawler.Source = SourceWayback
+			crawler.wayback = &WaybackFetcher{Archive: archive.URL + "/web/", CDX: archive.URL + "/cdx"}
+			crawler.Login = &FormLogin{
+				URL:    loginURL,
+				Fields: url.Values{"user": {"me"}, "pass": {"secret"}},
+			}
+			err := crawler.login()
+
+			Convey("Then I log in to the live site", func() {
+				So(err, ShouldBeNil)
+				So(len(crawler.Cookies.Cookies(privateURL)), ShouldEqual, 1)
+				So(atomic.LoadInt32(&archiveRequests), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the login doesn't respond in time", func() {
+			crawler.Timeouts.Header = 50 * time.Millisecond
+			slow, _ := privateURL.Parse("/slow-login")
+			crawler.Login = &FormLogin{URL: slow}
+			err := crawler.login()
+
+			Convey("Then I give up waiting for it", func() {
+				timeout, ok := err.(*TimeoutError)
+				So(ok, ShouldBeTrue)
+				So(timeout.Reason, ShouldEqual, FailHeaderTimeout)
+			})
+		})
+
+		Convey("When I log in with the wrong fields", func() {
+			crawler.Login = &FormLogin{
+				URL:    loginURL,
+				Fields: url.Values{"user": {"me"}, "pass": {"wrong"}},
+			}
+			err := crawler.login()
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
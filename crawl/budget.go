@@ -0,0 +1,141 @@
+package crawl
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// The error for a download cut off at the crawl's byte limit
+var errMaxBytes = errors.New("Reached the crawl's byte limit")
+
+// The pages and bytes a session may still fetch under its MaxPages and
+// MaxBytes limits. The workers hold a page before fetching it and take bytes
+// as they read them, so together they can't go over either limit.
+type crawlBudget struct {
+	maxPages int
+	maxBytes int64
+
+	// The pages fetched, and those held by workers fetching them
+	pages, held int
+
+	// The bytes downloaded
+	bytes int64
+
+	mu      sync.Mutex
+	settled *sync.Cond
+}
+
+// Create a budget for a session's limits, where 0 means no limit. A nil
+// budget has no limits.
+func newCrawlBudget(maxPages int, maxBytes int64) *crawlBudget {
+	budget := &crawlBudget{maxPages: maxPages, maxBytes: maxBytes}
+	budget.settled = sync.NewCond(&budget.mu)
+	return budget
+}
+
+// Hold a page for a worker about to fetch one, waiting while the pages left
+// are held by other workers in case one of them fails. Returns false, and
+// holds nothing, once the pages or bytes are used up. Call settle() once the
+// page is fetched.
+func (budget *crawlBudget) take() bool {
+	if budget == nil {
+		return true
+	}
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	for !budget.spent() && budget.maxPages > 0 && budget.pages+budget.held >= budget.maxPages {
+		budget.settled.Wait()
+	}
+	if budget.spent() {
+		return false
+	}
+	budget.held++
+	return true
+}
+
+// Settle a page held by take(): count it if it was fetched, or give it back
+// if the request failed
+func (budget *crawlBudget) settle(fetched bool) {
+	if budget == nil {
+		return
+	}
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.held--
+	if fetched {
+		budget.pages++
+	}
+	budget.settled.Broadcast()
+}
+
+// Ask whether the crawl has fetched as many pages or bytes as it may
+func (budget *crawlBudget) reached() bool {
+	if budget == nil {
+		return false
+	}
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	return budget.spent()
+}
+
+// Ask whether the pages or bytes are used up, with the lock held
+func (budget *crawlBudget) spent() bool {
+	return (budget.maxPages > 0 && budget.pages >= budget.maxPages) ||
+		(budget.maxBytes > 0 && budget.bytes >= budget.maxBytes)
+}
+
+// Take up to n of the bytes left, returning how many were taken
+func (budget *crawlBudget) takeBytes(n int) int {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	if int64(n) > budget.maxBytes-budget.bytes {
+		n = int(budget.maxBytes - budget.bytes)
+	}
+	budget.bytes += int64(n)
+	return n
+}
+
+// Give back bytes taken by takeBytes() but not read
+func (budget *crawlBudget) giveBytes(n int) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.bytes -= int64(n)
+}
+
+// Wrap a response body so it counts against the bytes left, and is cut off
+// with errMaxBytes once they are used up
+func (budget *crawlBudget) reader(r io.Reader) io.Reader {
+	if budget == nil || budget.maxBytes <= 0 {
+		return r
+	}
+	return &budgetReader{r: r, budget: budget}
+}
+
+// A reader which counts the bytes read against a crawl's budget
+type budgetReader struct {
+	r      io.Reader
+	budget *crawlBudget
+}
+
+// Read from the underlying reader, within the bytes left
+func (br *budgetReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return br.r.Read(p)
+	}
+	taken := br.budget.takeBytes(len(p))
+	if taken == 0 {
+
+		// Check for the end of the body, so a page which fits the budget
+		// exactly isn't cut off
+		var extra [1]byte
+		n, err := io.ReadFull(br.r, extra[:])
+		if n == 0 && err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, errMaxBytes
+	}
+	n, err := br.r.Read(p[:taken])
+	br.budget.giveBytes(taken - n)
+	return n, err
+}
@@ -0,0 +1,82 @@
+package crawl
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrawlBudget(t *testing.T) {
+	Convey("Given a budget of two pages", t, func() {
+		budget := newCrawlBudget(2, 0)
+
+		Convey("Then a worker waits while the pages left are held", func() {
+			So(budget.take(), ShouldBeTrue)
+			So(budget.take(), ShouldBeTrue)
+			took := make(chan bool, 1)
+			go func() {
+				took <- budget.take()
+			}()
+			waiting := true
+			select {
+			case ok := <-took:
+				took <- ok
+				waiting = false
+			case <-time.After(20 * time.Millisecond):
+			}
+			So(waiting, ShouldBeTrue)
+
+			Convey("And gets a page given back by a failed request", func() {
+				budget.settle(false)
+				So(<-took, ShouldBeTrue)
+				So(budget.reached(), ShouldBeFalse)
+				budget.settle(true)
+				budget.settle(true)
+				So(budget.reached(), ShouldBeTrue)
+				So(budget.take(), ShouldBeFalse)
+			})
+
+			Convey("And stops once the pages are fetched", func() {
+				budget.settle(true)
+				budget.settle(true)
+				So(<-took, ShouldBeFalse)
+				So(budget.reached(), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given a budget of ten bytes", t, func() {
+		budget := newCrawlBudget(0, 10)
+
+		Convey("Then a body within the budget is read", func() {
+			body, err := ioutil.ReadAll(budget.reader(strings.NewReader("0123456789")))
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "0123456789")
+			So(budget.reached(), ShouldBeTrue)
+		})
+
+		Convey("Then bodies over the budget are cut off", func() {
+			body, err := ioutil.ReadAll(budget.reader(strings.NewReader("012345")))
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "012345")
+			body, err = ioutil.ReadAll(budget.reader(strings.NewReader("6789abcdef")))
+			So(err, ShouldEqual, errMaxBytes)
+			So(string(body), ShouldEqual, "6789")
+			So(budget.take(), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given no budget", t, func() {
+		var budget *crawlBudget
+
+		Convey("Then there are no limits", func() {
+			So(budget.take(), ShouldBeTrue)
+			budget.settle(true)
+			So(budget.reached(), ShouldBeFalse)
+			r := strings.NewReader("body")
+			So(budget.reader(r), ShouldEqual, r)
+		})
+	})
+}
@@ -0,0 +1,204 @@
+package crawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The Internet Wayback Machine's CDX server, which lists the captures of
+// archived pages
+const DefaultCDXEndpoint = "https://web.archive.org/cdx/search/cdx"
+
+// A capture of a page in a web archive
+type Capture struct {
+
+	// The URL of the page that was captured
+	URL *url.URL
+
+	// When the page was captured
+	Timestamp time.Time
+
+	// The HTTP status of the captured response
+	Status int
+
+	// The MIME type of the captured response
+	MIMEType string
+
+	// The digest of the captured content
+	Digest string
+}
+
+// Selects the captures listed when enumerating an archived site
+type CDXFilter struct {
+
+	// A regular expression which the captured HTTP status must match, or ""
+	// for any status
+	Status string
+
+	// A regular expression which the captured MIME type must match, or ""
+	// for any type
+	MIMEType string
+
+	// Whether to list only the first of successive captures of a URL with
+	// the same content digest
+	CollapseDigest bool
+}
+
+// Build a CDX query for the captures of a URL, or with matchType "prefix"
+// of every URL it prefixes, within a date range
+func cdxQuery(target, matchType string, after, before time.Time) url.Values {
+	query := url.Values{
+		"url":       {target},
+		"matchType": {matchType},
+		"output":    {"json"},
+		"fl":        {"original,timestamp,statuscode,mimetype,digest"},
+	}
+	if !after.IsZero() {
+		query.Set("from", after.Format(waybackTimestamp))
+	}
+	if !before.IsZero() {
+		query.Set("to", before.Format(waybackTimestamp))
+	}
+	return query
+}
+
+// Send a CDX query, and list the captures in the response
+func queryCDX(fetcher Fetcher, endpoint string, query url.Values, userAgent string) ([]Capture, error) {
+	if endpoint == "" {
+		endpoint = DefaultCDXEndpoint
+	}
+	cdx, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CDX endpoint %q - %v", endpoint, err)
+	}
+	cdx.RawQuery = query.Encode()
+	req, err := http.NewRequest("GET", cdx.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := fetcher.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	var rows [][]string
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("Invalid CDX response from %s - %v", endpoint, err)
+	}
+	return parseCDX(rows)
+}
+
+// Parse the rows of a CDX response. The first row names the fields.
+func parseCDX(rows [][]string) ([]Capture, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	fields := make(map[string]int)
+	for i, name := range rows[0] {
+		fields[name] = i
+	}
+	for _, name := range []string{"original", "timestamp"} {
+		if _, ok := fields[name]; !ok {
+			return nil, fmt.Errorf("The CDX response has no %s field", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := fields[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var captures []Capture
+	for _, row := range rows[1:] {
+		site, err := url.Parse(field(row, "original"))
+		if err != nil || site.Host == "" {
+			continue
+		}
+		if host, port, err := net.SplitHostPort(site.Host); err == nil &&
+			(port == "80" && site.Scheme == "http" || port == "443" && site.Scheme == "https") {
+			site.Host = host
+		}
+		ts, err := time.Parse(waybackTimestamp, field(row, "timestamp"))
+		if err != nil {
+			continue
+		}
+		status, _ := strconv.Atoi(field(row, "statuscode"))
+		captures = append(captures, Capture{
+			URL:       site,
+			Timestamp: ts,
+			Status:    status,
+			MIMEType:  field(row, "mimetype"),
+			Digest:    field(row, "digest"),
+		})
+	}
+	return captures, nil
+}
+
+// Queue every archived page in the seeds' scopes, so pages which no other
+// page still links to are crawled too
+func (crawler *Crawler) enumerate() error {
+	queued := make(map[string]bool)
+	for _, seed := range crawler.seeds() {
+		queued[seed.URL.String()] = true
+	}
+	for _, seed := range crawler.seeds() {
+		captures, err := crawler.listCaptures(seed)
+		if err != nil {
+			return err
+		}
+		for _, capture := range captures {
+			if _, inScope := crawler.seedFor(capture.URL); !inScope {
+				continue
+			}
+			if crawler.wayback != nil {
+				crawler.wayback.AddCapture(capture)
+			}
+			if key := capture.URL.String(); !queued[key] {
+				queued[key] = true
+				crawler.queue.Add(QueueItem{URL: capture.URL, Depth: 1})
+			}
+		}
+	}
+	return nil
+}
+
+// List the captures of the pages in a seed's scope within the date range
+func (crawler *Crawler) listCaptures(seed Seed) ([]Capture, error) {
+	query := cdxQuery(seed.URL.Host+seed.scope(crawler.FolderScope), "prefix",
+		crawler.WaybackAfter, crawler.WaybackBefore)
+	if crawler.WaybackFilter.Status != "" {
+		query.Add("filter", "statuscode:"+crawler.WaybackFilter.Status)
+	}
+	if crawler.WaybackFilter.MIMEType != "" {
+		query.Add("filter", "mimetype:"+crawler.WaybackFilter.MIMEType)
+	}
+	if crawler.WaybackFilter.CollapseDigest {
+		query.Set("collapse", "digest")
+	}
+
+	endpoint := crawler.WaybackCDX
+	if endpoint == "" {
+		endpoint = DefaultCDXEndpoint
+	}
+	if cdx, err := url.Parse(endpoint); err == nil {
+		release := crawler.limiter.Acquire(cdx.Host)
+		defer release()
+	}
+	var client Fetcher = http.DefaultClient
+	if crawler.client != nil {
+		client = crawler.client
+	}
+	return queryCDX(client, endpoint, query, crawler.UserAgent)
+}
@@ -0,0 +1,150 @@
+package crawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// A page which links to another
+type Referrer struct {
+
+	// The linking page
+	Page string `json:"page"`
+
+	// The link's anchor text
+	Text string `json:"text"`
+}
+
+// The result of checking a link
+type LinkResult struct {
+
+	// The page linked to, without any fragment
+	URL string `json:"url"`
+
+	// The response status, or 0 if there was no response
+	Status int `json:"status,omitempty"`
+
+	// The reason there was no response
+	Error string `json:"error,omitempty"`
+
+	// The reason the page was neither crawled nor checked, such as SkipQuota
+	// or one of the crawler trap limits
+	Skipped string `json:"skipped,omitempty"`
+
+	// The pages which link to the URL
+	Referrers []Referrer `json:"referrers,omitempty"`
+}
+
+// Ask whether a link is broken
+func (result LinkResult) Broken() bool {
+	return result.Error != "" || result.Status >= 400
+}
+
+// Describe why a link is broken
+func (result LinkResult) Problem() string {
+	if result.Error != "" {
+		return result.Error
+	}
+	return fmt.Sprintf("%d %s", result.Status, http.StatusText(result.Status))
+}
+
+// Records the links found during a crawl, and the result of checking each
+type linkChecker struct {
+	mu      sync.Mutex
+	results map[string]*LinkResult
+}
+
+// Create an empty link checker
+func newLinkChecker() *linkChecker {
+	return &linkChecker{
+		results: make(map[string]*LinkResult),
+	}
+}
+
+// Record a link, returning whether it is the first link to its target
+func (checker *linkChecker) addLink(source *url.URL, link Link) (first bool) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	result := checker.result(link.URL)
+	first = len(result.Referrers) == 0 && result.Status == 0 && result.Error == "" && result.Skipped == ""
+	result.Referrers = append(result.Referrers, Referrer{
+		Page: source.String(),
+		Text: link.Text,
+	})
+	return first
+}
+
+// Record the result of fetching a page
+func (checker *linkChecker) setResult(site *url.URL, status int, err error) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	result := checker.result(site)
+	result.Status = status
+	result.Error = ""
+	result.Skipped = ""
+	if err != nil {
+		result.Error = err.Error()
+	}
+}
+
+// Record why a page was skipped rather than crawled or checked
+func (checker *linkChecker) setSkipped(site *url.URL, reason string) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	checker.result(site).Skipped = reason
+}
+
+// Get the result for a URL, creating it if necessary. The caller must hold
+// the lock.
+func (checker *linkChecker) result(site *url.URL) *LinkResult {
+	key := linkTarget(site).String()
+	result, ok := checker.results[key]
+	if !ok {
+		result = &LinkResult{URL: key}
+		checker.results[key] = result
+	}
+	return result
+}
+
+// Get the results for the links which were checked or skipped, sorted by URL
+func (checker *linkChecker) checked() []LinkResult {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	var results []LinkResult
+	for _, result := range checker.results {
+		if result.Status != 0 || result.Error != "" || result.Skipped != "" {
+			results = append(results, *result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].URL < results[j].URL
+	})
+	return results
+}
+
+// Get the page a link points to, without any fragment
+func linkTarget(site *url.URL) *url.URL {
+	target := *site
+	target.Fragment = ""
+	return &target
+}
+
+// Ask whether the crawler can check a link
+func checkable(site *url.URL) bool {
+	return site.Scheme == "http" || site.Scheme == "https"
+}
+
+// Check a link outside the crawl's scope with a HEAD request, falling back to
+// GET for servers which don't handle HEAD, without downloading the body.
+// Links are queued to be checked by the crawl's workers, so checking doesn't
+// hold up parsing pages.
+func (crawler *Crawler) checkLink(site *url.URL) {
+	status, err := crawler.requestStatus("HEAD", site)
+	if err != nil || status >= 400 {
+		status, err = crawler.requestStatus("GET", site)
+	}
+	crawler.checker.setResult(site, status, err)
+}
@@ -0,0 +1,157 @@
+package crawl
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCheckLinks(t *testing.T) {
+	Convey("Given a site with broken links", t, func() {
+		var extRequests int32
+		var noHeadSent int64
+		external := http.NewServeMux()
+		external.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&extRequests, 1)
+			w.WriteHeader(http.StatusGone)
+		})
+		external.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&extRequests, 1)
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			// Send a huge body, until the client stops reading it
+			chunk := make([]byte, 64*1024)
+			for i := 0; i < 4096; i++ {
+				n, err := w.Write(chunk)
+				atomic.AddInt64(&noHeadSent, int64(n))
+				if err != nil {
+					return
+				}
+			}
+		})
+		extSrv := httptest.NewServer(external)
+		Reset(func() {
+			extSrv.Close()
+		})
+
+		site := http.NewServeMux()
+		site.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `<a href="ok.html">OK</a> <a href="missing.html">Missing</a>
+<a href="%s/gone">Gone</a> <a href="%s/no-head">No HEAD</a>
+<a href="mailto:someone@domain.com">Mail</a>`, extSrv.URL, extSrv.URL)
+		})
+		site.HandleFunc("/ok.html", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `<a href="missing.html">Missing again</a> <a href="/#top">Top</a>`)
+		})
+		srv := httptest.NewServer(site)
+		Reset(func() {
+			srv.Close()
+		})
+
+		seed, _ := ParseSeed(srv.URL + "/")
+		crawler := Crawler{
+			CheckLinks: true,
+			MaxDepth:   5,
+			Seeds:      []Seed{seed},
+			queue:      NewQueue(),
+			limiter:    NewRateLimiter(HostLimit{}, nil, 0),
+			stats:      NewStats(),
+			control:    newControlState(),
+			checker:    newLinkChecker(),
+		}
+
+		Convey("When I check the links", func() {
+			crawler.crawl()
+			results := crawler.checker.checked()
+			byURL := make(map[string]LinkResult)
+			for _, result := range results {
+				byURL[result.URL] = result
+			}
+
+			Convey("Then I check every page and link once", func() {
+				So(len(results), ShouldEqual, 5)
+				So(byURL[srv.URL+"/"].Status, ShouldEqual, http.StatusOK)
+				So(byURL[srv.URL+"/ok.html"].Status, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Then I report the broken links with their referrers", func() {
+				missing := byURL[srv.URL+"/missing.html"]
+				So(missing.Broken(), ShouldBeTrue)
+				So(missing.Status, ShouldEqual, http.StatusNotFound)
+				So(missing.Referrers, ShouldResemble, []Referrer{
+					{Page: srv.URL + "/", Text: "Missing"},
+					{Page: srv.URL + "/ok.html", Text: "Missing again"},
+				})
+
+				gone := byURL[extSrv.URL+"/gone"]
+				So(gone.Status, ShouldEqual, http.StatusGone)
+				So(gone.Referrers, ShouldResemble, []Referrer{{Page: srv.URL + "/", Text: "Gone"}})
+			})
+
+			Convey("Then I fall back to GET for servers which don't handle HEAD", func() {
+				So(byURL[extSrv.URL+"/no-head"].Status, ShouldEqual, http.StatusOK)
+				So(byURL[extSrv.URL+"/no-head"].Broken(), ShouldBeFalse)
+			})
+
+			Convey("Then I don't download the body to get the status", func() {
+				So(atomic.LoadInt64(&noHeadSent), ShouldBeLessThan, 64*1024*1024)
+			})
+
+			Convey("Then I don't crawl pages outside the scope", func() {
+				host, _ := url.Parse(extSrv.URL)
+				So(crawler.stats.Hosts[host.Host], ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I parse a page with links outside the scope", func() {
+			crawler.fetch(QueueItem{URL: seed.URL, Depth: 1}, false)
+
+			Convey("Then the links are queued to be checked, rather than checked while parsing", func() {
+				So(atomic.LoadInt32(&extRequests), ShouldEqual, 0)
+				var checks []string
+				for {
+					item, ok := crawler.queue.Storage.Next()
+					if !ok {
+						break
+					}
+					if item.Check {
+						checks = append(checks, item.URL.String())
+					}
+				}
+				So(checks, ShouldResemble, []string{extSrv.URL + "/gone", extSrv.URL + "/no-head"})
+			})
+		})
+
+		Convey("When I check the links with a quota which is used up", func() {
+			quota, err := NewQuota("/missing.html", 0)
+			So(err, ShouldBeNil)
+			crawler.quotas = newQuotaState([]Quota{quota}, nil, nil)
+			crawler.crawl()
+			byURL := make(map[string]LinkResult)
+			for _, result := range crawler.checker.checked() {
+				byURL[result.URL] = result
+			}
+
+			Convey("Then I report the link as skipped, with the reason", func() {
+				missing := byURL[srv.URL+"/missing.html"]
+				So(missing.Skipped, ShouldEqual, SkipQuota)
+				So(missing.Broken(), ShouldBeFalse)
+				So(missing.Referrers, ShouldResemble, []Referrer{
+					{Page: srv.URL + "/", Text: "Missing"},
+					{Page: srv.URL + "/ok.html", Text: "Missing again"},
+				})
+			})
+		})
+	})
+}
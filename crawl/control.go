@@ -0,0 +1,217 @@
+package crawl
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// The states a crawl may be in
+const (
+	StateRunning  = "running"
+	StatePaused   = "paused"
+	StateStopping = "stopping"
+)
+
+// Lets a running crawl be paused, resumed, stopped and given new seeds
+type controlState struct {
+	mu      sync.Mutex
+	resumed *sync.Cond
+	paused  bool
+	stopped bool
+}
+
+// Create the control state for a running crawl
+func newControlState() *controlState {
+	control := &controlState{}
+	control.resumed = sync.NewCond(&control.mu)
+	return control
+}
+
+// Wait while the crawl is paused. Returns false if the crawl is stopping.
+func (control *controlState) wait() bool {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	for control.paused && !control.stopped {
+		control.resumed.Wait()
+	}
+	return !control.stopped
+}
+
+// Pause or resume the crawl
+func (control *controlState) setPaused(paused bool) {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	control.paused = paused
+	control.resumed.Broadcast()
+}
+
+// Stop the crawl once the pages being fetched are done
+func (control *controlState) stop() {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	control.stopped = true
+	control.resumed.Broadcast()
+}
+
+// Get the crawl's state
+func (control *controlState) state() string {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	if control.stopped {
+		return StateStopping
+	} else if control.paused {
+		return StatePaused
+	}
+	return StateRunning
+}
+
+// The status of a running crawl, as reported by the control server
+type Status struct {
+	State        string                `json:"state"`
+	Queued       int                   `json:"queued"`
+	InFlight     int                   `json:"in_flight"`
+	Done         int                   `json:"done"`
+	Failed       int                   `json:"failed"`
+	Bytes        int64                 `json:"bytes"`
+	ElapsedSecs  float64               `json:"elapsed_secs"`
+	Current      []string              `json:"current"`
+	Hosts        map[string]HostStatus `json:"hosts"`
+	RecentErrors []FetchError          `json:"recent_errors"`
+}
+
+// Get the status of the crawl
+func (crawler *Crawler) status() Status {
+	progress := crawler.progress()
+	status := Status{
+		State:        crawler.control.state(),
+		Queued:       progress.Queued,
+		InFlight:     progress.InFlight,
+		Done:         progress.Done,
+		Failed:       progress.Failed,
+		Bytes:        progress.Bytes,
+		ElapsedSecs:  progress.Elapsed.Seconds(),
+		Current:      []string{},
+		Hosts:        crawler.limiter.Status(),
+		RecentErrors: crawler.stats.RecentErrors(),
+	}
+	for _, page := range progress.Current {
+		if page != nil {
+			status.Current = append(status.Current, page.String())
+		}
+	}
+	return status
+}
+
+// Stop the crawl gracefully: the pages being fetched are finished, and the
+// rest of the frontier is left in the resume file
+func (crawler *Crawler) stop() {
+	crawler.control.stop()
+	crawler.queue.Stop()
+}
+
+// Create an HTTP handler which reports the crawl's status and controls it.
+//
+//	GET  /status   Report the crawl's status as JSON
+//	GET  /metrics  Report the crawl's metrics in the Prometheus text format
+//	POST /pause    Stop fetching new pages until resumed
+//	POST /resume   Resume a paused crawl
+//	POST /stop     Finish the pages being fetched, and end the crawl
+//	POST /seeds    Add the seeds in the request body, one per line
+//	POST /limits   Change the limits for the hosts in the request body, in
+//	               the format read by LoadHostLimits(). The host * sets the
+//	               default limits. Limits left out are unchanged, and 0
+//	               removes a limit.
+//
+// Every action responds with the crawl's status.
+func (crawler *Crawler) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		crawler.writeStatus(w)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		crawler.writeMetrics(w)
+	})
+	mux.HandleFunc("/pause", crawler.action(func(r *http.Request) error {
+		crawler.control.setPaused(true)
+		return nil
+	}))
+	mux.HandleFunc("/resume", crawler.action(func(r *http.Request) error {
+		crawler.control.setPaused(false)
+		return nil
+	}))
+	mux.HandleFunc("/stop", crawler.action(func(r *http.Request) error {
+		crawler.stop()
+		return nil
+	}))
+	mux.HandleFunc("/seeds", crawler.action(crawler.postSeeds))
+	mux.HandleFunc("/limits", crawler.action(crawler.postLimits))
+	return mux
+}
+
+// Wrap a control action in a handler which only accepts POST requests. An
+// error from the action is reported with 400 Bad Request.
+func (crawler *Crawler) action(act func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := act(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		crawler.writeStatus(w)
+	}
+}
+
+// Write the crawl's status as JSON
+func (crawler *Crawler) writeStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(crawler.status())
+}
+
+// Add the seeds in a request body
+func (crawler *Crawler) postSeeds(r *http.Request) error {
+	var seeds []Seed
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seed, err := ParseSeed(line)
+		if err != nil {
+			return err
+		}
+		seeds = append(seeds, seed)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, seed := range seeds {
+		crawler.addSeed(seed)
+	}
+	return nil
+}
+
+// Change the host limits in a request body
+func (crawler *Crawler) postLimits(r *http.Request) error {
+	limits, err := ParseHostLimits(r.Body, "request")
+	if err != nil {
+		return err
+	}
+	for host, limit := range limits {
+		if host == "*" {
+			host = ""
+		}
+		crawler.limiter.UpdateLimit(host, limit)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package crawl
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestControlHandler(t *testing.T) {
+	Convey("Given a running crawl with a control server", t, func() {
+		crawler := Crawler{
+			MaxDepth: 5,
+			queue:    NewQueue(),
+			limiter:  NewRateLimiter(HostLimit{Rate: 1}, nil, 0),
+			stats:    NewStats(),
+			control:  newControlState(),
+			workers:  newWorkerPages(1),
+		}
+		page, _ := url.Parse("http://domain.com/page.html")
+		crawler.queue.Add(QueueItem{URL: page, Depth: 1})
+		srv := httptest.NewServer(crawler.controlHandler())
+		Reset(func() {
+			srv.Close()
+		})
+
+		post := func(path, body string) (int, Status) {
+			resp, err := http.Post(srv.URL+path, "text/plain", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			var status Status
+			if resp.StatusCode == http.StatusOK {
+				So(json.NewDecoder(resp.Body).Decode(&status), ShouldBeNil)
+			}
+			return resp.StatusCode, status
+		}
+
+		Convey("When I ask for its status", func() {
+			resp, err := http.Get(srv.URL + "/status")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			var status Status
+			So(json.NewDecoder(resp.Body).Decode(&status), ShouldBeNil)
+
+			Convey("Then I get the frontier size", func() {
+				So(status.State, ShouldEqual, StateRunning)
+				So(status.Queued, ShouldEqual, 1)
+				So(status.InFlight, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I ask for its metrics", func() {
+			resp, err := http.Get(srv.URL + "/metrics")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			Convey("Then I get them in the Prometheus format", func() {
+				So(string(body), ShouldContainSubstring, "webcp_queued_pages 1\n")
+			})
+		})
+
+		Convey("When I pause and resume it", func() {
+			code, paused := post("/pause", "")
+			So(code, ShouldEqual, http.StatusOK)
+			_, resumed := post("/resume", "")
+
+			Convey("Then its state changes", func() {
+				So(paused.State, ShouldEqual, StatePaused)
+				So(resumed.State, ShouldEqual, StateRunning)
+				So(crawler.control.wait(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I stop it", func() {
+			_, status := post("/stop", "")
+
+			Convey("Then it stops handing out pages", func() {
+				So(status.State, ShouldEqual, StateStopping)
+				So(crawler.control.wait(), ShouldBeFalse)
+				_, ok := crawler.queue.Next()
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I add a seed", func() {
+			code, status := post("/seeds", "http://domain2.com/docs/ 2\n")
+
+			Convey("Then it is queued", func() {
+				So(code, ShouldEqual, http.StatusOK)
+				So(status.Queued, ShouldEqual, 2)
+				So(len(crawler.Seeds), ShouldEqual, 1)
+				So(crawler.Seeds[0].MaxDepth, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I add an invalid seed", func() {
+			code, _ := post("/seeds", "docs/index.html\n")
+
+			Convey("Then the request fails", func() {
+				So(code, ShouldEqual, http.StatusBadRequest)
+				So(crawler.Seeds, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I change the rate limits", func() {
+			code, _ := post("/limits", "* rate=2\ndomain.com concurrency=3\n")
+
+			Convey("Then the new limits apply", func() {
+				So(code, ShouldEqual, http.StatusOK)
+				So(crawler.limiter.Default, ShouldResemble, HostLimit{Rate: 2})
+				So(crawler.limiter.Hosts["domain.com"], ShouldResemble, HostLimit{Concurrency: 3})
+			})
+		})
+
+		Convey("When I change some of the rate limits, and remove others", func() {
+			post("/limits", "* concurrency=2\ndomain.com rate=5 burst=3\n")
+			code, _ := post("/limits", "* rate=0\ndomain.com concurrency=0 burst=4\n")
+
+			Convey("Then only the limits I sent change", func() {
+				So(code, ShouldEqual, http.StatusOK)
+				So(crawler.limiter.Default, ShouldResemble, HostLimit{Concurrency: 2})
+				So(crawler.limiter.Hosts["domain.com"], ShouldResemble,
+					HostLimit{Rate: 5, Burst: 4, Concurrency: NoLimit})
+				So(crawler.limiter.limit("domain.com"), ShouldResemble, HostLimit{Rate: 5, Burst: 4})
+			})
+		})
+
+		Convey("When I send an action without POST", func() {
+			resp, err := http.Get(srv.URL + "/stop")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+
+			Convey("Then the request is refused", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+				So(crawler.control.state(), ShouldEqual, StateRunning)
+			})
+		})
+	})
+}
@@ -0,0 +1,244 @@
+package crawl
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Holds the cookies for a crawl. Cookies set by a server are only sent back
+// to the host which set them, ignoring any Domain attribute, so one host can't
+// see or replace another's session. Cookies loaded from a file keep their
+// domain, and may apply to subdomains.
+type CookieJar struct {
+	mu      sync.Mutex
+	cookies []jarCookie
+
+	// Called with each cookie a server adds, changes or deletes, formatted as
+	// a line of a Netscape cookies file. Cookies set again unchanged are left
+	// out.
+	onSet func(line string)
+}
+
+// A cookie in a jar, with the hosts it applies to
+type jarCookie struct {
+	cookie *http.Cookie
+
+	// The host, or with subdomains the domain, the cookie applies to
+	host string
+
+	// Whether the cookie also applies to subdomains of the host
+	subdomains bool
+}
+
+// Create an empty cookie jar
+func NewCookieJar() *CookieJar {
+	return &CookieJar{}
+}
+
+// Load cookies from a file in the Netscape cookies.txt format, as exported
+// by browsers and written by curl and wget
+func LoadCookies(path string) (*CookieJar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	jar := NewCookieJar()
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if err := jar.load(scanner.Text()); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+	}
+	return jar, scanner.Err()
+}
+
+// Add a cookie from a line of a Netscape cookies file. Blank lines and
+// comments are ignored, and expired cookies remove any cookie they match.
+func (jar *CookieJar) load(line string) error {
+	line = strings.TrimRight(line, "\r\n")
+	httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+	if httpOnly {
+		line = line[len("#HttpOnly_"):]
+	} else if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	fields := strings.Split(line, "\t")
+	if len(fields) != 7 {
+		return fmt.Errorf("Invalid cookie %q", line)
+	}
+	expires, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid cookie expiry %q", fields[4])
+	}
+	entry := jarCookie{
+		cookie: &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		},
+		host:       strings.ToLower(strings.TrimPrefix(fields[0], ".")),
+		subdomains: strings.EqualFold(fields[1], "TRUE"),
+	}
+	if expires > 0 {
+		entry.cookie.Expires = time.Unix(expires, 0)
+	}
+
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	jar.set(entry, time.Now())
+	return nil
+}
+
+// Store the cookies set by a response to a URL
+func (jar *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	now := time.Now()
+	for _, cookie := range cookies {
+		stored := *cookie
+		stored.Domain = ""
+		if stored.Path == "" || !strings.HasPrefix(stored.Path, "/") {
+			stored.Path = defaultCookiePath(u)
+		}
+		if stored.MaxAge < 0 {
+			stored.Expires = time.Unix(1, 0)
+		} else if stored.MaxAge > 0 {
+			stored.Expires = now.Add(time.Duration(stored.MaxAge) * time.Second)
+		}
+		stored.MaxAge = 0
+		entry := jarCookie{
+			cookie: &stored,
+			host:   strings.ToLower(u.Hostname()),
+		}
+		if jar.set(entry, now) && jar.onSet != nil {
+			jar.onSet(entry.String())
+		}
+	}
+}
+
+// Get the cookies to send with a request to a URL, with the most specific
+// paths first
+func (jar *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	now := time.Now()
+	host := strings.ToLower(u.Hostname())
+	reqPath := u.EscapedPath()
+	if reqPath == "" {
+		reqPath = "/"
+	}
+	var matches []*http.Cookie
+	for _, entry := range jar.cookies {
+		if entry.expired(now) || !entry.matchesHost(host) ||
+			!cookiePathMatch(entry.cookie.Path, reqPath) ||
+			(entry.cookie.Secure && u.Scheme != "https") {
+			continue
+		}
+		matches = append(matches, entry.cookie)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return len(matches[i].Path) > len(matches[j].Path)
+	})
+	cookies := make([]*http.Cookie, len(matches))
+	for i, cookie := range matches {
+		cookies[i] = &http.Cookie{Name: cookie.Name, Value: cookie.Value}
+	}
+	return cookies
+}
+
+// Add or replace a cookie, or remove it if it has expired, returning whether
+// the jar changed. The caller must hold the lock.
+func (jar *CookieJar) set(entry jarCookie, now time.Time) (changed bool) {
+	changed = !entry.expired(now)
+	for i, old := range jar.cookies {
+		if old.host == entry.host && old.subdomains == entry.subdomains &&
+			old.cookie.Path == entry.cookie.Path && old.cookie.Name == entry.cookie.Name {
+			changed = old.String() != entry.String()
+			jar.cookies = append(jar.cookies[:i], jar.cookies[i+1:]...)
+			break
+		}
+	}
+	if !entry.expired(now) {
+		jar.cookies = append(jar.cookies, entry)
+	}
+	return changed
+}
+
+// Format a cookie as a line of a Netscape cookies file
+func (entry jarCookie) String() string {
+	domain, subdomains := entry.host, "FALSE"
+	if entry.subdomains {
+		domain, subdomains = "."+entry.host, "TRUE"
+	}
+	if entry.cookie.HttpOnly {
+		domain = "#HttpOnly_" + domain
+	}
+	secure := "FALSE"
+	if entry.cookie.Secure {
+		secure = "TRUE"
+	}
+	var expires int64
+	if !entry.cookie.Expires.IsZero() {
+		expires = entry.cookie.Expires.Unix()
+	}
+	return strings.Join([]string{domain, subdomains, entry.cookie.Path, secure,
+		strconv.FormatInt(expires, 10), entry.cookie.Name, entry.cookie.Value}, "\t")
+}
+
+// Ask whether a cookie has expired. Session cookies never expire during a
+// crawl.
+func (entry jarCookie) expired(now time.Time) bool {
+	return !entry.cookie.Expires.IsZero() && !entry.cookie.Expires.After(now)
+}
+
+// Ask whether a cookie applies to a host
+func (entry jarCookie) matchesHost(host string) bool {
+	return host == entry.host ||
+		(entry.subdomains && strings.HasSuffix(host, "."+entry.host))
+}
+
+// Ask whether a cookie's path applies to a request path
+func cookiePathMatch(cookiePath, reqPath string) bool {
+	if !strings.HasPrefix(reqPath, cookiePath) {
+		return false
+	}
+	return len(reqPath) == len(cookiePath) || strings.HasSuffix(cookiePath, "/") ||
+		reqPath[len(cookiePath)] == '/'
+}
+
+// Get the path for a cookie set without one: the folder of the URL's path
+func defaultCookiePath(u *url.URL) string {
+	dir := path.Dir(u.EscapedPath())
+	if !strings.HasPrefix(dir, "/") {
+		return "/"
+	}
+	return dir
+}
+
+// Set up the crawler's cookie jar, restoring the cookies saved by an earlier
+// session of a resumed crawl, and saving any new cookies in the queue
+func (crawler *Crawler) initCookies() error {
+	if crawler.Cookies == nil {
+		crawler.Cookies = NewCookieJar()
+	}
+	for _, line := range crawler.queue.Cookies() {
+		if err := crawler.Cookies.load(line); err != nil {
+			return err
+		}
+	}
+	crawler.Cookies.onSet = crawler.queue.AddCookie
+	return nil
+}
@@ -0,0 +1,190 @@
+package crawl
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCookieJar(t *testing.T) {
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+	names := func(cookies []*http.Cookie) []string {
+		var names []string
+		for _, cookie := range cookies {
+			names = append(names, cookie.Name+"="+cookie.Value)
+		}
+		return names
+	}
+
+	Convey("Given a cookie jar", t, func() {
+		jar := NewCookieJar()
+
+		Convey("When a server sets cookies", func() {
+			jar.SetCookies(parse("http://www.domain.com/docs/page.html"), []*http.Cookie{
+				{Name: "session", Value: "abc", Path: "/", Domain: "domain.com"},
+				{Name: "docs", Value: "1"},
+				{Name: "secure", Value: "2", Path: "/", Secure: true},
+			})
+
+			Convey("Then they are sent back to the same host", func() {
+				So(names(jar.Cookies(parse("http://www.domain.com/docs/other.html"))),
+					ShouldResemble, []string{"docs=1", "session=abc"})
+				So(names(jar.Cookies(parse("https://www.domain.com/"))),
+					ShouldResemble, []string{"session=abc", "secure=2"})
+			})
+
+			Convey("Then they are not sent to other hosts in the same domain", func() {
+				So(jar.Cookies(parse("http://domain.com/")), ShouldBeEmpty)
+				So(jar.Cookies(parse("http://blog.domain.com/")), ShouldBeEmpty)
+			})
+
+			Convey("Then they are only sent within their path", func() {
+				So(names(jar.Cookies(parse("http://www.domain.com/docsets/"))),
+					ShouldResemble, []string{"session=abc"})
+			})
+
+			Convey("Then the server can delete them", func() {
+				jar.SetCookies(parse("http://www.domain.com/"), []*http.Cookie{
+					{Name: "session", Path: "/", MaxAge: -1},
+				})
+				So(names(jar.Cookies(parse("http://www.domain.com/docs/"))),
+					ShouldResemble, []string{"docs=1"})
+			})
+		})
+
+		Convey("When a server sets the same cookie again", func() {
+			var lines []string
+			jar.onSet = func(line string) {
+				lines = append(lines, line)
+			}
+			site := parse("http://www.domain.com/")
+			jar.SetCookies(site, []*http.Cookie{{Name: "session", Value: "abc", Path: "/"}})
+			jar.SetCookies(site, []*http.Cookie{{Name: "session", Value: "abc", Path: "/"}})
+			jar.SetCookies(site, []*http.Cookie{{Name: "other", Path: "/", MaxAge: -1}})
+
+			Convey("Then it is only recorded when it changes", func() {
+				So(lines, ShouldResemble, []string{"www.domain.com\tFALSE\t/\tFALSE\t0\tsession\tabc"})
+				jar.SetCookies(site, []*http.Cookie{{Name: "session", Value: "def", Path: "/"}})
+				jar.SetCookies(site, []*http.Cookie{{Name: "session", Path: "/", MaxAge: -1}})
+				So(lines, ShouldResemble, []string{
+					"www.domain.com\tFALSE\t/\tFALSE\t0\tsession\tabc",
+					"www.domain.com\tFALSE\t/\tFALSE\t0\tsession\tdef",
+					"www.domain.com\tFALSE\t/\tFALSE\t1\tsession\t",
+				})
+			})
+		})
+	})
+
+	Convey("Given a Netscape cookies file", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		path := filepath.Join(folder, "cookies.txt")
+		So(ioutil.WriteFile(path, []byte(`# Netscape HTTP Cookie File
+
+.domain.com	TRUE	/	FALSE	0	site	1
+#HttpOnly_www.domain.com	FALSE	/	TRUE	4102444800	login	2
+www.domain.com	FALSE	/	FALSE	1	expired	3
+`), 0644), ShouldBeNil)
+
+		Convey("When I load the file", func() {
+			jar, err := LoadCookies(path)
+			So(err, ShouldBeNil)
+
+			Convey("Then domain cookies apply to the domain and its subdomains", func() {
+				So(names(jar.Cookies(parse("http://domain.com/"))), ShouldResemble, []string{"site=1"})
+				So(names(jar.Cookies(parse("http://blog.domain.com/"))), ShouldResemble, []string{"site=1"})
+				So(jar.Cookies(parse("http://otherdomain.com/")), ShouldBeEmpty)
+			})
+
+			Convey("Then host cookies apply to their host", func() {
+				So(names(jar.Cookies(parse("https://www.domain.com/"))),
+					ShouldResemble, []string{"site=1", "login=2"})
+			})
+		})
+
+		Convey("When the file has an invalid line", func() {
+			So(ioutil.WriteFile(path, []byte("domain.com\tTRUE\t/\n"), 0644), ShouldBeNil)
+			_, err := LoadCookies(path)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a site which sets a session cookie when I log in", t, func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			http.Redirect(w, r, "/private", http.StatusFound)
+		})
+		mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+			if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "abc" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		})
+		srv := httptest.NewServer(mux)
+		Reset(func() {
+			srv.Close()
+		})
+		srvURL, _ := url.Parse(srv.URL)
+		login, _ := srvURL.Parse("/login")
+		private, _ := srvURL.Parse("/private")
+
+		crawler := Crawler{
+			queue:   NewQueue(),
+			limiter: NewRateLimiter(HostLimit{}, nil, 0),
+			stats:   NewStats(),
+		}
+		So(crawler.initCookies(), ShouldBeNil)
+		crawler.client = crawler.newClient()
+
+		Convey("When I crawl the login page", func() {
+			resp, _, err := crawler.get(login)
+			So(err, ShouldBeNil)
+
+			Convey("Then I follow the redirect with the cookie", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Then later pages get the cookie", func() {
+				resp, _, err := crawler.get(private)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Then a resumed crawl keeps the session", func() {
+				resumed := Crawler{
+					queue:   crawler.queue,
+					limiter: NewRateLimiter(HostLimit{}, nil, 0),
+					stats:   NewStats(),
+				}
+				So(resumed.initCookies(), ShouldBeNil)
+				resumed.client = resumed.newClient()
+				resp, _, err := resumed.get(private)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When I crawl a private page without logging in", func() {
+			resp, _, err := crawler.get(private)
+
+			Convey("Then I am refused", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+		})
+	})
+}
@@ -1,7 +1,7 @@
 // The crawl package implements a web crawler with various distinctive features.
 // The Crawler type is the main entry point into the API. To run a crawl, simply
 // create a Crawler instance with appropriate field values and invoke its Run()
-// method.
+// method, which returns statistics about the crawl.
 //
 // The queue of sites to crawl next (the "frontier") is stored in memory by
 // default, in an instance of MemQueueStorage. However, if you provide a value
@@ -10,54 +10,279 @@
 package crawl
 
 import (
-	"code.google.com/p/go.net/html"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
+// The time to wait before retrying a request the server was too busy for,
+// when the crawl has no fetch delay
+var minRetryBackoff = time.Second
+
 // A single crawling session
 type Crawler struct {
 
-	// The root URL for the crawl
-	Seed *url.URL
+	// The URLs from which the crawl begins, which also define its scope
+	Seeds []Seed
+
+	// Whether each seed's scope is only the folder containing it, rather
+	// than its whole host
+	FolderScope bool
 
-	// The folder to which crawled files should be stored
+	// The folder to which crawled files should be stored, or "" to crawl
+	// without saving them
 	Folder string
 
-	// The maximum recursion depth
+	// Sends the crawl's requests (default an http.Client using Cookies). Use
+	// a MirrorFetcher or WARCFetcher to crawl offline.
+	Fetcher Fetcher
+
+	// The cookies to send with the crawl's requests. Cookies set during the
+	// crawl are added, and saved in the Resume file. Cookies are only used
+	// when Fetcher is nil.
+	Cookies *CookieJar
+
+	// Credentials to send to each host, keyed by host. Credentials are never
+	// sent to other hosts, even when a request is redirected.
+	Credentials map[string]Credential
+
+	// A login form to submit before the crawl begins, or nil for none
+	Login *FormLogin
+
+	// The User-Agent header to send, or "" for Go's default
+	UserAgent string
+
+	// Headers to send with every request
+	Headers http.Header
+
+	// Headers to send to each host, keyed by host, which replace any of the
+	// same name in Headers
+	HostHeaders map[string]http.Header
+
+	// The proxy and TLS settings for requests
+	Connection Connection
+
+	// Per-host replacements for Connection, keyed by host
+	HostConnections map[string]Connection
+
+	// Limits on how long a request may take
+	Timeouts Timeouts
+
+	// Hosts to connect to directly rather than through a proxy
+	NoProxy []string
+
+	// The maximum recursion depth, counted from the seed each page was
+	// reached from
 	MaxDepth int
 
+	// The maximum number of links in a row to follow to pages outside the
+	// seeds' scopes, such as 1 to fetch the pages a site links to on other
+	// sites but not the pages they link to, or 0 for none
+	MaxHops int
+
+	// Whether to fetch page requisites, the images, scripts, stylesheets and
+	// other resources the crawled pages use, whatever the pages' depth
+	Requisites bool
+
+	// Stop the crawl once this many pages have been fetched in this session,
+	// or 0 for no limit
+	MaxPages int
+
+	// Stop the crawl once this many bytes have been downloaded in this
+	// session, or 0 for no limit. The page being downloaded when the limit is
+	// reached is cut off, and left for a resumed crawl.
+	MaxBytes int64
+
+	// Limits on the pages crawled from parts of the web, counted across
+	// resumed sessions. Only pages fetched successfully count; a failed
+	// request or an error status doesn't. A page covered by a quota which is
+	// used up is skipped.
+	Quotas []Quota
+
+	// Limits which keep the crawl out of crawler traps. URLs which look like
+	// traps are skipped, and the links in pages nearly identical to many
+	// others are not followed.
+	Traps TrapLimits
+
 	// The file at which to load/save session resume info
 	Resume string
 
-	// Whether to crawl using the Internet Wayback Machine
-	UseWayback bool
+	// Whether to fetch pages from the live site, the Internet Wayback
+	// Machine, or one with the other as a fallback
+	Source SourcePolicy
 
-	// Crawl the latest page that occurs within this date range
+	// Crawl pages archived within this date range
 	WaybackBefore, WaybackAfter time.Time
 
+	// How to choose which capture of each page to crawl
+	WaybackStrategy CaptureStrategy
+
+	// The target time for CaptureClosest and CaptureCoherent
+	WaybackAt time.Time
+
+	// The URL prefix of the archive from which to crawl (default
+	// DefaultWaybackArchive)
+	WaybackArchive string
+
+	// Memento archives to crawl from instead of WaybackArchive, in order of
+	// preference: each page comes from the first with a capture of it in the
+	// date range
+	Archives []MementoArchive
+
+	// Whether to also queue every page archived within the seeds' scopes and
+	// the date range, as listed by the CDX server, so pages no longer linked
+	// from any other page are crawled too
+	WaybackEnumerate bool
+
+	// The CDX server which lists the archived pages (default
+	// DefaultCDXEndpoint)
+	WaybackCDX string
+
+	// Which archived pages to queue when enumerating
+	WaybackFilter CDXFilter
+
 	// The delay between successive requests to the same URL
 	FetchDelay time.Duration
 
+	// The number of pages to fetch at once
+	Workers int
+
+	// The maximum number of simultaneous requests to a single host
+	HostConcurrency int
+
+	// Per-host overrides of the request rate and concurrency limits
+	HostLimits map[string]HostLimit
+
+	// The maximum bytes per second to download across all hosts
+	Bandwidth int64
+
+	// The number of times to retry a request when the server asks us to slow
+	// down. Each retry waits for the server's Retry-After, or at least
+	// FetchDelay (or a second without one), doubled on each retry.
+	Retries int
+
+	// How to store pages whose content duplicates an already-saved page
+	Dedup DedupMode
+
+	// Whether to skip parsing links from pages with duplicate content
+	DedupSkipParse bool
+
+	// Whether to check links: links outside the crawl's scope are requested
+	// but not crawled, and the result for every link is recorded in the
+	// crawl's statistics
+	CheckLinks bool
+
+	// A function to call periodically with the crawl's progress, and once
+	// more when it finishes
+	OnProgress func(Progress)
+
+	// How often to call OnProgress (default 1 second)
+	ProgressInterval time.Duration
+
+	// The address on which to serve the crawl's status and control
+	// endpoints while it runs, or "" for none
+	ControlAddr string
+
+	// A file to which the links found during the crawl are written when it
+	// finishes, or "" for none. Links from earlier sessions of a resumed
+	// crawl are included. Links are only recorded when there is a Graph
+	// file, so they don't take up memory or space in the resume file.
+	Graph string
+
+	// The format of the Graph file
+	GraphFormat GraphFormat
+
+	// A file to which the crawl's metrics are written periodically in the
+	// Prometheus text format, or "" for none
+	MetricsFile string
+
+	// A file to which a line of JSON is added for each page saved, giving its
+	// path, its URL, which source it came from and when it was archived, or
+	// "" for none
+	Manifest string
+
 	// The crawler's queue
-	queue CrawlQueue
+	queue *CrawlQueue
+
+	// The client which sends requests with the crawl's cookies
+	client *http.Client
 
-	// The most recent time we accessed each recent domain
-	recentDomains map[string]time.Time
+	// Fetches pages from the archive, when crawling from the Wayback Machine
+	wayback *WaybackFetcher
+
+	// Records the pages saved
+	manifest *manifestWriter
+
+	// The crawler's rate limits
+	limiter *RateLimiter
+
+	// The pages counted against the crawler's quotas, or nil for none
+	quotas *quotaState
+
+	// The pages and bytes left under MaxPages and MaxBytes, or nil for no
+	// limits
+	budget *crawlBudget
+
+	// The URLs and pages seen by the trap heuristics
+	traps *trapState
+
+	// The crawler's statistics
+	stats *Stats
+
+	// The page each worker is fetching
+	workers *workerPages
+
+	// Pauses and stops the crawl
+	control *controlState
+
+	// The links found when checking links
+	checker *linkChecker
 }
 
-// Run the crawl
-func (crawler *Crawler) Run() {
+// Run the crawl, returning its statistics
+func (crawler *Crawler) Run() (*Stats, error) {
 	defer crawler.cleanup()
 	if err := crawler.init(); err != nil {
-		fmt.Println("Could not initialize the crawl - ", err)
-		return
+		return nil, fmt.Errorf("Could not initialize the crawl - %v", err)
+	}
+	if crawler.ControlAddr != "" {
+		listener, err := net.Listen("tcp", crawler.ControlAddr)
+		if err != nil {
+			return nil, fmt.Errorf("Could not start the control server - %v", err)
+		}
+		server := &http.Server{Handler: crawler.controlHandler()}
+		go server.Serve(listener)
+		defer server.Close()
+	}
+	if crawler.Login != nil {
+		if err := crawler.login(); err != nil {
+			return nil, fmt.Errorf("Could not log in - %v", err)
+		}
+	}
+	if crawler.WaybackEnumerate && !crawler.queue.DidResume {
+		if err := crawler.enumerate(); err != nil {
+			return nil, fmt.Errorf("Could not list the archived pages - %v", err)
+		}
 	}
 	crawler.crawl()
+	crawler.stats.finish()
+	if crawler.Graph != "" {
+		if err := crawler.writeGraph(); err != nil {
+			os.Stderr.WriteString("Could not write the link graph to " +
+				crawler.Graph + " - " + err.Error() + "\n")
+		}
+	}
+	if crawler.CheckLinks {
+		crawler.stats.Links = crawler.checker.checked()
+	}
+	return crawler.stats, nil
 }
 
 // Initialize a new crawl
@@ -71,99 +296,425 @@ func (crawler *Crawler) init() error {
 		}
 	}
 
+	// Pick up the pages counted against the quotas in earlier sessions
+	crawler.quotas = nil
+	if len(crawler.Quotas) > 0 {
+		crawler.quotas = newQuotaState(crawler.Quotas, crawler.queue.QuotaPages(),
+			crawler.queue.AddQuotaPage)
+	}
+
+	// Set up the rate limits, which the clients below send requests within
+	crawler.limiter = crawler.newRateLimiter()
+
+	// Set up the cookies and credentials
+	if err := crawler.initCookies(); err != nil {
+		return err
+	}
+	crawler.client = crawler.newClient()
+	if crawler.Source.UsesWayback() {
+		crawler.wayback = crawler.newWaybackFetcher()
+	}
+	if crawler.Manifest != "" {
+		var err error
+		if crawler.manifest, err = openManifest(crawler.Manifest); err != nil {
+			return err
+		}
+	}
+
+	// Start counting
+	crawler.budget = nil
+	if crawler.MaxPages > 0 || crawler.MaxBytes > 0 {
+		crawler.budget = newCrawlBudget(crawler.MaxPages, crawler.MaxBytes)
+	}
+	crawler.traps = newTrapState(crawler.Traps)
+	crawler.stats = NewStats()
+	crawler.control = newControlState()
+	crawler.checker = newLinkChecker()
+
 	return nil
 }
 
+// Get the time to wait before retrying a request the server was too busy
+// for: its Retry-After, or at least the fetch delay, or minRetryBackoff
+// without one, doubled for each earlier retry
+func (crawler *Crawler) retryBackoff(attempt int, retryAfter time.Duration) time.Duration {
+	backoff := crawler.FetchDelay
+	if backoff <= 0 {
+		backoff = minRetryBackoff
+	}
+	backoff <<= uint(attempt)
+	if retryAfter > backoff {
+		return retryAfter
+	}
+	return backoff
+}
+
+// Create a rate limiter for the crawler's limits
+func (crawler *Crawler) newRateLimiter() *RateLimiter {
+	var rate float64
+	if crawler.FetchDelay > 0 {
+		rate = float64(time.Second) / float64(crawler.FetchDelay)
+	}
+	return NewRateLimiter(HostLimit{
+		Rate:        rate,
+		Concurrency: crawler.HostConcurrency,
+	}, crawler.HostLimits, crawler.Bandwidth)
+}
+
 // Clean up after a crawl
 func (crawler *Crawler) cleanup() {
-	if crawler.queue.Storage != nil {
+	if crawler.queue != nil && crawler.queue.Storage != nil {
 		crawler.queue.Storage.Close()
 	}
+	if crawler.manifest != nil {
+		crawler.manifest.Close()
+	}
 }
 
 // Run a crawl
 func (crawler *Crawler) crawl() {
 
-	// If we're not resuming a prior crawl, start with the seed
+	// If we're not resuming a prior crawl, start with the seeds
 	if !crawler.queue.DidResume {
-		crawler.queue.Add(crawler.Seed, 1)
+		for _, seed := range crawler.seeds() {
+			crawler.queue.Add(QueueItem{URL: seed.URL, Depth: 1, Seed: seed.URL})
+		}
 	}
 
 	// Crawl the frontier
-	for {
-		next, depth := crawler.queue.Next()
-		if next == nil {
+	workers := crawler.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	crawler.workers = newWorkerPages(workers)
+	stop := make(chan struct{})
+	var reporters []chan struct{}
+	if crawler.OnProgress != nil {
+		stopped := make(chan struct{})
+		go crawler.reportProgress(stop, stopped)
+		reporters = append(reporters, stopped)
+	}
+	if crawler.MetricsFile != "" {
+		stopped := make(chan struct{})
+		go crawler.exportMetrics(stop, stopped)
+		reporters = append(reporters, stopped)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for crawler.control.wait() {
+				next, ok := crawler.queue.Next()
+				if !ok {
+					return
+				}
+				crawler.workers.set(worker, next.URL)
+				if next.Check {
+					crawler.checkLink(next.URL)
+				} else {
+					crawler.fetch(next, crawler.Folder != "")
+				}
+				crawler.workers.set(worker, nil)
+				crawler.queue.Done()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	for _, stopped := range reporters {
+		<-stopped
+	}
+}
+
+// Fetch a page in the frontier, saving it to the crawl folder if asked to
+func (crawler *Crawler) fetch(item QueueItem, save bool) {
+	next := item.URL
+	if !crawler.budget.take() {
+		crawler.leave(item)
+		return
+	} else if !crawler.quotas.take(next) {
+		crawler.budget.settle(false)
+		crawler.skip(next, SkipQuota)
+		return
+	}
+
+	// Fetch the URL, retrying if the server asks us to slow down
+	var (
+		resp *http.Response
+		body []byte
+		err  error
+	)
+	for attempt := 0; ; attempt++ {
+		resp, body, err = crawler.get(next)
+		if err != nil || !TooBusy(resp.StatusCode) || attempt >= crawler.Retries {
 			break
 		}
-		crawler.fetch(next, depth, nil)
+		crawler.stats.retried()
+		time.Sleep(crawler.retryBackoff(attempt, RetryAfter(resp)))
+	}
+	if err == errMaxBytes {
+		crawler.budget.settle(false)
+		crawler.quotas.settle(next, false)
+		crawler.leave(item)
+		return
+	}
+	if crawler.CheckLinks {
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		crawler.checker.setResult(next, status, err)
+	}
+	fetched := err == nil && resp.StatusCode < 400
+	crawler.quotas.settle(next, fetched)
+	crawler.budget.settle(fetched)
+	if crawler.budget.reached() {
+		crawler.stop()
+	}
+	if err != nil {
+		crawler.stats.failed(next, failReason(err), err)
+		os.Stderr.WriteString("Could not fetch " + next.String() +
+			" - " + err.Error() + "\n")
+		return
+	}
+	crawler.stats.fetched(next, item.Depth, resp.StatusCode, len(body))
+	if resp.StatusCode >= 400 {
+		return
+	}
+
+	// Save the page, and look for new links
+	var dup bool
+	if save {
+		dup = crawler.save(next, resp, body)
+	}
+	if item.Requisite || (dup && crawler.DedupSkipParse) {
+		return
+	}
+	if item.Depth < crawler.maxDepth(item) || crawler.Requisites {
+		if crawler.traps.similar(next, resp.Header.Get("Content-Type"), body) {
+			crawler.stats.skipped(SkipSimilarPage)
+			return
+		}
+		crawler.parseLinks(item, UTF8Reader(body, resp.Header.Get("Content-Type")))
 	}
 }
 
-// Fetch a URL
-func (crawler *Crawler) fetch(next *url.URL, depth int, save io.Writer) {
+// Leave a page the crawl has no budget left for in the frontier, so a
+// resumed crawl fetches it, and stop the crawl
+func (crawler *Crawler) leave(item QueueItem) {
+	crawler.queue.Add(item)
+	crawler.stop()
+}
 
-	// Wait, if we need to
-	if crawler.FetchDelay > 0 {
-		noWait := time.Now().Add(-crawler.FetchDelay)
-		if crawler.recentDomains == nil {
-			crawler.recentDomains = make(map[string]time.Time)
-		}
-		lastTime, ok := crawler.recentDomains[next.Host]
-		if ok && lastTime.After(noWait) {
-			for key, val := range crawler.recentDomains {
-				if val.Before(noWait) {
-					delete(crawler.recentDomains, key)
-				}
+// Send a GET request within the rate limits, and read the response body,
+// decompressing it if the server compressed it and cleaning it up if it
+// came from an archive. The body is cut off with errMaxBytes once the crawl
+// has downloaded as many bytes as it may.
+func (crawler *Crawler) get(site *url.URL) (*http.Response, []byte, error) {
+	req, err := crawler.newRequest("GET", site)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, watch := crawler.Timeouts.watch(req)
+	defer watch.close()
+
+	start := time.Now()
+	resp, err := crawler.fetcher().Do(req)
+	if err != nil {
+		crawler.stats.request(site.Host, 0, time.Since(start))
+		return nil, nil, watch.error(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(crawler.limiter.Reader(req.Context(), crawler.budget.reader(watch.body(resp.Body))))
+	crawler.stats.request(site.Host, resp.StatusCode, time.Since(start))
+	if err != nil {
+		return resp, body, watch.error(err)
+	}
+
+	// Decompress the body, so pages are saved and parsed as plain content
+	if body, err = DecodeContent(body, resp.Header.Get("Content-Encoding")); err != nil {
+		return resp, nil, err
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Uncompressed = true
+
+	// Undo an archive's changes to the page, so it's saved and parsed as it
+	// was on the live site
+	if !capturedAt(resp).IsZero() && resp.Request != nil {
+		body = CleanArchived(body, resp.Request.URL, resp.Header.Get("Content-Type"))
+	}
+	return resp, body, nil
+}
+
+// Send a request within the rate limits, and get the response's status
+// without reading the body
+func (crawler *Crawler) requestStatus(method string, site *url.URL) (int, error) {
+	req, err := crawler.newRequest(method, site)
+	if err != nil {
+		return 0, err
+	}
+	req, watch := crawler.Timeouts.watch(req)
+	defer watch.close()
+
+	start := time.Now()
+	resp, err := crawler.fetcher().Do(req)
+	if err != nil {
+		crawler.stats.request(site.Host, 0, time.Since(start))
+		return 0, watch.error(err)
+	}
+	resp.Body.Close()
+	crawler.stats.request(site.Host, resp.StatusCode, time.Since(start))
+	return resp.StatusCode, nil
+}
+
+// Create a request with the crawl's headers and the credentials for its host
+func (crawler *Crawler) newRequest(method string, site *url.URL) (*http.Request, error) {
+	req, err := http.NewRequest(method, site.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	crawler.setHeaders(req)
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	crawler.authorize(req)
+	return req, nil
+}
+
+// Save a page to the crawl folder, returning whether its content duplicated
+// that of a page we already saved. A page from an archive is given the
+// modification time of its capture.
+func (crawler *Crawler) save(site *url.URL, resp *http.Response, body []byte) (dup bool) {
+	captured := capturedAt(resp)
+	path := LocalPath(site)
+	full := filepath.Join(crawler.Folder, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0777); err != nil {
+		os.Stderr.WriteString("Could not save " + site.String() + " - " + err.Error())
+		return false
+	}
+
+	// Link to an earlier copy of the same content, if we have one
+	var digest string
+	if crawler.Dedup != DedupNone {
+		digest = ContentDigest(body)
+		if saved, ok := crawler.queue.Digest(digest); ok && saved != path {
+			err := crawler.Dedup.link(filepath.Join(crawler.Folder, saved), full)
+			if err == nil {
+				crawler.stats.skipped(SkipDuplicate)
+				crawler.record(path, site, resp)
+				return true
 			}
-			time.Sleep(crawler.FetchDelay - time.Now().Sub(lastTime))
+			os.Stderr.WriteString("Could not link " + site.String() + " to " +
+				saved + " - " + err.Error())
 		}
-		crawler.recentDomains[next.Host] = time.Now()
 	}
 
-	// Fetch the URL
-	if resp, err := http.Get(next.String()); err != nil {
-		os.Stderr.WriteString("Could not fetch " + next.String() +
-			" - " + err.Error())
-	} else {
-		if depth < crawler.MaxDepth {
-			r := io.Reader(resp.Body)
-			if save != nil {
-				r = io.TeeReader(resp.Body, save)
-			}
-			crawler.parseLinks(next, r, depth+1)
-		} else if save != nil {
-			if _, err := io.Copy(save, resp.Body); err != nil {
-				os.Stderr.WriteString("Could not save " + next.String() + " - " + err.Error())
-			}
+	// Save a new copy, replacing any link left at the path by an earlier save
+	// rather than writing through it to the copy it shares
+	if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+		os.Stderr.WriteString("Could not save " + site.String() + " - " + err.Error())
+		return false
+	}
+	if err := ioutil.WriteFile(full, body, 0644); err != nil {
+		os.Stderr.WriteString("Could not save " + site.String() + " - " + err.Error())
+		return false
+	}
+	if !captured.IsZero() {
+		os.Chtimes(full, captured, captured)
+	}
+	if digest != "" {
+		crawler.queue.AddDigest(digest, path)
+	}
+	crawler.stats.saved()
+	crawler.record(path, site, resp)
+	return false
+}
+
+// Add a saved page to the manifest, if there is one
+func (crawler *Crawler) record(path string, site *url.URL, resp *http.Response) {
+	if crawler.manifest == nil {
+		return
+	}
+	if err := crawler.manifest.add(path, site, sourceOf(resp), capturedAt(resp)); err != nil {
+		os.Stderr.WriteString("Could not add " + site.String() + " to the manifest - " +
+			err.Error() + "\n")
+	}
+}
+
+// Parse a page, recording its links for the Graph file if there is one, and
+// adding any new URLs it contains to the frontier. Links are followed up to the page's maximum depth, and its
+// requisites are fetched whatever its depth if the crawl wants them.
+func (crawler *Crawler) parseLinks(page QueueItem, body io.Reader) {
+	source := page.URL
+	follow := page.Depth < crawler.maxDepth(page)
+	links, err := ParseLinks(source, body)
+	if err != nil {
+		os.Stderr.WriteString("Could not parse " + source.String() +
+			" - " + err.Error() + "\n")
+	}
+	for _, link := range links {
+		if crawler.Graph != "" {
+			crawler.queue.AddEdge(NewEdge(source, link))
 		}
-		resp.Body.Close()
-	}
-}
-
-// Parse a page, adding any new URLs it contains to the frontier
-func (crawler *Crawler) parseLinks(source *url.URL, body io.Reader, depth int) {
-	tok := html.NewTokenizer(body)
-	parsing := true
-	for parsing {
-		switch tok.Next() {
-		case html.ErrorToken:
-			os.Stderr.WriteString("Could not parse " + source.String())
-			parsing = false
-
-		case html.StartTagToken:
-			tag, more := tok.TagName()
-			if string(tag) == "a" {
-				var key, val []byte
-				for more {
-					key, val, more = tok.TagAttr()
-					if string(key) == "href" {
-						more = false
-						if site, err := source.Parse(string(val)); err == nil {
-							crawler.queue.Add(site, depth)
-						}
-					}
-				}
-			}
+		if crawler.wayback != nil {
+			crawler.wayback.Linked(source, link.URL)
+		}
+		if link.Followed() && follow {
+			crawler.enqueue(page, link, false)
+		} else if !link.Followed() && crawler.Requisites {
+			crawler.enqueue(page, link, true)
 		}
 	}
 }
+
+// Add a link to the frontier, if it is within the crawl's scope or few
+// enough links in a row have left the scope. When checking links, links
+// outside the scope are queued to be checked instead, and each page is only
+// queued once.
+func (crawler *Crawler) enqueue(page QueueItem, link Link, requisite bool) {
+	source := page.URL
+	item := QueueItem{
+		URL:       link.URL,
+		Depth:     page.Depth + 1,
+		Seed:      page.Seed,
+		Requisite: requisite,
+	}
+	_, inScope := crawler.seedFor(link.URL)
+	if !inScope {
+		item.Hops = page.Hops + 1
+	}
+	follow := inScope || item.Hops <= crawler.MaxHops
+	if crawler.CheckLinks {
+		if !checkable(link.URL) {
+			return
+		}
+		link.URL = linkTarget(link.URL)
+		item.URL = link.URL
+		if !crawler.checker.addLink(source, link) {
+			return
+		}
+		if !follow {
+			item.Check = true
+			crawler.queue.Add(item)
+		}
+	}
+	if !follow {
+		crawler.stats.skipped(SkipOutOfScope)
+	} else if reason := crawler.traps.check(item.URL); reason != "" {
+		crawler.skip(item.URL, reason)
+	} else if crawler.quotas.exhausted(item.URL) {
+		crawler.skip(item.URL, SkipQuota)
+	} else {
+		crawler.queue.Add(item)
+	}
+}
+
+// Count a page which is skipped rather than crawled, and when checking
+// links, report why it was skipped
+func (crawler *Crawler) skip(site *url.URL, reason string) {
+	crawler.stats.skipped(reason)
+	if crawler.CheckLinks {
+		crawler.checker.setSkipped(site, reason)
+	}
+}
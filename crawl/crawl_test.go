@@ -5,9 +5,13 @@ import (
 	"code.google.com/p/gomock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -41,10 +45,21 @@ var (
 )
 
 type Handler struct {
-	Next string
+	Next     string
+	Busy     int
+	Status   int
+	Requests int32
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&h.Requests, 1)
+	if h.Status != 0 {
+		w.WriteHeader(h.Status)
+	} else if h.Busy > 0 {
+		h.Busy--
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
 	buff := bytes.NewBufferString(h.Next)
 	io.Copy(w, buff)
 }
@@ -78,26 +93,63 @@ func TestCrawler(t *testing.T) {
 		Reset(func() {
 			ctrl.Finish()
 		})
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		saved := func(site *url.URL) string {
+			content, _ := ioutil.ReadFile(filepath.Join(folder, LocalPath(site)))
+			return string(content)
+		}
+
 		crawler := Crawler{
+			Folder:   folder,
 			MaxDepth: 5,
 			queue:    NewQueue(),
+			limiter:  NewRateLimiter(HostLimit{}, nil, 0),
+			stats:    NewStats(),
+			control:  newControlState(),
 		}
 		crawler.queue.Storage = storage
+		storage.EXPECT().AddEdge(gomock.Any()).AnyTimes()
 
 		Convey("When I fetch a page I want to save", func() {
 
 			// Then I add the links
 			gomock.InOrder(
-				storage.EXPECT().Add(ABS_LINKS[0], 2),
-				storage.EXPECT().Add(ABS_LINKS[1], 2),
+				storage.EXPECT().Add(QueueItem{URL: ABS_LINKS[0], Depth: 2}),
+				storage.EXPECT().Add(QueueItem{URL: ABS_LINKS[1], Depth: 2}),
 			)
 
-			buff := bytes.Buffer{}
 			handler.Next = ABS_LINK_PAGE
-			crawler.fetch(srvURL, 1, &buff)
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, true)
 
 			Convey("Then I save the page", func() {
-				So(string(buff.Bytes()), ShouldEqual, ABS_LINK_PAGE)
+				So(saved(srvURL), ShouldEqual, ABS_LINK_PAGE)
+			})
+
+			Convey("Then I count the page", func() {
+				So(crawler.stats.Fetched, ShouldEqual, 1)
+				So(crawler.stats.Saved, ShouldEqual, 1)
+				So(crawler.stats.Bytes, ShouldEqual, int64(len(ABS_LINK_PAGE)))
+				So(crawler.stats.Hosts, ShouldResemble, map[string]int{srvURL.Host: 1})
+				So(crawler.stats.Depths, ShouldResemble, map[int]int{1: 1})
+			})
+		})
+
+		Convey("When I fetch a page which doesn't exist", func() {
+			handler.Next = ABS_LINK_PAGE
+			handler.Status = http.StatusNotFound
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, true)
+
+			Convey("Then I count the failure", func() {
+				So(crawler.stats.Fetched, ShouldEqual, 0)
+				So(crawler.stats.Failed, ShouldResemble, map[string]int{"404": 1})
+			})
+
+			Convey("Then I don't save the page", func() {
+				So(saved(srvURL), ShouldEqual, "")
 			})
 		})
 
@@ -105,35 +157,37 @@ func TestCrawler(t *testing.T) {
 
 			// Then I add the links
 			gomock.InOrder(
-				storage.EXPECT().Add(ABS_LINKS[0], 2),
-				storage.EXPECT().Add(ABS_LINKS[1], 2),
+				storage.EXPECT().Add(QueueItem{URL: ABS_LINKS[0], Depth: 2}),
+				storage.EXPECT().Add(QueueItem{URL: ABS_LINKS[1], Depth: 2}),
 			)
 
 			handler.Next = ABS_LINK_PAGE
-			crawler.fetch(srvURL, 1, nil)
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, false)
+
+			Convey("Then I don't save the page", func() {
+				So(saved(srvURL), ShouldEqual, "")
+			})
 		})
 
 		Convey("When I fetch a page at the maximum depth", func() {
 
 			// Then I don't add the links
-			buff := bytes.Buffer{}
 			handler.Next = ABS_LINK_PAGE
-			crawler.fetch(srvURL, crawler.MaxDepth, &buff)
+			crawler.fetch(QueueItem{URL: srvURL, Depth: crawler.MaxDepth}, true)
 
 			Convey("Then I save the page", func() {
-				So(string(buff.Bytes()), ShouldEqual, ABS_LINK_PAGE)
+				So(saved(srvURL), ShouldEqual, ABS_LINK_PAGE)
 			})
 		})
 
 		Convey("When I parse a page with no links", func() {
 
 			// Then I don't add the links
-			buff := bytes.Buffer{}
 			handler.Next = NO_LINK_PAGE
-			crawler.fetch(srvURL, 1, &buff)
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, true)
 
 			Convey("Then I save the page", func() {
-				So(string(buff.Bytes()), ShouldEqual, NO_LINK_PAGE)
+				So(saved(srvURL), ShouldEqual, NO_LINK_PAGE)
 			})
 		})
 
@@ -141,22 +195,57 @@ func TestCrawler(t *testing.T) {
 
 			// Then I add the links
 			gomock.InOrder(
-				storage.EXPECT().Add(REL_LINKS[0], 2),
-				storage.EXPECT().Add(REL_LINKS[1], 2),
-				storage.EXPECT().Add(REL_LINKS[2], 2),
+				storage.EXPECT().Add(QueueItem{URL: REL_LINKS[0], Depth: 2}),
+				storage.EXPECT().Add(QueueItem{URL: REL_LINKS[1], Depth: 2}),
+				storage.EXPECT().Add(QueueItem{URL: REL_LINKS[2], Depth: 2}),
 			)
 
 			handler.Next = REL_LINK_PAGE
-			crawler.fetch(srvURL, 1, nil)
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, false)
+		})
+
+		Convey("When I parse a page without writing a link graph", func() {
+			mem := NewMemQueueStorage()
+			crawler.queue.Storage = mem
+			handler.Next = `<a href="page.html">A page</a><img src="logo.png" alt="Logo">`
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, false)
+
+			Convey("Then I don't record the links", func() {
+				So(mem.Links, ShouldBeEmpty)
+				So(mem.Len(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I parse a page with links to pages and resources", func() {
+			mem := NewMemQueueStorage()
+			crawler.queue.Storage = mem
+			crawler.Graph = "links.csv"
+			handler.Next = `<a href="page.html">A page</a><img src="logo.png" alt="Logo">`
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, false)
+
+			Convey("Then I record every link for the graph", func() {
+				page, _ := srvURL.Parse("page.html")
+				logo, _ := srvURL.Parse("logo.png")
+				So(mem.Links, ShouldResemble, []Edge{
+					{Source: srvURL, Target: page, Tag: "a", Text: "A page"},
+					{Source: srvURL, Target: logo, Tag: "img", Text: "Logo"},
+				})
+			})
+
+			Convey("Then I only crawl the linked page", func() {
+				So(mem.Len(), ShouldEqual, 1)
+				So(mem.Items[0].URL.String(), ShouldEqual, srvURL.String()+"/page.html")
+			})
 		})
 
 		Convey("When I fetch a page I need to wait for", func() {
 			handler.Next = NO_LINK_PAGE
 			crawler.FetchDelay = time.Millisecond * 250
-			crawler.recentDomains = make(map[string]time.Time)
-			crawler.recentDomains[srvURL.Host] = time.Now().Add(-time.Millisecond * 100)
+			crawler.limiter = crawler.newRateLimiter()
+			crawler.limiter.Acquire(srvURL.Host)()
+			time.Sleep(time.Millisecond * 100)
 			before := time.Now()
-			crawler.fetch(srvURL, 1, nil)
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, false)
 			after := time.Now()
 
 			Convey("Then I wait for the delay period", func() {
@@ -167,13 +256,407 @@ func TestCrawler(t *testing.T) {
 		Convey("When I fetch a page I don't need to wait for", func() {
 			handler.Next = NO_LINK_PAGE
 			crawler.FetchDelay = time.Millisecond * 250
+			crawler.limiter = crawler.newRateLimiter()
 			before := time.Now()
-			crawler.fetch(srvURL, 1, nil)
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, false)
 			after := time.Now()
 
 			Convey("Then I don't wait for the delay period", func() {
 				So(after.Sub(before), ShouldBeLessThan, time.Millisecond)
 			})
 		})
+
+		Convey("When I fetch a page from a server that asks me to slow down", func() {
+			handler.Next = NO_LINK_PAGE
+			handler.Busy = 2
+			crawler.Retries = 1
+			crawler.FetchDelay = time.Millisecond * 10
+			crawler.limiter = crawler.newRateLimiter()
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, true)
+
+			Convey("Then I retry the request", func() {
+				So(handler.Requests, ShouldEqual, int32(2))
+			})
+
+			Convey("Then I slow down subsequent requests", func() {
+				before := time.Now()
+				crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, true)
+				after := time.Now()
+				So(after.Sub(before), ShouldBeGreaterThan, 30*time.Millisecond)
+				So(saved(srvURL), ShouldEqual, NO_LINK_PAGE)
+			})
+		})
+
+		Convey("When a server with a high rate limit asks me to slow down", func() {
+			handler.Next = NO_LINK_PAGE
+			handler.Busy = 2
+			crawler.Retries = 2
+			crawler.limiter = NewRateLimiter(HostLimit{Rate: 1000}, nil, 0)
+			backoff := minRetryBackoff
+			minRetryBackoff = 20 * time.Millisecond
+			Reset(func() {
+				minRetryBackoff = backoff
+			})
+			before := time.Now()
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, true)
+			after := time.Now()
+
+			Convey("Then I back off before each retry", func() {
+				So(handler.Requests, ShouldEqual, int32(3))
+				So(after.Sub(before), ShouldBeGreaterThanOrEqualTo, 60*time.Millisecond)
+				So(saved(srvURL), ShouldEqual, NO_LINK_PAGE)
+			})
+		})
+
+		Convey("When I fetch a page with links outside the seeds' hosts", func() {
+			seedURL, _ := srvURL.Parse("/some/start.html")
+			crawler.Seeds = []Seed{{URL: seedURL}}
+
+			// Then I add the links on the seed's host
+			sibling, _ := seedURL.Parse("page2.html")
+			gomock.InOrder(
+				storage.EXPECT().Add(QueueItem{URL: REL_LINKS[0], Depth: 2}),
+				storage.EXPECT().Add(QueueItem{URL: REL_LINKS[1], Depth: 2}),
+				storage.EXPECT().Add(QueueItem{URL: sibling, Depth: 2}),
+			)
+
+			handler.Next = REL_LINK_PAGE + ABS_LINK_PAGE
+			crawler.fetch(QueueItem{URL: seedURL, Depth: 1}, false)
+
+			Convey("Then I count the skipped links", func() {
+				So(crawler.stats.Skipped, ShouldResemble, map[string]int{
+					SkipOutOfScope: 2,
+				})
+			})
+		})
+
+		Convey("When I fetch a page with links outside the seeds' folders", func() {
+			seedURL, _ := srvURL.Parse("/some/start.html")
+			crawler.Seeds = []Seed{{URL: seedURL}}
+			crawler.FolderScope = true
+
+			// Then I only add the links within scope
+			sibling, _ := seedURL.Parse("page2.html")
+			gomock.InOrder(
+				storage.EXPECT().Add(QueueItem{URL: REL_LINKS[0], Depth: 2}),
+				storage.EXPECT().Add(QueueItem{URL: sibling, Depth: 2}),
+			)
+
+			handler.Next = REL_LINK_PAGE + ABS_LINK_PAGE
+			crawler.fetch(QueueItem{URL: seedURL, Depth: 1}, false)
+
+			Convey("Then I count the skipped links", func() {
+				So(crawler.stats.Skipped, ShouldResemble, map[string]int{
+					SkipOutOfScope: 3,
+				})
+			})
+		})
+
+		Convey("When I fetch a page beyond its seed's maximum depth", func() {
+			deepURL, _ := srvURL.Parse("/deep/")
+			crawler.Seeds = []Seed{{URL: srvURL}, {URL: deepURL, MaxDepth: 1}}
+
+			// Then I don't add the links
+			handler.Next = REL_LINK_PAGE
+			crawler.fetch(QueueItem{URL: deepURL, Depth: 1}, false)
+		})
+
+		Convey("When I crawl with several workers", func() {
+			handler.Next = REL_LINK_PAGE
+			crawler.MaxDepth = 2
+			crawler.Seeds = []Seed{{URL: srvURL}}
+			crawler.Workers = 3
+			crawler.queue.Storage = NewMemQueueStorage()
+			var last Progress
+			crawler.OnProgress = func(progress Progress) {
+				last = progress
+			}
+			crawler.crawl()
+
+			Convey("Then I fetch every page in the frontier", func() {
+				So(handler.Requests, ShouldEqual, int32(1+len(REL_LINKS)))
+				So(saved(REL_LINKS[0]), ShouldEqual, REL_LINK_PAGE)
+			})
+
+			Convey("Then I report the final progress", func() {
+				So(last.Done, ShouldEqual, 1+len(REL_LINKS))
+				So(last.Queued, ShouldEqual, 0)
+				So(last.InFlight, ShouldEqual, 0)
+				So(last.Current, ShouldResemble, []*url.URL{nil, nil, nil})
+			})
+		})
+
+		Convey("When I fetch a page whose content I already saved", func() {
+			dupURL, _ := srvURL.Parse("/copy.html")
+			digest := ContentDigest([]byte(NO_LINK_PAGE))
+			crawler.Dedup = DedupHardLink
+			handler.Next = NO_LINK_PAGE
+
+			// Then I record the first copy's digest
+			gomock.InOrder(
+				storage.EXPECT().Digest(digest).Return("", false),
+				storage.EXPECT().AddDigest(digest, LocalPath(srvURL)),
+				storage.EXPECT().Digest(digest).Return(LocalPath(srvURL), true),
+			)
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, true)
+			crawler.fetch(QueueItem{URL: dupURL, Depth: 1}, true)
+
+			Convey("Then I link the page to the first copy", func() {
+				So(saved(dupURL), ShouldEqual, NO_LINK_PAGE)
+				first, err := os.Stat(filepath.Join(folder, LocalPath(srvURL)))
+				So(err, ShouldBeNil)
+				second, err := os.Stat(filepath.Join(folder, LocalPath(dupURL)))
+				So(err, ShouldBeNil)
+				So(os.SameFile(first, second), ShouldBeTrue)
+			})
+
+			Convey("Then I count the duplicate", func() {
+				So(crawler.stats.Saved, ShouldEqual, 1)
+				So(crawler.stats.Skipped, ShouldResemble, map[string]int{
+					SkipDuplicate: 1,
+				})
+			})
+
+			Convey("Then refetching the duplicate after it changes leaves the first copy alone", func() {
+				changed := ContentDigest([]byte("changed"))
+				gomock.InOrder(
+					storage.EXPECT().Digest(changed).Return("", false),
+					storage.EXPECT().AddDigest(changed, LocalPath(dupURL)),
+				)
+				handler.Next = "changed"
+				crawler.fetch(QueueItem{URL: dupURL, Depth: 1}, true)
+				So(saved(dupURL), ShouldEqual, "changed")
+				So(saved(srvURL), ShouldEqual, NO_LINK_PAGE)
+			})
+		})
+
+		Convey("When I fetch a page whose content I already saved and parsed", func() {
+			dupURL, _ := srvURL.Parse("/copy.html")
+			digest := ContentDigest([]byte(ABS_LINK_PAGE))
+			crawler.Dedup = DedupSymlink
+			crawler.DedupSkipParse = true
+			handler.Next = ABS_LINK_PAGE
+
+			// Then I only add the links from the first copy
+			gomock.InOrder(
+				storage.EXPECT().Digest(digest).Return("", false),
+				storage.EXPECT().AddDigest(digest, LocalPath(srvURL)),
+				storage.EXPECT().Add(QueueItem{URL: ABS_LINKS[0], Depth: 2}),
+				storage.EXPECT().Add(QueueItem{URL: ABS_LINKS[1], Depth: 2}),
+				storage.EXPECT().Digest(digest).Return(LocalPath(srvURL), true),
+			)
+			crawler.fetch(QueueItem{URL: srvURL, Depth: 1}, true)
+			crawler.fetch(QueueItem{URL: dupURL, Depth: 1}, true)
+
+			Convey("Then I link the page to the first copy", func() {
+				target, err := os.Readlink(filepath.Join(folder, LocalPath(dupURL)))
+				So(err, ShouldBeNil)
+				So(target, ShouldEqual, filepath.Base(LocalPath(srvURL)))
+				So(saved(dupURL), ShouldEqual, ABS_LINK_PAGE)
+			})
+		})
+	})
+}
+
+// Serves pages from a map keyed by path
+type pageHandler map[string]string
+
+func (pages pageHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if page, ok := pages[req.URL.Path]; ok {
+		io.WriteString(w, page)
+	} else {
+		http.NotFound(w, req)
+	}
+}
+
+func TestCrawlLimits(t *testing.T) {
+	Convey("Given a site with page requisites and external links", t, func() {
+		external := httptest.NewServer(pageHandler{
+			"/ext.html":  `<a href="/ext2.html">Further away</a>`,
+			"/ext2.html": "further away",
+		})
+		Reset(func() {
+			external.Close()
+		})
+		index := `<img src="logo.png"> <a href="page.html">Page</a> ` +
+			`<a href="` + external.URL + `/ext.html">Elsewhere</a> <a href="/other/x.html">Other</a>`
+		srv := httptest.NewServer(pageHandler{
+			"/docs/":          index,
+			"/docs/page.html": `<img src="pic.png"> <a href="deep.html">Deep</a>`,
+			"/docs/deep.html": "deep",
+			"/docs/logo.png":  "logo",
+			"/docs/pic.png":   "pic",
+			"/other/":         `<a href="x.html">X</a>`,
+			"/other/x.html":   `<a href="y.html">Y</a>`,
+			"/other/y.html":   "y",
+		})
+		Reset(func() {
+			srv.Close()
+		})
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		seed, _ := url.Parse(srv.URL + "/docs/")
+		crawler := Crawler{
+			Seeds:       []Seed{{URL: seed}},
+			FolderScope: true,
+			Folder:      folder,
+			MaxDepth:    2,
+		}
+		savedAt := func(site string) bool {
+			u, _ := url.Parse(site)
+			_, err := os.Stat(filepath.Join(folder, LocalPath(u)))
+			return err == nil
+		}
+		saved := func(path string) bool {
+			return savedAt(srv.URL + path)
+		}
+
+		Convey("When I crawl without requisites or hops", func() {
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then only pages in scope and depth are saved", func() {
+				So(saved("/docs/index.html"), ShouldBeTrue)
+				So(saved("/docs/page.html"), ShouldBeTrue)
+				So(saved("/docs/deep.html"), ShouldBeFalse)
+				So(saved("/docs/logo.png"), ShouldBeFalse)
+				So(savedAt(external.URL+"/ext.html"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When I crawl without a folder", func() {
+			crawler.Folder = ""
+			stats, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the pages are crawled without being saved", func() {
+				So(stats.Fetched, ShouldBeGreaterThan, 0)
+				So(stats.Saved, ShouldEqual, 0)
+				So(saved("/docs/index.html"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When I crawl with page requisites", func() {
+			crawler.Requisites = true
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the requisites of pages at the maximum depth are saved too", func() {
+				So(saved("/docs/logo.png"), ShouldBeTrue)
+				So(saved("/docs/pic.png"), ShouldBeTrue)
+				So(saved("/docs/deep.html"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When I follow one hop outside the scope", func() {
+			crawler.MaxHops = 1
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the external pages are saved, but not the pages they link to", func() {
+				So(savedAt(external.URL+"/ext.html"), ShouldBeTrue)
+				So(savedAt(external.URL+"/ext2.html"), ShouldBeFalse)
+				So(saved("/other/x.html"), ShouldBeTrue)
+				So(saved("/other/y.html"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When a page in another seed's scope is reached from the first seed", func() {
+			other, _ := url.Parse(srv.URL + "/other/")
+			crawler.Seeds[0].MaxDepth = 3
+			crawler.Seeds = append(crawler.Seeds, Seed{URL: other, MaxDepth: 1})
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the depth of the seed it was reached from applies", func() {
+				So(saved("/other/index.html"), ShouldBeTrue)
+				So(saved("/other/x.html"), ShouldBeTrue)
+				So(saved("/other/y.html"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I crawl at most two pages", func() {
+			crawler.MaxPages = 2
+			crawler.Resume = filepath.Join(folder, "resume.txt")
+			crawler.Requisites = true
+			stats, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the crawl stops after two pages", func() {
+				So(stats.Fetched, ShouldEqual, 2)
+				So(saved("/docs/index.html"), ShouldBeTrue)
+				So(saved("/docs/logo.png"), ShouldBeTrue)
+				So(saved("/docs/page.html"), ShouldBeFalse)
+			})
+
+			Convey("Then a resumed crawl continues where it stopped", func() {
+				crawler.MaxPages = 0
+				stats, err := crawler.Run()
+				So(err, ShouldBeNil)
+				So(stats.Fetched, ShouldEqual, 2)
+				So(saved("/docs/page.html"), ShouldBeTrue)
+				So(saved("/docs/pic.png"), ShouldBeTrue)
+				So(saved("/docs/deep.html"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When several workers crawl at most two pages", func() {
+			crawler.MaxPages = 2
+			crawler.Workers = 4
+			crawler.Resume = filepath.Join(folder, "resume.txt")
+			crawler.Requisites = true
+			stats, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the crawl fetches no more than two pages", func() {
+				So(stats.Fetched, ShouldEqual, 2)
+				So(saved("/docs/index.html"), ShouldBeTrue)
+				So(saved("/docs/logo.png") != saved("/docs/page.html"), ShouldBeTrue)
+			})
+
+			Convey("Then a resumed crawl fetches the page left over", func() {
+				crawler.MaxPages = 0
+				_, err := crawler.Run()
+				So(err, ShouldBeNil)
+				So(saved("/docs/logo.png"), ShouldBeTrue)
+				So(saved("/docs/page.html"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I crawl at most a few bytes", func() {
+			crawler.MaxBytes = int64(len(index)) + 2
+			crawler.Resume = filepath.Join(folder, "resume.txt")
+			crawler.Requisites = true
+			stats, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the crawl stops once it has downloaded them, cutting off the page at the limit", func() {
+				So(stats.Fetched, ShouldEqual, 1)
+				So(stats.Bytes, ShouldEqual, len(index))
+				So(saved("/docs/index.html"), ShouldBeTrue)
+				So(saved("/docs/logo.png"), ShouldBeFalse)
+			})
+
+			Convey("Then a resumed crawl fetches the page which was cut off", func() {
+				crawler.MaxBytes = 0
+				_, err := crawler.Run()
+				So(err, ShouldBeNil)
+				So(saved("/docs/logo.png"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I crawl exactly as many bytes as a page", func() {
+			crawler.MaxBytes = int64(len(index))
+			stats, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the page is fetched", func() {
+				So(stats.Fetched, ShouldEqual, 1)
+				So(saved("/docs/index.html"), ShouldBeTrue)
+			})
+		})
 	})
 }
@@ -0,0 +1,73 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"code.google.com/p/go.net/html/charset"
+	"code.google.com/p/go.text/transform"
+	"github.com/andybalholm/brotli"
+)
+
+// The content encodings we ask servers for, and can decode
+const acceptEncoding = "gzip, deflate, br"
+
+// Undo the content encodings of a response body, given its Content-Encoding
+// header. Encodings are listed in the order they were applied, so they are
+// removed in reverse.
+func DecodeContent(body []byte, contentEncoding string) ([]byte, error) {
+	if len(body) == 0 {
+		return body, nil
+	}
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var (
+			r   io.Reader
+			err error
+		)
+		switch encoding := strings.ToLower(strings.TrimSpace(encodings[i])); encoding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			r = inflate(body)
+		case "br":
+			r = brotli.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("Unsupported content encoding %q", encoding)
+		}
+		if err == nil {
+			body, err = ioutil.ReadAll(r)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode %s content - %v",
+				strings.TrimSpace(encodings[i]), err)
+		}
+	}
+	return body, nil
+}
+
+// Read a deflate body. The standard wraps it in zlib, but many servers send
+// the raw deflate stream.
+func inflate(body []byte) io.Reader {
+	if r, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+		return r
+	}
+	return flate.NewReader(bytes.NewReader(body))
+}
+
+// Read an HTML page as UTF-8. The page's charset is taken from its byte
+// order mark, then its Content-Type header, then its <meta> tags; a page
+// which declares none is read as UTF-8 if it is valid, or else as
+// windows-1252.
+func UTF8Reader(body []byte, contentType string) io.Reader {
+	encoding, _, _ := charset.DetermineEncoding(body, contentType)
+	return transform.NewReader(bytes.NewReader(body), encoding.NewDecoder())
+}
@@ -0,0 +1,171 @@
+package crawl
+
+import (
+	"bytes"
+	"code.google.com/p/go.text/encoding/japanese"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Compress bytes with a compressing writer
+func compress(body []byte, newWriter func(io.Writer) io.WriteCloser) []byte {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	w.Write(body)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Encode a string as Shift_JIS
+func shiftJIS(s string) []byte {
+	b, _ := japanese.ShiftJIS.NewEncoder().Bytes([]byte(s))
+	return b
+}
+
+func gzipWriter(w io.Writer) io.WriteCloser   { return gzip.NewWriter(w) }
+func zlibWriter(w io.Writer) io.WriteCloser   { return zlib.NewWriter(w) }
+func brotliWriter(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }
+func flateWriter(w io.Writer) io.WriteCloser {
+	fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+	return fw
+}
+
+func TestDecodeContent(t *testing.T) {
+	Convey("Given a compressed page", t, func() {
+		page := []byte(`<a href="/next">Next</a>`)
+
+		Convey("Then gzip, deflate and brotli bodies are decompressed", func() {
+			for encoding, body := range map[string][]byte{
+				"gzip":    compress(page, gzipWriter),
+				"x-gzip":  compress(page, gzipWriter),
+				"deflate": compress(page, zlibWriter),
+				"br":      compress(page, brotliWriter),
+			} {
+				decoded, err := DecodeContent(body, encoding)
+				So(err, ShouldBeNil)
+				So(string(decoded), ShouldEqual, string(page))
+			}
+		})
+
+		Convey("Then a raw deflate stream without the zlib wrapper is decompressed", func() {
+			decoded, err := DecodeContent(compress(page, flateWriter), "deflate")
+			So(err, ShouldBeNil)
+			So(string(decoded), ShouldEqual, string(page))
+		})
+
+		Convey("Then several encodings are removed in reverse order", func() {
+			body := compress(compress(page, gzipWriter), brotliWriter)
+			decoded, err := DecodeContent(body, "gzip, br")
+			So(err, ShouldBeNil)
+			So(string(decoded), ShouldEqual, string(page))
+		})
+
+		Convey("Then an identity or missing encoding leaves the body alone", func() {
+			for _, encoding := range []string{"", "identity"} {
+				decoded, err := DecodeContent(page, encoding)
+				So(err, ShouldBeNil)
+				So(string(decoded), ShouldEqual, string(page))
+			}
+		})
+
+		Convey("Then an unknown or corrupt encoding is an error", func() {
+			_, err := DecodeContent(page, "compress")
+			So(err, ShouldNotBeNil)
+			_, err = DecodeContent(page, "gzip")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUTF8Reader(t *testing.T) {
+	source, _ := url.Parse("http://example.com/")
+
+	Convey("Given a Shift_JIS page", t, func() {
+		page := shiftJIS(`<a href="/日本">日本語</a>`)
+
+		Convey("Then its charset is taken from the Content-Type header", func() {
+			links, err := ParseLinks(source, UTF8Reader(page, "text/html; charset=Shift_JIS"))
+			So(err, ShouldBeNil)
+			So(len(links), ShouldEqual, 1)
+			So(links[0].Text, ShouldEqual, "日本語")
+			So(links[0].URL.Path, ShouldEqual, "/日本")
+		})
+
+		Convey("Then its charset is taken from a meta tag", func() {
+			page = append(shiftJIS(`<meta charset="shift_jis">`), page...)
+			links, err := ParseLinks(source, UTF8Reader(page, "text/html"))
+			So(err, ShouldBeNil)
+			So(len(links), ShouldEqual, 1)
+			So(links[0].Text, ShouldEqual, "日本語")
+		})
+	})
+
+	Convey("Given a windows-1252 page which declares no charset", t, func() {
+		page := []byte("<a href=\"/caf\xe9\">Caf\xe9</a>")
+
+		Convey("Then it is read as windows-1252", func() {
+			links, err := ParseLinks(source, UTF8Reader(page, ""))
+			So(err, ShouldBeNil)
+			So(len(links), ShouldEqual, 1)
+			So(links[0].Text, ShouldEqual, "Café")
+		})
+	})
+
+	Convey("Given a page with a byte order mark", t, func() {
+		page := []byte("\xef\xbb\xbf<a href=\"/\">Caf\xc3\xa9</a>")
+
+		Convey("Then the byte order mark overrides the Content-Type header", func() {
+			links, err := ParseLinks(source, UTF8Reader(page, "text/html; charset=iso-8859-1"))
+			So(err, ShouldBeNil)
+			So(len(links), ShouldEqual, 1)
+			So(links[0].Text, ShouldEqual, "Café")
+		})
+	})
+}
+
+func TestCompressedCrawl(t *testing.T) {
+	Convey("Given a server which sends brotli-compressed Shift_JIS pages", t, func() {
+		page := shiftJIS(`<a href="/next">次へ</a>`)
+		var acceptEncoding string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			acceptEncoding = r.Header.Get("Accept-Encoding")
+			w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write(compress(page, brotliWriter))
+		}))
+		Reset(func() {
+			server.Close()
+		})
+		site, _ := url.Parse(server.URL)
+		crawler := Crawler{
+			queue:   NewQueue(),
+			limiter: NewRateLimiter(HostLimit{}, nil, 0),
+			stats:   NewStats(),
+		}
+		So(crawler.initCookies(), ShouldBeNil)
+		crawler.client = crawler.newClient()
+
+		Convey("When I fetch a page", func() {
+			resp, body, err := crawler.get(site)
+			So(err, ShouldBeNil)
+
+			Convey("Then I ask for every encoding I can decode", func() {
+				So(acceptEncoding, ShouldEqual, "gzip, deflate, br")
+			})
+
+			Convey("Then the body is decompressed but keeps its charset", func() {
+				So(string(body), ShouldEqual, string(page))
+				So(resp.Header.Get("Content-Encoding"), ShouldEqual, "")
+				So(resp.Header.Get("Content-Type"), ShouldEqual, "text/html; charset=Shift_JIS")
+			})
+		})
+	})
+}
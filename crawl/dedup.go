@@ -0,0 +1,68 @@
+package crawl
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// How to store pages whose content duplicates an already-saved page
+type DedupMode int
+
+const (
+	// Save a full copy of every page
+	DedupNone DedupMode = iota
+
+	// Save duplicate pages as hard links to the first copy
+	DedupHardLink
+
+	// Save duplicate pages as symbolic links to the first copy
+	DedupSymlink
+)
+
+// Parse a dedup mode name, as produced by DedupMode.String()
+func ParseDedupMode(name string) (DedupMode, error) {
+	for _, mode := range []DedupMode{DedupNone, DedupHardLink, DedupSymlink} {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return DedupNone, fmt.Errorf("Unknown dedup mode %q", name)
+}
+
+// Get the name of a dedup mode
+func (mode DedupMode) String() string {
+	switch mode {
+	case DedupHardLink:
+		return "hardlink"
+	case DedupSymlink:
+		return "symlink"
+	default:
+		return "none"
+	}
+}
+
+// Link a new file path to the saved copy of the same content
+func (mode DedupMode) link(saved, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	switch mode {
+	case DedupHardLink:
+		return os.Link(saved, path)
+	case DedupSymlink:
+		target, err := filepath.Rel(filepath.Dir(path), saved)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, path)
+	default:
+		return fmt.Errorf("Can't link files with dedup mode %v", mode)
+	}
+}
+
+// Get the digest used to detect duplicate content
+func ContentDigest(body []byte) string {
+	return fmt.Sprintf("%x", sha1.Sum(body))
+}
@@ -0,0 +1,138 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Sends the crawler's requests. An *http.Client is a Fetcher; the other
+// fetchers serve responses from a previous crawl, so a crawl can be repeated
+// without touching the network.
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Open a fetcher which serves the pages saved at a path: either a folder
+// holding a crawl saved by webcp, or a WARC file
+func OpenFetcher(path string) (Fetcher, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		return &MirrorFetcher{Folder: path}, nil
+	}
+	lower := strings.ToLower(path)
+	if strings.HasSuffix(lower, ".warc") || strings.HasSuffix(lower, ".warc.gz") {
+		return OpenWARCFetcher(path)
+	}
+	return nil, fmt.Errorf("Can't crawl from %s - it is not a folder or a WARC file", path)
+}
+
+// Get the crawler's fetcher, which fetches pages from the crawl's sources
+func (crawler *Crawler) fetcher() Fetcher {
+	live := crawler.liveFetcher()
+	if crawler.wayback == nil {
+		return live
+	}
+	switch crawler.Source {
+	case SourceLiveThenWayback:
+		return fallbackFetcher{live, crawler.wayback}
+	case SourceWaybackThenLive:
+		return fallbackFetcher{crawler.wayback, live}
+	default:
+		return crawler.wayback
+	}
+}
+
+// Get the fetcher for the live site, or for the crawl being repeated. Its
+// requests, including those the Wayback Machine fetcher sends to the
+// archive, are made within the rate limits of the host they're sent to.
+func (crawler *Crawler) liveFetcher() Fetcher {
+	var fetcher Fetcher = http.DefaultClient
+	if crawler.Fetcher != nil {
+		fetcher = crawler.Fetcher
+	} else if crawler.client != nil {
+		fetcher = crawler.client
+	}
+	if crawler.limiter != nil {
+		fetcher = limitedFetcher{fetcher, crawler.limiter}
+	}
+	return fetcher
+}
+
+// Sends requests within the rate limits of the host each is sent to, which
+// is an archive's host rather than the page's for a page fetched from the
+// archive. The host's slot is held until the response body is closed.
+type limitedFetcher struct {
+	Fetcher
+	limiter *RateLimiter
+}
+
+// Send a request once its host's limits allow, and adjust the host's rate
+// to the response. The wait doesn't count against the request's timeouts.
+func (fetcher limitedFetcher) Do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	var release func()
+	excludeWait(req.Context(), func() {
+		release = fetcher.limiter.Acquire(host)
+	})
+	resp, err := fetcher.Fetcher.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	fetcher.limiter.Feedback(host, resp.StatusCode, RetryAfter(resp))
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// A response body which releases its host's slot when it is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+// Close the body, and release the slot
+func (body *releasingBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.once.Do(body.release)
+	return err
+}
+
+// Create the client for the crawl's requests, which keeps its cookies and
+// credentials, and connects with its proxy and TLS settings
+func (crawler *Crawler) newClient() *http.Client {
+	client := &http.Client{
+		Transport:     crawler.newTransport(),
+		CheckRedirect: crawler.checkRedirect,
+	}
+	if crawler.Cookies != nil {
+		client.Jar = crawler.Cookies
+	}
+	return client
+}
+
+// Create a response to a request, with a body read from memory
+func newResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,172 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Build a WARC record
+func makeWARCRecord(kind, uri, contentType, block string) string {
+	return fmt.Sprintf("WARC/1.0\r\nWARC-Type: %s\r\nWARC-Target-URI: %s\r\n"+
+		"Content-Type: %s\r\nContent-Length: %d\r\n\r\n%s\r\n\r\n",
+		kind, uri, contentType, len(block), block)
+}
+
+// Build an HTTP response as recorded in a WARC file
+func httpResponse(status int, body string) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Type: text/html\r\n"+
+		"Content-Length: %d\r\n\r\n%s", status, http.StatusText(status), len(body), body)
+}
+
+var WARC_RECORDS = []string{
+	makeWARCRecord("warcinfo", "", "application/warc-fields", "software: test\r\n"),
+	makeWARCRecord("request", "http://domain.com/", "application/http; msgtype=request",
+		"GET / HTTP/1.1\r\nHost: domain.com\r\n\r\n"),
+	makeWARCRecord("response", "http://domain.com/", "application/http; msgtype=response",
+		httpResponse(200, "old copy")),
+	makeWARCRecord("response", "<http://domain.com/>", "application/http; msgtype=response",
+		httpResponse(200, REL_LINK_PAGE)),
+	makeWARCRecord("response", "http://domain.com/gone.html", "application/http; msgtype=response",
+		httpResponse(410, "gone")),
+	makeWARCRecord("resource", "http://domain.com/notes.txt", "text/plain", "some notes"),
+}
+
+func TestFetchers(t *testing.T) {
+	Convey("Given a folder for test files", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		get := func(fetcher Fetcher, site string) (*http.Response, string) {
+			req, err := http.NewRequest("GET", site, nil)
+			So(err, ShouldBeNil)
+			resp, err := fetcher.Do(req)
+			So(err, ShouldBeNil)
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return resp, string(body)
+		}
+		checkWARC := func(path string) {
+			fetcher, err := OpenFetcher(path)
+			So(err, ShouldBeNil)
+			defer fetcher.(*WARCFetcher).Close()
+
+			resp, body := get(fetcher, "http://domain.com/")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, REL_LINK_PAGE)
+
+			resp, body = get(fetcher, "http://domain.com/gone.html")
+			So(resp.StatusCode, ShouldEqual, http.StatusGone)
+			So(body, ShouldEqual, "gone")
+
+			resp, body = get(fetcher, "http://domain.com/notes.txt")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, "text/plain")
+			So(body, ShouldEqual, "some notes")
+
+			resp, _ = get(fetcher, "http://domain.com/missing.html")
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		}
+
+		Convey("When I serve pages from a saved crawl", func() {
+			page := filepath.Join(folder, "domain.com", "index.html")
+			So(os.MkdirAll(filepath.Dir(page), 0777), ShouldBeNil)
+			So(ioutil.WriteFile(page, []byte(NO_LINK_PAGE), 0644), ShouldBeNil)
+			fetcher, err := OpenFetcher(folder)
+			So(err, ShouldBeNil)
+
+			Convey("Then I serve the saved pages", func() {
+				resp, body := get(fetcher, "http://domain.com/")
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/html")
+				So(body, ShouldEqual, NO_LINK_PAGE)
+			})
+
+			Convey("Then pages which weren't saved are not found", func() {
+				resp, _ := get(fetcher, "http://domain.com/missing.html")
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When I serve pages from a WARC file", func() {
+			path := filepath.Join(folder, "crawl.warc")
+			var buff bytes.Buffer
+			for _, record := range WARC_RECORDS {
+				buff.WriteString(record)
+			}
+			So(ioutil.WriteFile(path, buff.Bytes(), 0644), ShouldBeNil)
+
+			Convey("Then I serve the last response for each URL", func() {
+				checkWARC(path)
+			})
+		})
+
+		Convey("When I serve pages from a compressed WARC file", func() {
+			path := filepath.Join(folder, "crawl.warc.gz")
+			var buff bytes.Buffer
+			for _, record := range WARC_RECORDS {
+				gz := gzip.NewWriter(&buff)
+				gz.Name = "record"
+				gz.Write([]byte(record))
+				gz.Close()
+			}
+			So(ioutil.WriteFile(path, buff.Bytes(), 0644), ShouldBeNil)
+
+			Convey("Then I serve the last response for each URL", func() {
+				checkWARC(path)
+			})
+		})
+
+		Convey("When I serve pages from an unknown kind of file", func() {
+			path := filepath.Join(folder, "crawl.zip")
+			So(ioutil.WriteFile(path, []byte("PK"), 0644), ShouldBeNil)
+			_, err := OpenFetcher(path)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I crawl a WARC file", func() {
+			path := filepath.Join(folder, "crawl.warc")
+			var buff bytes.Buffer
+			for _, record := range WARC_RECORDS {
+				buff.WriteString(record)
+			}
+			So(ioutil.WriteFile(path, buff.Bytes(), 0644), ShouldBeNil)
+			fetcher, err := OpenWARCFetcher(path)
+			So(err, ShouldBeNil)
+			Reset(func() {
+				fetcher.Close()
+			})
+			seed, _ := ParseSeed("http://domain.com/")
+			crawler := Crawler{
+				Fetcher:  fetcher,
+				Folder:   filepath.Join(folder, "mirror"),
+				MaxDepth: 2,
+				Seeds:    []Seed{seed},
+				queue:    NewQueue(),
+				limiter:  NewRateLimiter(HostLimit{}, nil, 0),
+				stats:    NewStats(),
+				control:  newControlState(),
+			}
+			crawler.crawl()
+
+			Convey("Then I save the archived pages, and report the missing ones", func() {
+				content, err := ioutil.ReadFile(filepath.Join(crawler.Folder, "domain.com", "index.html"))
+				So(err, ShouldBeNil)
+				So(string(content), ShouldEqual, REL_LINK_PAGE)
+				So(crawler.stats.Fetched, ShouldEqual, 1)
+				So(crawler.stats.Failed, ShouldResemble, map[string]int{"404": 3})
+			})
+		})
+	})
+}
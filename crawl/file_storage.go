@@ -14,11 +14,20 @@ type FileQueueStorage struct {
 	Reader  *os.File
 	Scanner *bufio.Scanner
 	Writer  *os.File
+	Digests map[string]string
+	Jar     []string
+	Spent   map[string]int
+
+	// The number of pages in the file which have not been crawled
+	pending int
 }
 
 // Open/Create a new file storage at a given path
 func NewFileQueueStorage(path string) (storage *FileQueueStorage, didResume bool, err error) {
-	storage = &FileQueueStorage{}
+	storage = &FileQueueStorage{
+		Digests: make(map[string]string),
+		Spent:   make(map[string]int),
+	}
 	defer func() {
 		if err != nil {
 			storage.Close()
@@ -26,11 +35,11 @@ func NewFileQueueStorage(path string) (storage *FileQueueStorage, didResume bool
 	}()
 
 	// Open the file for writing
-	if storage.Writer, err = os.OpenFile(path, os.O_APPEND, 0644); err != nil {
+	if storage.Writer, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
 		return
 	}
 
-	// Find the last crawled page in the file
+	// Find the last crawled page in the file, and the digests of saved pages
 	var last string
 	r, err := os.Open(path)
 	if err != nil {
@@ -41,6 +50,18 @@ func NewFileQueueStorage(path string) (storage *FileQueueStorage, didResume bool
 			line := scanner.Text()
 			if strings.HasPrefix(line, "- ") {
 				last = line[2:]
+				storage.pending--
+			} else if strings.HasPrefix(line, "= ") {
+				parts := strings.SplitN(line, " ", 3)
+				if len(parts) == 3 {
+					storage.Digests[parts[1]] = parts[2]
+				}
+			} else if strings.HasPrefix(line, "@ ") {
+				storage.Jar = append(storage.Jar, line[2:])
+			} else if strings.HasPrefix(line, "% ") {
+				storage.Spent[line[2:]]++
+			} else if isPageLine(line) {
+				storage.pending++
 			}
 		}
 		r.Close()
@@ -50,12 +71,13 @@ func NewFileQueueStorage(path string) (storage *FileQueueStorage, didResume bool
 	if storage.Reader, err = os.Open(path); err != nil {
 		return
 	}
-	storage.Scanner = bufio.NewScanner(r)
+	storage.Scanner = bufio.NewScanner(storage.Reader)
 	if last != "" {
 		didResume = true
 		for storage.Scanner.Scan() {
 			line := storage.Scanner.Text()
-			if line == last {
+			parts := strings.SplitN(line, " ", 3)
+			if len(parts) >= 2 && isPageLine(line) && parts[1] == last {
 				break
 			}
 		}
@@ -64,37 +86,116 @@ func NewFileQueueStorage(path string) (storage *FileQueueStorage, didResume bool
 }
 
 // Store a new page to crawl later
-func (storage *FileQueueStorage) Add(site *url.URL, depth int) {
-	line := fmt.Sprintf("%d %s\n", depth, site.String())
-	if _, err := storage.Writer.WriteString(line); err != nil {
-		os.Stderr.WriteString("Failed to record " + site.String() +
+func (storage *FileQueueStorage) Add(item QueueItem) {
+	if _, err := storage.Writer.WriteString(formatQueueItem(item) + "\n"); err != nil {
+		os.Stderr.WriteString("Failed to record " + item.URL.String() +
 			" - " + err.Error() + "\n")
+		return
 	}
+	storage.pending++
 }
 
-// Get a page to crawl now, blocking until one is available
-func (storage *FileQueueStorage) Next() (*url.URL, int) {
+// Get a page to crawl now, or false if there are none left
+func (storage *FileQueueStorage) Next() (QueueItem, bool) {
 	for storage.Scanner.Scan() {
 		line := storage.Scanner.Text()
-		if !strings.HasPrefix(line, "- ") {
-			parts := strings.SplitN(line, " ", 2)
-			if len(parts) != 2 {
-				os.Stderr.WriteString("Invalid line in restore file\n")
-			} else if depth, err := strconv.Atoi(parts[0]); err != nil {
-				os.Stderr.WriteString("Invalid depth field in restore file\n")
-			} else if site, err := url.Parse(parts[1]); err != nil {
-				os.Stderr.WriteString("Invalid URL: " + parts[1] + "\n")
+		if isPageLine(line) {
+			item, err := parseQueueItem(line)
+			if err != nil {
+				os.Stderr.WriteString(err.Error() + "\n")
+				continue
+			}
+			storage.pending--
+			_, err = storage.Writer.WriteString("- " + item.URL.String() + "\n")
+			if err != nil {
+				os.Stderr.WriteString("Failed to record crawl for " +
+					item.URL.String() + " - " + err.Error() + "\n")
+			}
+			return item, true
+		}
+	}
+
+	// The scanner stops for good at the end of the file, so start a new one to
+	// pick up any pages added later
+	storage.Scanner = bufio.NewScanner(storage.Reader)
+	return QueueItem{}, false
+}
+
+// Record the digest of a page's content, and the path where it was saved
+func (storage *FileQueueStorage) AddDigest(digest, path string) {
+	storage.Digests[digest] = path
+	if _, err := storage.Writer.WriteString("= " + digest + " " + path + "\n"); err != nil {
+		os.Stderr.WriteString("Failed to record digest for " + path +
+			" - " + err.Error() + "\n")
+	}
+}
+
+// Get the path where content with a given digest was saved, if any
+func (storage *FileQueueStorage) Digest(digest string) (path string, ok bool) {
+	path, ok = storage.Digests[digest]
+	return
+}
+
+// Record a link from one page to another
+func (storage *FileQueueStorage) AddEdge(edge Edge) {
+	if _, err := storage.Writer.WriteString("> " + edge.String() + "\n"); err != nil {
+		os.Stderr.WriteString("Failed to record link to " + edge.Target.String() +
+			" - " + err.Error() + "\n")
+	}
+}
+
+// Get the links recorded in the file, including those from earlier sessions
+func (storage *FileQueueStorage) Edges() ([]Edge, error) {
+	r, err := os.Open(storage.Writer.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var edges []Edge
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "> ") {
+			if edge, err := ParseEdge(line[2:]); err == nil {
+				edges = append(edges, edge)
 			} else {
-				_, err := storage.Writer.WriteString("- " + parts[1] + "\n")
-				if err != nil {
-					os.Stderr.WriteString("Failed to record crawl for " +
-						parts[1] + " - " + err.Error() + "\n")
-				}
-				return site, depth
+				os.Stderr.WriteString("Invalid link in restore file: " + line[2:] + "\n")
 			}
 		}
 	}
-	return nil, 0
+	return edges, scanner.Err()
+}
+
+// Record a cookie, formatted as a line of a Netscape cookies file
+func (storage *FileQueueStorage) AddCookie(line string) {
+	storage.Jar = append(storage.Jar, line)
+	if _, err := storage.Writer.WriteString("@ " + line + "\n"); err != nil {
+		os.Stderr.WriteString("Failed to record cookie - " + err.Error() + "\n")
+	}
+}
+
+// Get the cookies recorded so far, including those from earlier sessions
+func (storage *FileQueueStorage) Cookies() []string {
+	return storage.Jar
+}
+
+// Record a page counted against a quota
+func (storage *FileQueueStorage) AddQuotaPage(match string) {
+	storage.Spent[match]++
+	if _, err := storage.Writer.WriteString("% " + match + "\n"); err != nil {
+		os.Stderr.WriteString("Failed to record quota for " + match + " - " +
+			err.Error() + "\n")
+	}
+}
+
+// Get the number of pages counted against each quota, including those from
+// earlier sessions
+func (storage *FileQueueStorage) QuotaPages() map[string]int {
+	return storage.Spent
+}
+
+// Get the number of pages waiting to be crawled
+func (storage *FileQueueStorage) Len() int {
+	return storage.pending
 }
 
 // Close the underlying files
@@ -105,8 +206,76 @@ func (storage *FileQueueStorage) Close() error {
 		storage.Scanner = nil
 	}
 	if storage.Writer != nil {
+		storage.Writer.Sync()
 		storage.Writer.Close()
 		storage.Writer = nil
 	}
 	return nil
 }
+
+// Ask whether a line of a resume file holds a page to crawl, rather than
+// recording a crawled page, digest, link, cookie or quota page
+func isPageLine(line string) bool {
+	for _, prefix := range []string{"- ", "= ", "> ", "@ ", "% "} {
+		if strings.HasPrefix(line, prefix) {
+			return false
+		}
+	}
+	return line != ""
+}
+
+// Format a page to crawl as a line of a resume file: its depth, followed by
+// ",h" and the number of hops outside the seeds' scopes if any, ",r" if it is
+// a requisite and ",c" if it is only to be checked; then its URL; then the
+// URL of its seed, if known
+func formatQueueItem(item QueueItem) string {
+	fields := strconv.Itoa(item.Depth)
+	if item.Hops > 0 {
+		fields += ",h" + strconv.Itoa(item.Hops)
+	}
+	if item.Requisite {
+		fields += ",r"
+	}
+	if item.Check {
+		fields += ",c"
+	}
+	line := fields + " " + item.URL.String()
+	if item.Seed != nil {
+		line += " " + item.Seed.String()
+	}
+	return line
+}
+
+// Parse a page to crawl from a line of a resume file, as formatted by
+// formatQueueItem()
+func parseQueueItem(line string) (item QueueItem, err error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return item, fmt.Errorf("Invalid line in restore file")
+	}
+	fields := strings.Split(parts[0], ",")
+	if item.Depth, err = strconv.Atoi(fields[0]); err != nil {
+		return item, fmt.Errorf("Invalid depth field in restore file")
+	}
+	for _, field := range fields[1:] {
+		switch {
+		case field == "r":
+			item.Requisite = true
+		case field == "c":
+			item.Check = true
+		case strings.HasPrefix(field, "h"):
+			if item.Hops, err = strconv.Atoi(field[1:]); err != nil {
+				return item, fmt.Errorf("Invalid hops field in restore file")
+			}
+		}
+	}
+	if item.URL, err = url.Parse(parts[1]); err != nil {
+		return item, fmt.Errorf("Invalid URL: %s", parts[1])
+	}
+	if len(parts) == 3 {
+		if item.Seed, err = url.Parse(parts[2]); err != nil {
+			return item, fmt.Errorf("Invalid seed URL: %s", parts[2])
+		}
+	}
+	return item, nil
+}
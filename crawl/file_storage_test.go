@@ -0,0 +1,152 @@
+package crawl
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileQueueStorage(t *testing.T) {
+	Convey("Given a new resume file", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		path := filepath.Join(folder, "resume.txt")
+		storage, didResume, err := NewFileQueueStorage(path)
+		So(err, ShouldBeNil)
+		So(didResume, ShouldBeFalse)
+
+		first, _ := url.Parse("http://domain.com/")
+		second, _ := url.Parse("http://domain.com/page.html")
+		storage.Add(QueueItem{URL: first, Depth: 1, Seed: first})
+		storage.Add(QueueItem{URL: second, Depth: 2, Seed: first, Hops: 1, Requisite: true})
+
+		Convey("When I crawl a page and record its digest", func() {
+			item, ok := storage.Next()
+			So(ok, ShouldBeTrue)
+			So(item, ShouldResemble, QueueItem{URL: first, Depth: 1, Seed: first})
+			So(storage.Len(), ShouldEqual, 1)
+			storage.AddDigest("abc123", "domain.com/index.html")
+			storage.AddEdge(Edge{Source: first, Target: second, Tag: "a", Text: "Next page"})
+			storage.AddCookie("domain.com\tFALSE\t/\tFALSE\t0\tsession\tabc")
+			storage.AddQuotaPage("domain.com/calendar/")
+			storage.AddQuotaPage("domain.com/calendar/")
+			storage.AddQuotaPage("~[?&]page=")
+			So(storage.Len(), ShouldEqual, 1)
+			storage.Close()
+
+			Convey("Then a resumed crawl continues with the next page", func() {
+				storage, didResume, err := NewFileQueueStorage(path)
+				So(err, ShouldBeNil)
+				defer storage.Close()
+				So(didResume, ShouldBeTrue)
+				So(storage.Len(), ShouldEqual, 1)
+
+				item, ok := storage.Next()
+				So(ok, ShouldBeTrue)
+				So(item, ShouldResemble, QueueItem{URL: second, Depth: 2, Seed: first, Hops: 1, Requisite: true})
+				So(storage.Len(), ShouldEqual, 0)
+
+				Convey("And remembers the digest", func() {
+					saved, ok := storage.Digest("abc123")
+					So(ok, ShouldBeTrue)
+					So(saved, ShouldEqual, "domain.com/index.html")
+				})
+
+				Convey("And remembers the cookies", func() {
+					So(storage.Cookies(), ShouldResemble, []string{
+						"domain.com\tFALSE\t/\tFALSE\t0\tsession\tabc",
+					})
+				})
+
+				Convey("And remembers the pages counted against quotas", func() {
+					So(storage.QuotaPages(), ShouldResemble, map[string]int{
+						"domain.com/calendar/": 2,
+						"~[?&]page=":           1,
+					})
+				})
+
+				Convey("And remembers the links", func() {
+					edges, err := storage.Edges()
+					So(err, ShouldBeNil)
+					So(edges, ShouldResemble, []Edge{
+						{Source: first, Target: second, Tag: "a", Text: "Next page"},
+					})
+				})
+			})
+		})
+	})
+	Convey("Given a resume file which doesn't exist yet", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		path := filepath.Join(folder, "resume.txt")
+
+		Convey("When I open it and add a page", func() {
+			storage, didResume, err := NewFileQueueStorage(path)
+			So(err, ShouldBeNil)
+			So(didResume, ShouldBeFalse)
+			site, _ := url.Parse("http://domain.com/")
+			storage.Add(QueueItem{URL: site, Depth: 1})
+			storage.Close()
+
+			Convey("Then the file is created with the page", func() {
+				content, err := ioutil.ReadFile(path)
+				So(err, ShouldBeNil)
+				So(string(content), ShouldEqual, "1 http://domain.com/\n")
+			})
+		})
+	})
+
+	Convey("Given a resume file in which no page was crawled yet", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		path := filepath.Join(folder, "resume.txt")
+		So(ioutil.WriteFile(path, []byte("1 http://domain.com/\n2 http://domain.com/page.html\n"), 0644), ShouldBeNil)
+
+		Convey("Then the crawl starts with the first page", func() {
+			storage, didResume, err := NewFileQueueStorage(path)
+			So(err, ShouldBeNil)
+			defer storage.Close()
+			So(didResume, ShouldBeFalse)
+			item, ok := storage.Next()
+			So(ok, ShouldBeTrue)
+			So(item.URL.String(), ShouldEqual, "http://domain.com/")
+			item, ok = storage.Next()
+			So(ok, ShouldBeTrue)
+			So(item.URL.String(), ShouldEqual, "http://domain.com/page.html")
+		})
+	})
+
+	Convey("Given a resume file written before pages recorded their seeds", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		path := filepath.Join(folder, "resume.txt")
+		So(ioutil.WriteFile(path, []byte("1 http://domain.com/\n- http://domain.com/\n2 http://domain.com/page.html\n"), 0644), ShouldBeNil)
+
+		Convey("Then the crawl resumes with the next page", func() {
+			storage, didResume, err := NewFileQueueStorage(path)
+			So(err, ShouldBeNil)
+			defer storage.Close()
+			So(didResume, ShouldBeTrue)
+			item, ok := storage.Next()
+			So(ok, ShouldBeTrue)
+			So(item.URL.String(), ShouldEqual, "http://domain.com/page.html")
+			So(item.Depth, ShouldEqual, 2)
+			So(item.Seed, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,249 @@
+package crawl
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A link from one page to another, recorded when the linking page was parsed
+type Edge struct {
+
+	// The page holding the link
+	Source *url.URL
+
+	// The page or resource linked to
+	Target *url.URL
+
+	// The name of the element holding the link, such as "a" or "img"
+	Tag string
+
+	// The link's anchor or alt text
+	Text string
+}
+
+// Create the edge for a link found on a page
+func NewEdge(source *url.URL, link Link) Edge {
+	return Edge{
+		Source: source,
+		Target: CanonicalURL(link.URL),
+		Tag:    link.Tag,
+		Text:   link.Text,
+	}
+}
+
+// Format an edge as a line of a resume file
+func (edge Edge) String() string {
+	return fmt.Sprintf("%s %s %s %s", edge.Tag, edge.Source, edge.Target, edge.Text)
+}
+
+// Parse an edge formatted by Edge.String()
+func ParseEdge(line string) (edge Edge, err error) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 {
+		return edge, fmt.Errorf("Invalid link %q", line)
+	}
+	edge.Tag = parts[0]
+	if edge.Source, err = url.Parse(parts[1]); err != nil {
+		return
+	} else if edge.Target, err = url.Parse(parts[2]); err != nil {
+		return
+	}
+	if len(parts) == 4 {
+		edge.Text = parts[3]
+	}
+	return
+}
+
+// A file format for the link graph
+type GraphFormat int
+
+const (
+	// A CSV edge list, with a header row
+	GraphCSV GraphFormat = iota
+
+	// A Graphviz DOT digraph
+	GraphDOT
+
+	// A GraphML document
+	GraphML
+)
+
+// Parse a graph format name, as produced by GraphFormat.String()
+func ParseGraphFormat(name string) (GraphFormat, error) {
+	for _, format := range []GraphFormat{GraphCSV, GraphDOT, GraphML} {
+		if format.String() == name {
+			return format, nil
+		}
+	}
+	return GraphCSV, fmt.Errorf("Unknown graph format %q", name)
+}
+
+// Choose a graph format from a file's extension, defaulting to CSV
+func GraphFormatFor(path string) GraphFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dot", ".gv":
+		return GraphDOT
+	case ".graphml", ".xml":
+		return GraphML
+	default:
+		return GraphCSV
+	}
+}
+
+// Get the name of a graph format
+func (format GraphFormat) String() string {
+	switch format {
+	case GraphDOT:
+		return "dot"
+	case GraphML:
+		return "graphml"
+	default:
+		return "csv"
+	}
+}
+
+// Write a link graph in the given format
+func WriteGraph(w io.Writer, format GraphFormat, edges []Edge) error {
+	switch format {
+	case GraphDOT:
+		return writeDOT(w, edges)
+	case GraphML:
+		return writeGraphML(w, edges)
+	default:
+		return writeCSV(w, edges)
+	}
+}
+
+// Write the link graph to the crawler's Graph file
+func (crawler *Crawler) writeGraph() error {
+	edges, err := crawler.queue.Edges()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(crawler.Graph)
+	if err != nil {
+		return err
+	}
+	if err = WriteGraph(file, crawler.GraphFormat, edges); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Write a CSV edge list
+func writeCSV(w io.Writer, edges []Edge) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"source", "target", "tag", "text"})
+	for _, edge := range edges {
+		out.Write([]string{edge.Source.String(), edge.Target.String(), edge.Tag, edge.Text})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Write a Graphviz digraph, labeling each edge with its element and text
+func writeDOT(w io.Writer, edges []Edge) error {
+	if _, err := io.WriteString(w, "digraph links {\n"); err != nil {
+		return err
+	}
+	for _, edge := range edges {
+		label := edge.Tag
+		if edge.Text != "" {
+			label += ": " + edge.Text
+		}
+		fmt.Fprintf(w, "  %s -> %s [label=%s];\n", strconv.Quote(edge.Source.String()),
+			strconv.Quote(edge.Target.String()), strconv.Quote(label))
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+// A GraphML document
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+// A GraphML attribute declaration
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+// A GraphML graph
+type graphMLGraph struct {
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+// A GraphML node, identified by its URL
+type graphMLNode struct {
+	ID string `xml:"id,attr"`
+}
+
+// A GraphML edge, with its element and text as data
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// A GraphML attribute value
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Write a GraphML document, with a node for each URL
+func writeGraphML(w io.Writer, edges []Edge) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "tag", For: "edge", Name: "tag", Type: "string"},
+			{ID: "text", For: "edge", Name: "text", Type: "string"},
+		},
+		Graph: graphMLGraph{EdgeDefault: "directed"},
+	}
+	nodes := make(map[string]bool)
+	addNode := func(site *url.URL) string {
+		id := site.String()
+		if !nodes[id] {
+			nodes[id] = true
+			doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: id})
+		}
+		return id
+	}
+	for _, edge := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: addNode(edge.Source),
+			Target: addNode(edge.Target),
+			Data: []graphMLData{
+				{Key: "tag", Value: edge.Tag},
+				{Key: "text", Value: edge.Text},
+			},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,110 @@
+package crawl
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	home, _ := url.Parse("http://domain.com/")
+	page, _ := url.Parse("http://domain.com/page.html")
+	logo, _ := url.Parse("http://domain.com/logo.png")
+	edges := []Edge{
+		{Source: home, Target: page, Tag: "a", Text: `The "page"`},
+		{Source: home, Target: logo, Tag: "img"},
+		{Source: page, Target: home, Tag: "a", Text: "Home"},
+	}
+
+	Convey("Given a link formatted for a resume file", t, func() {
+		line := edges[0].String()
+
+		Convey("Then it parses to the same link", func() {
+			edge, err := ParseEdge(line)
+			So(err, ShouldBeNil)
+			So(edge, ShouldResemble, edges[0])
+		})
+
+		Convey("Then a link without text parses too", func() {
+			edge, err := ParseEdge(edges[1].String())
+			So(err, ShouldBeNil)
+			So(edge, ShouldResemble, edges[1])
+		})
+	})
+
+	Convey("Given a graph file name", t, func() {
+		Convey("Then its format comes from its extension", func() {
+			So(GraphFormatFor("links.dot"), ShouldEqual, GraphDOT)
+			So(GraphFormatFor("links.GraphML"), ShouldEqual, GraphML)
+			So(GraphFormatFor("links.csv"), ShouldEqual, GraphCSV)
+			So(GraphFormatFor("links"), ShouldEqual, GraphCSV)
+		})
+
+		Convey("Then format names round trip", func() {
+			for _, format := range []GraphFormat{GraphCSV, GraphDOT, GraphML} {
+				parsed, err := ParseGraphFormat(format.String())
+				So(err, ShouldBeNil)
+				So(parsed, ShouldEqual, format)
+			}
+			_, err := ParseGraphFormat("svg")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given the links found by a crawl", t, func() {
+		var buff bytes.Buffer
+
+		Convey("When I write them as CSV", func() {
+			So(WriteGraph(&buff, GraphCSV, edges), ShouldBeNil)
+
+			Convey("Then I get an edge list with a header", func() {
+				rows, err := csv.NewReader(&buff).ReadAll()
+				So(err, ShouldBeNil)
+				So(rows, ShouldResemble, [][]string{
+					{"source", "target", "tag", "text"},
+					{"http://domain.com/", "http://domain.com/page.html", "a", `The "page"`},
+					{"http://domain.com/", "http://domain.com/logo.png", "img", ""},
+					{"http://domain.com/page.html", "http://domain.com/", "a", "Home"},
+				})
+			})
+		})
+
+		Convey("When I write them as DOT", func() {
+			So(WriteGraph(&buff, GraphDOT, edges), ShouldBeNil)
+
+			Convey("Then I get a labeled digraph", func() {
+				So(buff.String(), ShouldEqual, `digraph links {
+  "http://domain.com/" -> "http://domain.com/page.html" [label="a: The \"page\""];
+  "http://domain.com/" -> "http://domain.com/logo.png" [label="img"];
+  "http://domain.com/page.html" -> "http://domain.com/" [label="a: Home"];
+}
+`)
+			})
+		})
+
+		Convey("When I write them as GraphML", func() {
+			So(WriteGraph(&buff, GraphML, edges), ShouldBeNil)
+
+			Convey("Then I get a node for each URL and an edge for each link", func() {
+				var doc graphML
+				So(xml.Unmarshal(buff.Bytes(), &doc), ShouldBeNil)
+				So(doc.Graph.EdgeDefault, ShouldEqual, "directed")
+				So(doc.Graph.Nodes, ShouldResemble, []graphMLNode{
+					{ID: "http://domain.com/"},
+					{ID: "http://domain.com/page.html"},
+					{ID: "http://domain.com/logo.png"},
+				})
+				So(len(doc.Graph.Edges), ShouldEqual, 3)
+				So(doc.Graph.Edges[0].Source, ShouldEqual, "http://domain.com/")
+				So(doc.Graph.Edges[0].Target, ShouldEqual, "http://domain.com/page.html")
+				So(doc.Graph.Edges[0].Data, ShouldResemble, []graphMLData{
+					{Key: "tag", Value: "a"},
+					{Key: "text", Value: `The "page"`},
+				})
+			})
+		})
+	})
+}
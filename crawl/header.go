@@ -0,0 +1,43 @@
+package crawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// Parse a request header written as "Name: value"
+func ParseHeader(line string) (name, value string, err error) {
+	parts := strings.SplitN(line, ":", 2)
+	name = strings.TrimSpace(parts[0])
+	if len(parts) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("Invalid header %q - expected \"Name: value\"", line)
+	}
+	return textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(parts[1]), nil
+}
+
+// Set the User-Agent and the other headers for a request's host. Headers for
+// the host replace those for every host.
+func (crawler *Crawler) setHeaders(req *http.Request) {
+	if crawler.UserAgent != "" {
+		req.Header.Set("User-Agent", crawler.UserAgent)
+	}
+	for _, headers := range []http.Header{crawler.Headers, crawler.HostHeaders[req.URL.Host]} {
+		for name, values := range headers {
+			req.Header[textproto.CanonicalMIMEHeaderKey(name)] = append([]string(nil), values...)
+		}
+	}
+}
+
+// Replace the headers for the hosts a request was redirected from with those
+// for the host it was redirected to. Every earlier request is checked, since
+// the client copies the headers of the first one to each redirect.
+func (crawler *Crawler) redirectHeaders(req *http.Request, via []*http.Request) {
+	for _, from := range via {
+		for name := range crawler.HostHeaders[from.URL.Host] {
+			req.Header.Del(name)
+		}
+	}
+	crawler.setHeaders(req)
+}
@@ -0,0 +1,112 @@
+package crawl
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestHeaders(t *testing.T) {
+	Convey("Given a header on the command line", t, func() {
+		Convey("Then its name and value are parsed", func() {
+			name, value, err := ParseHeader("accept-language:  en-US ")
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "Accept-Language")
+			So(value, ShouldEqual, "en-US")
+		})
+
+		Convey("Then a header without a name is an error", func() {
+			_, _, err := ParseHeader("en-US")
+			So(err, ShouldNotBeNil)
+			_, _, err = ParseHeader(": en-US")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a host with its own headers which redirects to another host", t, func() {
+		var received []http.Header
+		far := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = append(received, r.Header)
+		}))
+		Reset(func() {
+			far.Close()
+		})
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = append(received, r.Header)
+			if r.URL.Path == "/on" {
+				http.Redirect(w, r, far.URL+"/", http.StatusFound)
+			}
+		}))
+		Reset(func() {
+			other.Close()
+		})
+		special := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = append(received, r.Header)
+			if r.URL.Path == "/away" {
+				http.Redirect(w, r, other.URL+"/", http.StatusFound)
+			} else if r.URL.Path == "/further" {
+				http.Redirect(w, r, other.URL+"/on", http.StatusFound)
+			}
+		}))
+		Reset(func() {
+			special.Close()
+		})
+		specialURL, _ := url.Parse(special.URL)
+
+		crawler := Crawler{
+			UserAgent: "webcp-test",
+			Headers:   http.Header{"Accept-Language": {"en-US"}, "X-Team": {"docs"}},
+			HostHeaders: map[string]http.Header{
+				specialURL.Host: {"X-Team": {"wiki"}, "X-Key": {"abc"}},
+			},
+			queue:   NewQueue(),
+			limiter: NewRateLimiter(HostLimit{}, nil, 0),
+			stats:   NewStats(),
+		}
+		So(crawler.initCookies(), ShouldBeNil)
+		crawler.client = crawler.newClient()
+
+		Convey("When I fetch a page from the host", func() {
+			_, _, err := crawler.get(specialURL)
+			So(err, ShouldBeNil)
+
+			Convey("Then I send the User-Agent, and the host's headers replace the others", func() {
+				So(len(received), ShouldEqual, 1)
+				So(received[0].Get("User-Agent"), ShouldEqual, "webcp-test")
+				So(received[0].Get("Accept-Language"), ShouldEqual, "en-US")
+				So(received[0]["X-Team"], ShouldResemble, []string{"wiki"})
+				So(received[0].Get("X-Key"), ShouldEqual, "abc")
+			})
+		})
+
+		Convey("When I follow a redirect to the other host", func() {
+			away, _ := specialURL.Parse("/away")
+			_, _, err := crawler.get(away)
+			So(err, ShouldBeNil)
+
+			Convey("Then the other host only gets the headers for every host", func() {
+				So(len(received), ShouldEqual, 2)
+				So(received[1].Get("User-Agent"), ShouldEqual, "webcp-test")
+				So(received[1].Get("Accept-Language"), ShouldEqual, "en-US")
+				So(received[1]["X-Team"], ShouldResemble, []string{"docs"})
+				So(received[1].Get("X-Key"), ShouldEqual, "")
+			})
+		})
+
+		Convey("When I follow redirects through the other host to a third host", func() {
+			further, _ := specialURL.Parse("/further")
+			_, _, err := crawler.get(further)
+			So(err, ShouldBeNil)
+
+			Convey("Then neither later host gets the first host's headers", func() {
+				So(len(received), ShouldEqual, 3)
+				for _, header := range received[1:] {
+					So(header["X-Team"], ShouldResemble, []string{"docs"})
+					So(header.Get("X-Key"), ShouldEqual, "")
+				}
+			})
+		})
+	})
+}
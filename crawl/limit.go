@@ -0,0 +1,429 @@
+package crawl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The most we will slow down requests to a host that asks us to back off
+	maxSlowdown = 64
+
+	// The rate to slow down from, for a host without a rate limit
+	backoffRate = 1.0
+)
+
+// A value for a field of a per-host override which removes the default's
+// limit, where 0 would keep it
+const NoLimit = -1
+
+// Request limits for a single host. In a per-host override, zero fields keep
+// the default limit, and NoLimit fields remove it.
+type HostLimit struct {
+
+	// The maximum sustained requests per second (0 for no limit)
+	Rate float64 `json:"rate"`
+
+	// The number of requests which may be made at once before the rate
+	// limit applies
+	Burst int `json:"burst"`
+
+	// The maximum number of simultaneous requests (0 for no limit)
+	Concurrency int `json:"concurrency"`
+}
+
+// Limits the rate of requests to each host, and the bandwidth used by all
+// downloads
+type RateLimiter struct {
+
+	// The limits for hosts without an override
+	Default HostLimit
+
+	// Per-host overrides of the default limits
+	Hosts map[string]HostLimit
+
+	// The maximum bytes per second to download across all hosts (0 for no
+	// limit)
+	Bandwidth int64
+
+	mu        sync.Mutex
+	hosts     map[string]*hostState
+	bandwidth bucket
+}
+
+// The current limits for a single host
+type hostState struct {
+	limit    HostLimit
+	bucket   bucket
+	slowdown float64
+	slots    chan struct{}
+	active   int
+}
+
+// The current state of requests to a single host
+type HostStatus struct {
+
+	// The host's limits
+	Limit HostLimit `json:"limit"`
+
+	// The request rate after any slowdown the host asked for (0 for no limit)
+	CurrentRate float64 `json:"current_rate"`
+
+	// How much the host has asked us to slow down (1 for not at all)
+	Slowdown float64 `json:"slowdown"`
+
+	// The number of requests to the host which are in flight
+	Active int `json:"active"`
+}
+
+// Create a new rate limiter. Zero fields in the per-host limits are inherited
+// from the defaults.
+func NewRateLimiter(def HostLimit, hosts map[string]HostLimit, bandwidth int64) *RateLimiter {
+	return &RateLimiter{
+		Default:   def,
+		Hosts:     hosts,
+		Bandwidth: bandwidth,
+		hosts:     make(map[string]*hostState),
+		bandwidth: newBucket(float64(bandwidth), float64(bandwidth)),
+	}
+}
+
+// Wait until we may send a request to a host. The returned function must be
+// called once the request is complete.
+func (limiter *RateLimiter) Acquire(host string) (release func()) {
+	// Keep hold of the slots we acquire, in case the limit is changed while
+	// the request is in flight
+	limiter.mu.Lock()
+	state := limiter.host(host)
+	slots := state.slots
+	limiter.mu.Unlock()
+	if slots != nil {
+		slots <- struct{}{}
+	}
+	limiter.mu.Lock()
+	state.active++
+	wait := state.bucket.take(1, time.Now())
+	limiter.mu.Unlock()
+	time.Sleep(wait)
+
+	return func() {
+		limiter.mu.Lock()
+		state.active--
+		limiter.mu.Unlock()
+		if slots != nil {
+			<-slots
+		}
+	}
+}
+
+// Adjust a host's request rate based on a response. Hosts which respond with
+// 429 Too Many Requests or 503 Service Unavailable are slowed down, and hosts
+// which respond normally are gradually allowed to speed up again.
+func (limiter *RateLimiter) Feedback(host string, status int, retryAfter time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	state := limiter.host(host)
+	now := time.Now()
+	state.bucket.take(0, now)
+
+	if TooBusy(status) {
+		state.slowdown = math.Min(state.slowdown*2, maxSlowdown)
+	} else if status < 400 {
+		state.slowdown = math.Max(state.slowdown*0.9, 1)
+	}
+	state.bucket.rate = state.rate()
+	if TooBusy(status) && retryAfter > 0 {
+		state.bucket.delay(retryAfter, now)
+	}
+}
+
+// Throttle a download so all downloads together stay within the bandwidth
+// limit. The waits aren't counted against the timeouts of the request with
+// the given context.
+func (limiter *RateLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	limiter.mu.Lock()
+	bandwidth := limiter.Bandwidth
+	limiter.mu.Unlock()
+	if bandwidth <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, limiter: limiter, ctx: ctx, max: int(bandwidth)}
+}
+
+// Get the state for a host, creating it if necessary. The caller must hold
+// the lock.
+func (limiter *RateLimiter) host(host string) *hostState {
+	if state, ok := limiter.hosts[host]; ok {
+		return state
+	}
+	limit := limiter.limit(host)
+	state := &hostState{
+		limit:    limit,
+		slowdown: 1,
+	}
+	state.bucket = newBucket(state.rate(), float64(limit.Burst))
+	if limit.Concurrency > 0 {
+		state.slots = make(chan struct{}, limit.Concurrency)
+	}
+	limiter.hosts[host] = state
+	return state
+}
+
+// Get the limits for a host, merging any override with the defaults. The
+// caller must hold the lock.
+func (limiter *RateLimiter) limit(host string) HostLimit {
+	return limiter.Default.merge(limiter.Hosts[host], false)
+}
+
+// Replace the fields of the limits which are set in an override, keeping
+// those which are zero. NoLimit fields are kept as NoLimit when merging two
+// overrides, and otherwise remove the limit.
+func (limit HostLimit) merge(override HostLimit, overrides bool) HostLimit {
+	field := func(value float64) float64 {
+		if value == NoLimit && !overrides {
+			return 0
+		}
+		return value
+	}
+	if override.Rate != 0 {
+		limit.Rate = field(override.Rate)
+	}
+	if override.Burst != 0 {
+		limit.Burst = int(field(float64(override.Burst)))
+	}
+	if override.Concurrency != 0 {
+		limit.Concurrency = int(field(float64(override.Concurrency)))
+	}
+	return limit
+}
+
+// Change the limits for a host, or the default limits if host is empty.
+// Requests already in flight are not affected.
+func (limiter *RateLimiter) SetLimit(host string, limit HostLimit) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.setLimit(host, limit)
+}
+
+// Change only the limits set in an override for a host, or for the defaults
+// if host is empty, keeping the rest
+func (limiter *RateLimiter) UpdateLimit(host string, override HostLimit) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if host == "" {
+		limiter.setLimit(host, limiter.Default.merge(override, false))
+	} else {
+		limiter.setLimit(host, limiter.Hosts[host].merge(override, true))
+	}
+}
+
+// Change the limits for a host, or the default limits if host is empty. The
+// caller must hold the lock.
+func (limiter *RateLimiter) setLimit(host string, limit HostLimit) {
+	if host == "" {
+		limiter.Default = limit
+	} else {
+		hosts := make(map[string]HostLimit, len(limiter.Hosts)+1)
+		for name, override := range limiter.Hosts {
+			hosts[name] = override
+		}
+		hosts[host] = limit
+		limiter.Hosts = hosts
+	}
+
+	// Apply the new limits to the hosts we have already seen
+	for name, state := range limiter.hosts {
+		if host != "" && name != host {
+			continue
+		}
+		limit := limiter.limit(name)
+		if limit.Concurrency != state.limit.Concurrency {
+			state.slots = nil
+			if limit.Concurrency > 0 {
+				state.slots = make(chan struct{}, limit.Concurrency)
+			}
+		}
+		state.limit = limit
+		state.bucket.take(0, time.Now())
+		state.bucket.rate = state.rate()
+		state.bucket.burst = math.Max(float64(limit.Burst), 1)
+	}
+}
+
+// Get the state of each host we have sent requests to
+func (limiter *RateLimiter) Status() map[string]HostStatus {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	status := make(map[string]HostStatus, len(limiter.hosts))
+	for host, state := range limiter.hosts {
+		status[host] = HostStatus{
+			Limit:       state.limit,
+			CurrentRate: state.rate(),
+			Slowdown:    state.slowdown,
+			Active:      state.active,
+		}
+	}
+	return status
+}
+
+// Get the current request rate for a host, after any slowdown
+func (state *hostState) rate() float64 {
+	rate := state.limit.Rate
+	if state.slowdown <= 1 {
+		return rate
+	} else if rate <= 0 {
+		rate = backoffRate
+	}
+	return rate / state.slowdown
+}
+
+// A token bucket, refilled continuously at a fixed rate
+type bucket struct {
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+// Create a full token bucket
+func newBucket(rate, burst float64) bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return bucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Take tokens from the bucket, returning how long to wait until they have
+// been refilled. A rate of zero means no limit.
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Empty the bucket, so no tokens are available for a given time
+func (b *bucket) delay(wait time.Duration, now time.Time) {
+	b.take(0, now)
+	b.tokens = math.Min(b.tokens, -wait.Seconds()*b.rate)
+}
+
+// A reader throttled by a rate limiter's bandwidth limit
+type limitedReader struct {
+	r       io.Reader
+	limiter *RateLimiter
+	ctx     context.Context
+
+	// The most to read at once: the bandwidth limit when the reader was made
+	max int
+}
+
+// Read from the underlying reader, waiting for bandwidth as needed
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.max {
+		p = p[:lr.max]
+	}
+	n, err := lr.r.Read(p)
+	lr.limiter.mu.Lock()
+	wait := lr.limiter.bandwidth.take(float64(n), time.Now())
+	lr.limiter.mu.Unlock()
+	if wait > 0 {
+		excludeWait(lr.ctx, func() {
+			time.Sleep(wait)
+		})
+	}
+	return n, err
+}
+
+// Ask whether a response status means the server wants us to slow down
+func TooBusy(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// Get the delay requested by a response's Retry-After header, if any
+func RetryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	} else if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second
+	} else if when, err := http.ParseTime(value); err == nil {
+		return when.Sub(time.Now())
+	}
+	return 0
+}
+
+// Load per-host limits from a file. Each line names a host, followed by any
+// of rate=<requests/sec>, burst=<num>, and concurrency=<num>, where 0 means no
+// limit. Limits left out keep the defaults. Blank lines and lines starting
+// with # are ignored.
+func LoadHostLimits(path string) (map[string]HostLimit, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseHostLimits(file, path)
+}
+
+// Parse per-host limits in the format read by LoadHostLimits(). The name is
+// used in error messages.
+func ParseHostLimits(r io.Reader, name string) (map[string]HostLimit, error) {
+	limits := make(map[string]HostLimit)
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var limit HostLimit
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("%s:%d: Invalid limit %q", name, lineNum, field)
+			}
+			var err error
+			switch parts[0] {
+			case "rate":
+				if limit.Rate, err = strconv.ParseFloat(parts[1], 64); limit.Rate == 0 {
+					limit.Rate = NoLimit
+				}
+			case "burst":
+				if limit.Burst, err = strconv.Atoi(parts[1]); limit.Burst == 0 {
+					limit.Burst = NoLimit
+				}
+			case "concurrency":
+				if limit.Concurrency, err = strconv.Atoi(parts[1]); limit.Concurrency == 0 {
+					limit.Concurrency = NoLimit
+				}
+			default:
+				err = fmt.Errorf("Unknown limit")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: Invalid limit %q - %v", name, lineNum, field, err)
+			}
+		}
+		limits[fields[0]] = limit
+	}
+	return limits, scanner.Err()
+}
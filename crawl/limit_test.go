@@ -0,0 +1,228 @@
+package crawl
+
+import (
+	"bytes"
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	Convey("Given a rate limiter with a per-host override", t, func() {
+		limiter := NewRateLimiter(HostLimit{
+			Rate:        10,
+			Concurrency: 1,
+		}, map[string]HostLimit{
+			"fast.com": HostLimit{Rate: 100, Burst: 2, Concurrency: 2},
+		}, 0)
+
+		Convey("When I make requests to the same host", func() {
+			before := time.Now()
+			limiter.Acquire("slow.com")()
+			limiter.Acquire("slow.com")()
+			after := time.Now()
+
+			Convey("Then I wait for the default rate", func() {
+				So(after.Sub(before), ShouldBeBetween, 90*time.Millisecond, 110*time.Millisecond)
+			})
+		})
+
+		Convey("When I make requests to different hosts", func() {
+			before := time.Now()
+			limiter.Acquire("slow.com")()
+			limiter.Acquire("other.com")()
+			after := time.Now()
+
+			Convey("Then I don't wait", func() {
+				So(after.Sub(before), ShouldBeLessThan, time.Millisecond)
+			})
+		})
+
+		Convey("When I make a burst of requests to an overridden host", func() {
+			before := time.Now()
+			limiter.Acquire("fast.com")()
+			limiter.Acquire("fast.com")()
+			limiter.Acquire("fast.com")()
+			after := time.Now()
+
+			Convey("Then I only wait once the burst is used up", func() {
+				So(after.Sub(before), ShouldBeBetween, 5*time.Millisecond, 15*time.Millisecond)
+			})
+		})
+
+		Convey("When a host has too many requests in flight", func() {
+			release := limiter.Acquire("slow.com")
+			acquired := make(chan bool)
+			go func() {
+				limiter.Acquire("slow.com")()
+				acquired <- true
+			}()
+
+			Convey("Then I wait for a request to finish", func() {
+				select {
+				case <-acquired:
+					t.Error("Acquired a second slot")
+				case <-time.After(150 * time.Millisecond):
+				}
+				release()
+				So(<-acquired, ShouldBeTrue)
+			})
+		})
+
+		Convey("When a host asks me to slow down", func() {
+			limiter.Acquire("slow.com")()
+			limiter.Feedback("slow.com", http.StatusServiceUnavailable, 0)
+			before := time.Now()
+			limiter.Acquire("slow.com")()
+			after := time.Now()
+
+			Convey("Then I halve the rate", func() {
+				So(after.Sub(before), ShouldBeBetween, 190*time.Millisecond, 210*time.Millisecond)
+			})
+		})
+
+		Convey("When a host asks me to retry after a delay", func() {
+			limiter.Acquire("fast.com")()
+			limiter.Feedback("fast.com", http.StatusTooManyRequests, 200*time.Millisecond)
+			before := time.Now()
+			limiter.Acquire("fast.com")()
+			after := time.Now()
+
+			Convey("Then I wait for the delay", func() {
+				So(after.Sub(before), ShouldBeBetween, 200*time.Millisecond, 230*time.Millisecond)
+			})
+		})
+
+		Convey("When I raise a host's limits while a request is in flight", func() {
+			release := limiter.Acquire("slow.com")
+			limiter.SetLimit("slow.com", HostLimit{Rate: 1000, Concurrency: 2})
+			before := time.Now()
+			limiter.Acquire("slow.com")()
+			after := time.Now()
+			release()
+
+			Convey("Then the new limits apply", func() {
+				So(after.Sub(before), ShouldBeLessThan, 10*time.Millisecond)
+				status := limiter.Status()["slow.com"]
+				So(status.Limit, ShouldResemble, HostLimit{Rate: 1000, Concurrency: 2})
+				So(status.Active, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I change a host's limits while requests are being made", func() {
+			limiter.SetLimit("slow.com", HostLimit{Rate: 1000, Concurrency: 2})
+			done := make(chan struct{})
+			go func() {
+				for i := 0; i < 20; i++ {
+					limiter.Acquire("slow.com")()
+				}
+				close(done)
+			}()
+			for i := 0; i < 20; i++ {
+				limiter.UpdateLimit("slow.com", HostLimit{Concurrency: 1 + i%2})
+			}
+			<-done
+
+			Convey("Then every request is released", func() {
+				So(limiter.Status()["slow.com"].Active, ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given a fetcher within a rate limiter's limits", t, func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		Reset(func() {
+			srv.Close()
+		})
+		srvURL, _ := url.Parse(srv.URL)
+		limiter := NewRateLimiter(HostLimit{Rate: 10, Concurrency: 1}, nil, 0)
+		fetcher := limitedFetcher{http.DefaultClient, limiter}
+
+		Convey("When the host it sends to asks me to slow down", func() {
+			req, _ := http.NewRequest("GET", srv.URL+"/page.html", nil)
+			resp, err := fetcher.Do(req)
+			So(err, ShouldBeNil)
+
+			Convey("Then that host is slowed down", func() {
+				So(limiter.Status()[srvURL.Host].Slowdown, ShouldBeGreaterThan, 1)
+			})
+
+			Convey("Then its slot is held until the body is closed", func() {
+				So(limiter.Status()[srvURL.Host].Active, ShouldEqual, 1)
+				resp.Body.Close()
+				resp.Body.Close()
+				So(limiter.Status()[srvURL.Host].Active, ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given a rate limiter with a bandwidth limit", t, func() {
+		limiter := NewRateLimiter(HostLimit{}, nil, 1000)
+
+		Convey("When I download more than the limit", func() {
+			before := time.Now()
+			body, err := ioutil.ReadAll(limiter.Reader(context.Background(), bytes.NewReader(make([]byte, 1500))))
+			after := time.Now()
+
+			Convey("Then the download is throttled", func() {
+				So(err, ShouldBeNil)
+				So(len(body), ShouldEqual, 1500)
+				So(after.Sub(before), ShouldBeBetween, 450*time.Millisecond, 550*time.Millisecond)
+			})
+		})
+	})
+}
+
+func TestLoadHostLimits(t *testing.T) {
+	Convey("Given a host limits file", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		path := filepath.Join(folder, "limits.txt")
+
+		Convey("When the file is valid", func() {
+			So(ioutil.WriteFile(path, []byte(`# Our own servers can take it
+internal.com  rate=20 burst=5 concurrency=4
+
+slow.com      rate=0.2
+open.com      rate=0 concurrency=0
+`), 0644), ShouldBeNil)
+			limits, err := LoadHostLimits(path)
+
+			Convey("Then I get the limits", func() {
+				So(err, ShouldBeNil)
+				So(limits, ShouldResemble, map[string]HostLimit{
+					"internal.com": HostLimit{Rate: 20, Burst: 5, Concurrency: 4},
+					"slow.com":     HostLimit{Rate: 0.2},
+					"open.com":     HostLimit{Rate: NoLimit, Concurrency: NoLimit},
+				})
+			})
+
+			Convey("Then limits set to 0 remove the defaults", func() {
+				limiter := NewRateLimiter(HostLimit{Rate: 1, Burst: 2, Concurrency: 3}, limits, 0)
+				So(limiter.limit("open.com"), ShouldResemble, HostLimit{Burst: 2})
+				So(limiter.limit("slow.com"), ShouldResemble, HostLimit{Rate: 0.2, Burst: 2, Concurrency: 3})
+			})
+		})
+
+		Convey("When the file has an unknown limit", func() {
+			So(ioutil.WriteFile(path, []byte("slow.com speed=1\n"), 0644), ShouldBeNil)
+			_, err := LoadHostLimits(path)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
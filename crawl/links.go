@@ -0,0 +1,125 @@
+package crawl
+
+import (
+	"code.google.com/p/go.net/html"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// A link found on a page
+type Link struct {
+
+	// The page linked to
+	URL *url.URL
+
+	// The link's anchor text, or the alt text of an image or image map area,
+	// with runs of whitespace collapsed
+	Text string
+
+	// The name of the element holding the link, such as "a" or "img"
+	Tag string
+}
+
+// The attribute holding the URL for each element which links to another page
+// or resource
+var linkAttrs = map[string]string{
+	"a":      "href",
+	"area":   "href",
+	"embed":  "src",
+	"frame":  "src",
+	"iframe": "src",
+	"img":    "src",
+	"link":   "href",
+	"script": "src",
+	"source": "src",
+}
+
+// Ask whether the crawler should follow a link to crawl the page it points to.
+// Only hyperlinks are followed; the other links, to resources such as frames,
+// images, scripts and stylesheets, are recorded but not crawled.
+func (link Link) Followed() bool {
+	return link.Tag == "a"
+}
+
+// Find the links on a page, resolving them against the page's URL. Links
+// which can't be parsed are ignored. If the page can't be parsed, the links
+// found before the error are returned with it.
+func ParseLinks(source *url.URL, body io.Reader) ([]Link, error) {
+	var (
+		links   []Link
+		current *Link
+		text    []string
+	)
+	finish := func() {
+		if current != nil {
+			current.Text = collapseSpace(strings.Join(text, " "))
+			links = append(links, *current)
+			current, text = nil, nil
+		}
+	}
+
+	tok := html.NewTokenizer(body)
+	for {
+		switch token := tok.Next(); token {
+		case html.ErrorToken:
+			finish()
+			if err := tok.Err(); err != io.EOF {
+				return links, err
+			}
+			return links, nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, more := tok.TagName()
+			tag := string(name)
+			attr, ok := linkAttrs[tag]
+			if !ok {
+				continue
+			}
+			if tag == "a" {
+				finish()
+			}
+			var (
+				key, val []byte
+				link     *Link
+				alt      string
+			)
+			for more {
+				key, val, more = tok.TagAttr()
+				switch string(key) {
+				case attr:
+					if site, err := source.Parse(strings.TrimSpace(string(val))); err == nil {
+						link = &Link{URL: site, Tag: tag}
+					}
+				case "alt":
+					alt = collapseSpace(string(val))
+				}
+			}
+			if link == nil {
+				continue
+			} else if tag == "a" && token == html.StartTagToken {
+				current = link
+			} else {
+				if tag == "area" || tag == "img" {
+					link.Text = alt
+				}
+				links = append(links, *link)
+			}
+
+		case html.EndTagToken:
+			if tag, _ := tok.TagName(); string(tag) == "a" {
+				finish()
+			}
+
+		case html.TextToken:
+			if current != nil {
+				text = append(text, string(tok.Text()))
+			}
+		}
+	}
+}
+
+// Collapse runs of whitespace to single spaces, and trim the ends
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
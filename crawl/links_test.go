@@ -0,0 +1,78 @@
+package crawl
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	source, _ := url.Parse("http://domain.com/docs/index.html")
+
+	Convey("When I parse a page with links", t, func() {
+		links, err := ParseLinks(source, strings.NewReader(`<html><body>
+<a href="page.html">The  <b>first</b>
+  page</a>
+<a name="top">Not a link</a>
+<a href="/other.html"><img src="icon.png"></a>
+<a href="http://domain2.com/">Unclosed
+</body></html>`))
+
+		Convey("Then I get each link's URL and text", func() {
+			So(err, ShouldBeNil)
+			So(len(links), ShouldEqual, 4)
+			So(links[0].URL.String(), ShouldEqual, "http://domain.com/docs/page.html")
+			So(links[0].Text, ShouldEqual, "The first page")
+			So(links[2].URL.String(), ShouldEqual, "http://domain.com/other.html")
+			So(links[2].Text, ShouldEqual, "")
+			So(links[3].URL.String(), ShouldEqual, "http://domain2.com/")
+			So(links[3].Text, ShouldEqual, "Unclosed")
+		})
+
+		Convey("Then I get the image inside a link", func() {
+			So(links[1].URL.String(), ShouldEqual, "http://domain.com/docs/icon.png")
+			So(links[1].Tag, ShouldEqual, "img")
+			So(links[1].Followed(), ShouldBeFalse)
+		})
+	})
+
+	Convey("When I parse a page with links to resources and frames", t, func() {
+		links, err := ParseLinks(source, strings.NewReader(`<html><head>
+<link rel="stylesheet" href="style.css">
+<script src="/app.js"></script>
+</head><body>
+<img src="photo.jpg" alt="A   photo" />
+<map><area href="region.html" alt="Region"></map>
+<iframe src="frame.html"></iframe>
+</body></html>`))
+
+		Convey("Then I get each link's element", func() {
+			So(err, ShouldBeNil)
+			var tags, urls []string
+			for _, link := range links {
+				tags = append(tags, link.Tag)
+				urls = append(urls, link.URL.String())
+			}
+			So(tags, ShouldResemble, []string{"link", "script", "img", "area", "iframe"})
+			So(urls, ShouldResemble, []string{
+				"http://domain.com/docs/style.css",
+				"http://domain.com/app.js",
+				"http://domain.com/docs/photo.jpg",
+				"http://domain.com/docs/region.html",
+				"http://domain.com/docs/frame.html",
+			})
+		})
+
+		Convey("Then I get the alt text of images and areas", func() {
+			So(links[2].Text, ShouldEqual, "A photo")
+			So(links[3].Text, ShouldEqual, "Region")
+		})
+
+		Convey("Then I only follow hyperlinks", func() {
+			for _, link := range links {
+				So(link.Followed(), ShouldBeFalse)
+			}
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package crawl
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// A line of the crawl's manifest, which records each page saved
+type ManifestEntry struct {
+
+	// The path, relative to the crawl folder, at which the page was saved
+	Path string `json:"path"`
+
+	// The page's URL
+	URL string `json:"url"`
+
+	// Where the page was fetched from, such as SourceNameLive or
+	// SourceNameWayback
+	Source string `json:"source"`
+
+	// When an archive captured the page, in the archive's YYYYMMDDHHMMSS
+	// format, or "" for a page fetched from the live site
+	Captured string `json:"captured,omitempty"`
+}
+
+// Appends entries to the manifest, as lines of JSON
+type manifestWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// Open the manifest, adding to any entries from an earlier session
+func openManifest(path string) (*manifestWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestWriter{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record a saved page
+func (manifest *manifestWriter) add(path string, site *url.URL, source string, captured time.Time) error {
+	entry := ManifestEntry{Path: path, URL: site.String(), Source: source}
+	if !captured.IsZero() {
+		entry.Captured = captured.Format(waybackTimestamp)
+	}
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+	return manifest.enc.Encode(entry)
+}
+
+// Close the manifest
+func (manifest *manifestWriter) Close() error {
+	return manifest.file.Close()
+}
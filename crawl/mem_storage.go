@@ -1,40 +1,81 @@
 package crawl
 
-import (
-	"net/url"
-)
-
 // Memory-based storage for smaller crawls
 type MemQueueStorage struct {
-	Items []MemItem
+	Items   []QueueItem
+	Digests map[string]string
+	Links   []Edge
+	Jar     []string
+	Spent   map[string]int
 }
 
 // Create a new memory storage object
 func NewMemQueueStorage() *MemQueueStorage {
-	return &MemQueueStorage{}
-}
-
-type MemItem struct {
-	URL   *url.URL
-	Depth int
+	return &MemQueueStorage{
+		Digests: make(map[string]string),
+		Spent:   make(map[string]int),
+	}
 }
 
 // Store a new page to crawl later
-func (storage *MemQueueStorage) Add(site *url.URL, depth int) {
-	storage.Items = append(storage.Items, MemItem{
-		URL:   site,
-		Depth: depth,
-	})
+func (storage *MemQueueStorage) Add(item QueueItem) {
+	storage.Items = append(storage.Items, item)
 }
 
 // Get a page to crawl
-func (storage *MemQueueStorage) Next() (*url.URL, int) {
+func (storage *MemQueueStorage) Next() (QueueItem, bool) {
 	if len(storage.Items) == 0 {
-		return nil, 0
+		return QueueItem{}, false
 	}
 	item := storage.Items[0]
 	storage.Items = storage.Items[1:]
-	return item.URL, item.Depth
+	return item, true
+}
+
+// Record the digest of a page's content, and the path where it was saved
+func (storage *MemQueueStorage) AddDigest(digest, path string) {
+	storage.Digests[digest] = path
+}
+
+// Get the path where content with a given digest was saved, if any
+func (storage *MemQueueStorage) Digest(digest string) (path string, ok bool) {
+	path, ok = storage.Digests[digest]
+	return
+}
+
+// Record a link from one page to another
+func (storage *MemQueueStorage) AddEdge(edge Edge) {
+	storage.Links = append(storage.Links, edge)
+}
+
+// Get the links recorded so far
+func (storage *MemQueueStorage) Edges() ([]Edge, error) {
+	return storage.Links, nil
+}
+
+// Record a cookie, formatted as a line of a Netscape cookies file
+func (storage *MemQueueStorage) AddCookie(line string) {
+	storage.Jar = append(storage.Jar, line)
+}
+
+// Get the cookies recorded so far
+func (storage *MemQueueStorage) Cookies() []string {
+	return storage.Jar
+}
+
+// Record a page counted against a quota
+func (storage *MemQueueStorage) AddQuotaPage(match string) {
+	storage.Spent[match]++
+}
+
+// Get the number of pages counted against each quota so far
+func (storage *MemQueueStorage) QuotaPages() map[string]int {
+	return storage.Spent
+}
+
+// Get the number of pages waiting to be crawled
+func (storage *MemQueueStorage) Len() int {
+	return len(storage.Items)
 }
 
 // Close the storage
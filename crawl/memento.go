@@ -0,0 +1,222 @@
+package crawl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The path which pywb, OpenWayback and the Wayback Machine add to their
+// TimeGate URLs to give their TimeMaps in link format
+const defaultTimeMapPath = "timemap/link/"
+
+// The response header in which an archive is named, so the manifest can
+// record which archive served each page
+const archiveHeader = "X-Webcp-Archive"
+
+// A web archive which speaks the Memento protocol (RFC 7089), such as a pywb
+// or OpenWayback instance
+type MementoArchive struct {
+
+	// The archive's name, recorded in the manifest for the pages it serves
+	Name string
+
+	// The archive's TimeGate, to which a page's URL is appended to ask for
+	// the capture closest to the Accept-Datetime header
+	TimeGate string
+
+	// The archive's TimeMap in link format, to which a page's URL is
+	// appended to list its captures (default TimeGate + "timemap/link/")
+	TimeMap string
+}
+
+// Get the prefix of the archive's TimeMap URLs
+func (archive MementoArchive) timeMap() string {
+	if archive.TimeMap == "" {
+		return archive.TimeGate + defaultTimeMapPath
+	}
+	return archive.TimeMap
+}
+
+// A capture of a page listed in a TimeMap
+type memento struct {
+	URL      *url.URL
+	Datetime time.Time
+}
+
+// Fetch a page from the first of the Memento archives with a capture of it in
+// the date range. If every archive fails, the last error is returned;
+// otherwise a page which no archive has is not found.
+func (fetcher *WaybackFetcher) doMemento(req *http.Request) (*http.Response, error) {
+	var (
+		lastErr error
+		failed  int
+	)
+	target := fetcher.target(req.URL)
+	for _, archive := range fetcher.Archives {
+		resp, err := fetcher.fromArchive(archive, req, target)
+		if err != nil {
+			lastErr = err
+			failed++
+			continue
+		} else if resp.StatusCode >= 400 {
+			resp.Body.Close()
+			continue
+		}
+		resp.Header.Set(archiveHeader, archive.Name)
+		fetcher.noteFetched(req.URL, capturedAt(resp))
+		return resp, nil
+	}
+	if failed == len(fetcher.Archives) {
+		return nil, lastErr
+	}
+	return newResponse(req, http.StatusNotFound, nil, nil), nil
+}
+
+// Fetch a page's capture from an archive. The TimeGate is asked for the
+// capture closest to the target time, and if that is outside the date range
+// the TimeMap is searched for the capture the Strategy prefers within it.
+func (fetcher *WaybackFetcher) fromArchive(archive MementoArchive, req *http.Request, target time.Time) (*http.Response, error) {
+	resp, err := fetcher.sendArchive(req, archive.TimeGate+req.URL.String(), func(areq *http.Request) {
+		areq.Header.Set("Accept-Datetime", target.UTC().Format(http.TimeFormat))
+	})
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode >= 400 {
+		return resp, nil
+	}
+	captured := capturedAt(resp)
+	if captured.IsZero() {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s did not return a memento of %s", archive.Name, req.URL)
+	} else if fetcher.inRange(captured) {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	mementos, err := fetcher.listMementos(archive, req)
+	if err != nil {
+		return nil, err
+	}
+	times := make([]time.Time, len(mementos))
+	for i, m := range mementos {
+		times[i] = m.Datetime
+	}
+	chosen := fetcher.pick(times, target)
+	if chosen < 0 {
+		return newResponse(req, http.StatusNotFound, nil, nil), nil
+	}
+	resp, err = fetcher.sendArchive(req, mementos[chosen].URL.String(), nil)
+	if err == nil && resp.StatusCode < 400 && capturedAt(resp).IsZero() {
+		resp.Header.Set("Memento-Datetime", mementos[chosen].Datetime.Format(http.TimeFormat))
+	}
+	return resp, err
+}
+
+// List the captures of a page in an archive's TimeMap
+func (fetcher *WaybackFetcher) listMementos(archive MementoArchive, req *http.Request) ([]memento, error) {
+	resp, err := fetcher.sendArchive(req, archive.timeMap()+req.URL.String(), func(areq *http.Request) {
+		areq.Header.Set("Accept", "application/link-format")
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not list the mementos of %s in %s - %s",
+			req.URL, archive.Name, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseTimeMap(resp.Request.URL, string(body)), nil
+}
+
+// Send a request for a page to an archive. Only the archiveHeaders of the
+// request for the page are sent, so credentials, cookies and headers meant
+// for the page's host never reach the archive.
+func (fetcher *WaybackFetcher) sendArchive(req *http.Request, archived string, prepare func(*http.Request)) (*http.Response, error) {
+	location, err := url.Parse(archived)
+	if err != nil {
+		return nil, err
+	}
+	areq, err := archiveRequest(req, location)
+	if err != nil {
+		return nil, err
+	}
+	if prepare != nil {
+		prepare(areq)
+	}
+	return fetcher.client().Do(areq)
+}
+
+// Parse the mementos listed in a TimeMap in link format (RFC 6690). Each link
+// is a URL in angle brackets followed by parameters separated by semicolons;
+// mementos have a rel parameter including "memento" and a datetime.
+// Relative URLs are resolved against the TimeMap's URL.
+func parseTimeMap(base *url.URL, body string) []memento {
+	var mementos []memento
+	for {
+		start := strings.IndexByte(body, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(body[start:], '>')
+		if end < 0 {
+			break
+		}
+		target := body[start+1 : start+end]
+		body = body[start+end+1:]
+
+		// The parameters run to the next link, skipping quoted commas
+		quoted := false
+		i := 0
+		for ; i < len(body); i++ {
+			if body[i] == '"' {
+				quoted = !quoted
+			} else if body[i] == ',' && !quoted {
+				break
+			}
+		}
+		params := body[:i]
+		body = body[i:]
+
+		var (
+			rel      []string
+			datetime string
+		)
+		for _, param := range strings.Split(params, ";") {
+			parts := strings.SplitN(param, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			value := strings.Trim(strings.TrimSpace(parts[1]), `"`)
+			switch strings.ToLower(strings.TrimSpace(parts[0])) {
+			case "rel":
+				rel = strings.Fields(value)
+			case "datetime":
+				datetime = value
+			}
+		}
+		isMemento := false
+		for _, r := range rel {
+			isMemento = isMemento || r == "memento"
+		}
+		ts, err := http.ParseTime(datetime)
+		if !isMemento || err != nil {
+			continue
+		}
+		location, err := base.Parse(target)
+		if err != nil {
+			continue
+		}
+		mementos = append(mementos, memento{URL: location, Datetime: ts.UTC()})
+	}
+	return mementos
+}
@@ -0,0 +1,277 @@
+package crawl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake Memento archive, with a TimeGate at /tg/, TimeMaps at
+// /tg/timemap/link/ and captures at /m/<timestamp>/<url>
+type fakeMemento struct {
+	*httptest.Server
+
+	// The captured pages, keyed by URL and then by timestamp
+	pages map[string]map[string]string
+
+	mu       sync.Mutex
+	requests []string
+	headers  []http.Header
+}
+
+func newFakeMemento(pages map[string]map[string]string) *fakeMemento {
+	archive := &fakeMemento{pages: pages}
+	archive.Server = httptest.NewServer(http.HandlerFunc(archive.serve))
+	return archive
+}
+
+// Describe the archive for a crawl
+func (archive *fakeMemento) archive(name string) MementoArchive {
+	return MementoArchive{Name: name, TimeGate: archive.URL + "/tg/"}
+}
+
+// Serve the TimeGate, the TimeMaps and the captures
+func (archive *fakeMemento) serve(w http.ResponseWriter, r *http.Request) {
+	archive.mu.Lock()
+	defer archive.mu.Unlock()
+	archive.requests = append(archive.requests, r.URL.Path)
+	archive.headers = append(archive.headers, r.Header)
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/tg/timemap/link/"):
+		site := strings.TrimPrefix(r.URL.Path, "/tg/timemap/link/")
+		if _, ok := archive.pages[site]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/link-format")
+		fmt.Fprintf(w, "<%s>; rel=\"original\",\n", site)
+		for _, ts := range archive.timestamps(site) {
+			fmt.Fprintf(w, "</m/%s/%s>; rel=\"memento\"; datetime=\"%s\",\n",
+				ts, site, archiveTime(ts).Format(http.TimeFormat))
+		}
+
+	case strings.HasPrefix(r.URL.Path, "/tg/"):
+		site := strings.TrimPrefix(r.URL.Path, "/tg/")
+		want, err := http.ParseTime(r.Header.Get("Accept-Datetime"))
+		if err != nil {
+			want = time.Now()
+		}
+		var closest string
+		for _, ts := range archive.timestamps(site) {
+			if closest == "" || absDuration(archiveTime(ts).Sub(want)) <
+				absDuration(archiveTime(closest).Sub(want)) {
+				closest = ts
+			}
+		}
+		if closest == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Vary", "accept-datetime")
+		w.Header().Set("Location", "/m/"+closest+"/"+site)
+		w.WriteHeader(http.StatusFound)
+
+	case strings.HasPrefix(r.URL.Path, "/m/"):
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/m/"), "/", 2)
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		page, ok := archive.pages[parts[1]][parts[0]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Memento-Datetime", archiveTime(parts[0]).Format(http.TimeFormat))
+		w.Write([]byte(page))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// List the timestamps of a page's captures, in order
+func (archive *fakeMemento) timestamps(site string) []string {
+	var timestamps []string
+	for ts := range archive.pages[site] {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Strings(timestamps)
+	return timestamps
+}
+
+// Get the paths requested from the archive
+func (archive *fakeMemento) requested() []string {
+	archive.mu.Lock()
+	defer archive.mu.Unlock()
+	return append([]string(nil), archive.requests...)
+}
+
+func TestMemento(t *testing.T) {
+	Convey("Given a TimeMap in link format", t, func() {
+		base, _ := url.Parse("http://archive.example.com/timemap/link/http://example.com/")
+		timemap := `<http://example.com/>; rel="original",
+<http://archive.example.com/timemap/link/http://example.com/>; rel="self"; type="application/link-format",
+<http://archive.example.com/20120101000000/http://example.com/>; rel="first memento"; datetime="Sun, 01 Jan 2012 00:00:00 GMT",
+</20130601000000/http://example.com/>; rel="memento"; datetime="Sat, 01 Jun 2013 00:00:00 GMT"; title="a, b",
+<http://archive.example.com/20140101000000/http://example.com/>; datetime="Wed, 01 Jan 2014 00:00:00 GMT"; rel="last memento"`
+
+		Convey("Then its mementos are listed", func() {
+			mementos := parseTimeMap(base, timemap)
+			So(len(mementos), ShouldEqual, 3)
+			So(mementos[0].URL.String(), ShouldEqual, "http://archive.example.com/20120101000000/http://example.com/")
+			So(mementos[0].Datetime, ShouldResemble, archiveTime("20120101000000"))
+			So(mementos[1].URL.String(), ShouldEqual, "http://archive.example.com/20130601000000/http://example.com/")
+			So(mementos[1].Datetime, ShouldResemble, archiveTime("20130601000000"))
+			So(mementos[2].Datetime, ShouldResemble, archiveTime("20140101000000"))
+		})
+	})
+
+	Convey("Given two Memento archives of a site", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		primary := newFakeMemento(map[string]map[string]string{
+			"http://example.com/docs/": {
+				"20120101000000": `<a href="old.html">Old</a> <a href="new.html">New</a>`,
+				"20150101000000": `<a href="old.html">Old</a> <a href="new.html">New</a>`,
+			},
+			"http://example.com/docs/new.html": {
+				"20110101000000": "too early",
+				"20130101000000": "new page",
+			},
+		})
+		Reset(func() {
+			primary.Close()
+		})
+		secondary := newFakeMemento(map[string]map[string]string{
+			"http://example.com/docs/old.html": {
+				"20120601000000": "old page",
+			},
+			"http://example.com/docs/new.html": {
+				"20130101000000": "secondary copy",
+			},
+		})
+		Reset(func() {
+			secondary.Close()
+		})
+		seed, _ := url.Parse("http://example.com/docs/")
+		crawler := Crawler{
+			Seeds:         []Seed{{URL: seed}},
+			Folder:        folder,
+			Manifest:      filepath.Join(folder, "manifest.jsonl"),
+			MaxDepth:      2,
+			Source:        SourceWayback,
+			WaybackAfter:  time.Date(2012, 1, 1, 0, 0, 0, 0, time.UTC),
+			WaybackBefore: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+			Archives:      []MementoArchive{primary.archive("primary"), secondary.archive("secondary")},
+		}
+		saved := func(name string) string {
+			data, err := ioutil.ReadFile(filepath.Join(folder, "example.com", "docs", name))
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+
+		Convey("When I crawl the site from the archives", func() {
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then each page comes from the first archive which has it in the date range", func() {
+				So(saved("index.html"), ShouldContainSubstring, "old.html")
+				So(saved("new.html"), ShouldEqual, "new page")
+				So(saved("old.html"), ShouldEqual, "old page")
+			})
+
+			Convey("Then the TimeGate is asked for the latest capture in the range", func() {
+				So(primary.requested(), ShouldContain, "/tg/http://example.com/docs/")
+				So(primary.requested(), ShouldContain, "/m/20120101000000/http://example.com/docs/")
+				So(primary.requested(), ShouldNotContain, "/tg/timemap/link/http://example.com/docs/new.html")
+			})
+
+			Convey("Then the TimeMap is searched when the TimeGate's capture is out of range", func() {
+				So(primary.requested(), ShouldContain, "/tg/timemap/link/http://example.com/docs/")
+			})
+
+			Convey("Then the manifest names the archive each page came from", func() {
+				file, err := os.Open(crawler.Manifest)
+				So(err, ShouldBeNil)
+				defer file.Close()
+				entries := make(map[string]ManifestEntry)
+				scanner := bufio.NewScanner(file)
+				for scanner.Scan() {
+					var entry ManifestEntry
+					So(json.Unmarshal(scanner.Bytes(), &entry), ShouldBeNil)
+					entries[filepath.Base(entry.Path)] = entry
+				}
+				So(entries["index.html"].Source, ShouldEqual, "primary")
+				So(entries["index.html"].Captured, ShouldEqual, "20120101000000")
+				So(entries["new.html"].Source, ShouldEqual, "primary")
+				So(entries["old.html"].Source, ShouldEqual, "secondary")
+				So(entries["old.html"].Captured, ShouldEqual, "20120601000000")
+			})
+		})
+
+		Convey("When I crawl the site with credentials and headers for it", func() {
+			crawler.Credentials = map[string]Credential{"example.com": {Username: "me", Password: "secret"}}
+			crawler.HostHeaders = map[string]http.Header{"example.com": {"X-Api-Key": {"site-key"}}}
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then they never reach the archives", func() {
+				for _, archive := range []*fakeMemento{primary, secondary} {
+					archive.mu.Lock()
+					So(archive.headers, ShouldNotBeEmpty)
+					for _, header := range archive.headers {
+						So(header.Get("X-Api-Key"), ShouldEqual, "")
+						So(header.Get("Authorization"), ShouldEqual, "")
+					}
+					archive.mu.Unlock()
+				}
+			})
+		})
+
+		Convey("When the first archive is down", func() {
+			primary.Close()
+			fetcher := crawler.newWaybackFetcher()
+			req, err := http.NewRequest("GET", "http://example.com/docs/new.html", nil)
+			So(err, ShouldBeNil)
+			resp, err := fetcher.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+
+			Convey("Then the page comes from the next archive", func() {
+				body, err := ioutil.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+				So(string(body), ShouldEqual, "secondary copy")
+				So(sourceOf(resp), ShouldEqual, "secondary")
+			})
+		})
+
+		Convey("When no archive has a page", func() {
+			fetcher := crawler.newWaybackFetcher()
+			req, err := http.NewRequest("GET", "http://example.com/docs/missing.html", nil)
+			So(err, ShouldBeNil)
+			resp, err := fetcher.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+
+			Convey("Then it is not found", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
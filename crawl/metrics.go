@@ -0,0 +1,186 @@
+package crawl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How often to write the crawl's metrics to the MetricsFile
+const metricsInterval = 15 * time.Second
+
+// The upper bounds, in seconds, of the fetch latency histogram's buckets
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// A histogram of observed values, in the form Prometheus expects
+type histogram struct {
+	bounds []float64
+	counts []int
+	count  int
+	sum    float64
+}
+
+// Create an empty histogram with the given bucket upper bounds
+func newHistogram(bounds []float64) histogram {
+	return histogram{
+		bounds: bounds,
+		counts: make([]int, len(bounds)),
+	}
+}
+
+// Record a value
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Write the crawl's metrics in the Prometheus text exposition format
+func (crawler *Crawler) writeMetrics(w io.Writer) error {
+	queued, inFlight, _ := crawler.queue.Counts()
+	bw := bufio.NewWriter(w)
+	crawler.stats.writeMetrics(bw)
+	writeMetric(bw, "webcp_queued_pages", "gauge",
+		"Pages waiting in the frontier.", nil, float64(queued))
+	writeMetric(bw, "webcp_in_flight_pages", "gauge",
+		"Pages being fetched.", nil, float64(inFlight))
+	return bw.Flush()
+}
+
+// Write the statistics' metrics in the Prometheus text exposition format
+func (stats *Stats) writeMetrics(w io.Writer) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	keys := make([]hostStatus, 0, len(stats.responses))
+	for key := range stats.responses {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		return keys[i].status < keys[j].status
+	})
+	writeHeader(w, "webcp_fetches_total", "counter",
+		"HTTP requests by host and response status.")
+	for _, key := range keys {
+		writeSample(w, "webcp_fetches_total", []string{"host", key.host,
+			"status", key.status}, float64(stats.responses[key]))
+	}
+
+	writeHeader(w, "webcp_fetch_duration_seconds", "histogram",
+		"Time taken to fetch a page.")
+	for i, bound := range stats.latency.bounds {
+		writeSample(w, "webcp_fetch_duration_seconds_bucket",
+			[]string{"le", fmt.Sprint(bound)}, float64(stats.latency.counts[i]))
+	}
+	writeSample(w, "webcp_fetch_duration_seconds_bucket",
+		[]string{"le", "+Inf"}, float64(stats.latency.count))
+	writeSample(w, "webcp_fetch_duration_seconds_sum", nil, stats.latency.sum)
+	writeSample(w, "webcp_fetch_duration_seconds_count", nil, float64(stats.latency.count))
+
+	writeMetric(w, "webcp_downloaded_bytes_total", "counter",
+		"Bytes downloaded.", nil, float64(stats.Bytes))
+	writeMetric(w, "webcp_retries_total", "counter",
+		"Requests retried because the server asked us to slow down.", nil,
+		float64(stats.Retries))
+	writeMetric(w, "webcp_saved_pages_total", "counter",
+		"Pages saved to the crawl folder.", nil, float64(stats.Saved))
+	writeLabeledCounts(w, "webcp_skipped_urls_total",
+		"URLs skipped, by reason.", "reason", stats.Skipped)
+	writeLabeledCounts(w, "webcp_failed_fetches_total",
+		"Failed fetches, by status or reason.", "reason", stats.Failed)
+}
+
+// Write the metrics to a file, replacing it in one step so a collector
+// never reads a partial file
+func (crawler *Crawler) writeMetricsFile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = crawler.writeMetrics(tmp); err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Write the metrics to the MetricsFile periodically until stop is closed,
+// and then once more
+func (crawler *Crawler) exportMetrics(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	write := func() {
+		if err := crawler.writeMetricsFile(crawler.MetricsFile); err != nil {
+			os.Stderr.WriteString("Could not write metrics to " +
+				crawler.MetricsFile + " - " + err.Error() + "\n")
+		}
+	}
+	ticker := time.NewTicker(metricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			write()
+		case <-stop:
+			write()
+			return
+		}
+	}
+}
+
+// Write a metric's HELP and TYPE lines
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Write a single sample. The labels are given as name, value pairs.
+func writeSample(w io.Writer, name string, labels []string, value float64) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	if len(pairs) > 0 {
+		name += "{" + strings.Join(pairs, ",") + "}"
+	}
+	fmt.Fprintf(w, "%s %v\n", name, value)
+}
+
+// Write a metric with a single sample
+func writeMetric(w io.Writer, name, kind, help string, labels []string, value float64) {
+	writeHeader(w, name, kind, help)
+	writeSample(w, name, labels, value)
+}
+
+// Write a counter with one sample per key, sorted by key
+func writeLabeledCounts(w io.Writer, name, help, label string, counts map[string]int) {
+	writeHeader(w, name, "counter", help)
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeSample(w, name, []string{label, key}, float64(counts[key]))
+	}
+}
+
+// Escapes label values for the Prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,73 @@
+package crawl
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("Given a crawl in progress", t, func() {
+		crawler := Crawler{
+			queue: NewQueue(),
+			stats: NewStats(),
+		}
+		page, _ := url.Parse("http://domain.com/page.html")
+		crawler.queue.Add(QueueItem{URL: page, Depth: 1})
+		crawler.stats.request("domain.com", 200, 30*time.Millisecond)
+		crawler.stats.request("domain.com", 429, 2*time.Second)
+		crawler.stats.request(`odd"host`, 0, time.Second)
+		crawler.stats.fetched(page, 1, 200, 512)
+		crawler.stats.retried()
+
+		Convey("When I write its metrics", func() {
+			var buff bytes.Buffer
+			So(crawler.writeMetrics(&buff), ShouldBeNil)
+			metrics := buff.String()
+
+			Convey("Then I count the fetches by host and status", func() {
+				So(metrics, ShouldContainSubstring, "# TYPE webcp_fetches_total counter\n")
+				So(metrics, ShouldContainSubstring, `webcp_fetches_total{host="domain.com",status="200"} 1`+"\n")
+				So(metrics, ShouldContainSubstring, `webcp_fetches_total{host="domain.com",status="429"} 1`+"\n")
+				So(metrics, ShouldContainSubstring, `webcp_fetches_total{host="odd\"host",status="error"} 1`+"\n")
+			})
+
+			Convey("Then I write the latency histogram", func() {
+				So(metrics, ShouldContainSubstring, `webcp_fetch_duration_seconds_bucket{le="0.05"} 1`+"\n")
+				So(metrics, ShouldContainSubstring, `webcp_fetch_duration_seconds_bucket{le="1"} 2`+"\n")
+				So(metrics, ShouldContainSubstring, `webcp_fetch_duration_seconds_bucket{le="+Inf"} 3`+"\n")
+				So(metrics, ShouldContainSubstring, "webcp_fetch_duration_seconds_count 3\n")
+			})
+
+			Convey("Then I write the totals and queue depth", func() {
+				So(metrics, ShouldContainSubstring, "webcp_downloaded_bytes_total 512\n")
+				So(metrics, ShouldContainSubstring, "webcp_retries_total 1\n")
+				So(metrics, ShouldContainSubstring, "webcp_queued_pages 1\n")
+				So(metrics, ShouldContainSubstring, "webcp_in_flight_pages 0\n")
+			})
+		})
+
+		Convey("When I write its metrics to a file", func() {
+			folder, err := ioutil.TempDir("", "webcp")
+			So(err, ShouldBeNil)
+			Reset(func() {
+				os.RemoveAll(folder)
+			})
+			path := filepath.Join(folder, "webcp.prom")
+			So(crawler.writeMetricsFile(path), ShouldBeNil)
+
+			Convey("Then the file holds only the metrics", func() {
+				content, err := ioutil.ReadFile(path)
+				So(err, ShouldBeNil)
+				So(string(content), ShouldContainSubstring, "webcp_queued_pages 1\n")
+				files, _ := ioutil.ReadDir(folder)
+				So(len(files), ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,42 @@
+package crawl
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Serves the pages of a crawl saved by webcp, from the paths given by
+// LocalPath(). Pages which weren't saved get 404 Not Found.
+type MirrorFetcher struct {
+
+	// The folder holding the saved crawl
+	Folder string
+}
+
+// Serve a saved page
+func (fetcher *MirrorFetcher) Do(req *http.Request) (*http.Response, error) {
+	path := LocalPath(req.URL)
+	body, err := ioutil.ReadFile(filepath.Join(fetcher.Folder, path))
+	if os.IsNotExist(err) {
+		return newResponse(req, http.StatusNotFound, nil, nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Guess the content type from the file name, ignoring any query
+	header := make(http.Header)
+	ext := filepath.Ext(strings.SplitN(path, "?", 2)[0])
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		header.Set("Content-Type", contentType)
+	} else {
+		header.Set("Content-Type", http.DetectContentType(body))
+	}
+	if req.Method == "HEAD" {
+		body = nil
+	}
+	return newResponse(req, http.StatusOK, header, body), nil
+}
@@ -4,7 +4,6 @@
 package crawl
 
 import (
-	url "net/url"
 	gomock "code.google.com/p/gomock/gomock"
 )
 
@@ -29,12 +28,44 @@ func (_m *MockCrawlQueueStorage) EXPECT() *_MockCrawlQueueStorageRecorder {
 	return _m.recorder
 }
 
-func (_m *MockCrawlQueueStorage) Add(_param0 *url.URL, _param1 int) {
-	_m.ctrl.Call(_m, "Add", _param0, _param1)
+func (_m *MockCrawlQueueStorage) Add(_param0 QueueItem) {
+	_m.ctrl.Call(_m, "Add", _param0)
 }
 
-func (_mr *_MockCrawlQueueStorageRecorder) Add(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Add", arg0, arg1)
+func (_mr *_MockCrawlQueueStorageRecorder) Add(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Add", arg0)
+}
+
+func (_m *MockCrawlQueueStorage) AddCookie(_param0 string) {
+	_m.ctrl.Call(_m, "AddCookie", _param0)
+}
+
+func (_mr *_MockCrawlQueueStorageRecorder) AddCookie(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddCookie", arg0)
+}
+
+func (_m *MockCrawlQueueStorage) AddDigest(_param0 string, _param1 string) {
+	_m.ctrl.Call(_m, "AddDigest", _param0, _param1)
+}
+
+func (_mr *_MockCrawlQueueStorageRecorder) AddDigest(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddDigest", arg0, arg1)
+}
+
+func (_m *MockCrawlQueueStorage) AddEdge(_param0 Edge) {
+	_m.ctrl.Call(_m, "AddEdge", _param0)
+}
+
+func (_mr *_MockCrawlQueueStorageRecorder) AddEdge(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddEdge", arg0)
+}
+
+func (_m *MockCrawlQueueStorage) AddQuotaPage(_param0 string) {
+	_m.ctrl.Call(_m, "AddQuotaPage", _param0)
+}
+
+func (_mr *_MockCrawlQueueStorageRecorder) AddQuotaPage(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddQuotaPage", arg0)
 }
 
 func (_m *MockCrawlQueueStorage) Close() error {
@@ -47,13 +78,65 @@ func (_mr *_MockCrawlQueueStorageRecorder) Close() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Close")
 }
 
-func (_m *MockCrawlQueueStorage) Next() (*url.URL, int) {
+func (_m *MockCrawlQueueStorage) Cookies() []string {
+	ret := _m.ctrl.Call(_m, "Cookies")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+func (_mr *_MockCrawlQueueStorageRecorder) Cookies() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Cookies")
+}
+
+func (_m *MockCrawlQueueStorage) Digest(_param0 string) (string, bool) {
+	ret := _m.ctrl.Call(_m, "Digest", _param0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+func (_mr *_MockCrawlQueueStorageRecorder) Digest(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Digest", arg0)
+}
+
+func (_m *MockCrawlQueueStorage) Edges() ([]Edge, error) {
+	ret := _m.ctrl.Call(_m, "Edges")
+	ret0, _ := ret[0].([]Edge)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockCrawlQueueStorageRecorder) Edges() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Edges")
+}
+
+func (_m *MockCrawlQueueStorage) Len() int {
+	ret := _m.ctrl.Call(_m, "Len")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+func (_mr *_MockCrawlQueueStorageRecorder) Len() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Len")
+}
+
+func (_m *MockCrawlQueueStorage) Next() (QueueItem, bool) {
 	ret := _m.ctrl.Call(_m, "Next")
-	ret0, _ := ret[0].(*url.URL)
-	ret1, _ := ret[1].(int)
+	ret0, _ := ret[0].(QueueItem)
+	ret1, _ := ret[1].(bool)
 	return ret0, ret1
 }
 
 func (_mr *_MockCrawlQueueStorageRecorder) Next() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Next")
 }
+
+func (_m *MockCrawlQueueStorage) QuotaPages() map[string]int {
+	ret := _m.ctrl.Call(_m, "QuotaPages")
+	ret0, _ := ret[0].(map[string]int)
+	return ret0
+}
+
+func (_mr *_MockCrawlQueueStorageRecorder) QuotaPages() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "QuotaPages")
+}
@@ -0,0 +1,105 @@
+package crawl
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// How often to report progress when the crawler's ProgressInterval is unset
+const defaultProgressInterval = time.Second
+
+// A snapshot of a crawl's progress
+type Progress struct {
+
+	// The number of pages waiting in the frontier
+	Queued int
+
+	// The number of pages being fetched
+	InFlight int
+
+	// The number of pages crawled in this session
+	Done int
+
+	// The number of failed requests
+	Failed int
+
+	// The number of bytes downloaded
+	Bytes int64
+
+	// The page each worker is fetching, or nil for an idle worker
+	Current []*url.URL
+
+	// The time the crawl has taken so far
+	Elapsed time.Duration
+
+	// The estimated time until the frontier is empty, or 0 if unknown
+	ETA time.Duration
+}
+
+// The pages being fetched by each worker
+type workerPages struct {
+	mu    sync.Mutex
+	pages []*url.URL
+}
+
+// Create an idle set of workers
+func newWorkerPages(workers int) *workerPages {
+	return &workerPages{
+		pages: make([]*url.URL, workers),
+	}
+}
+
+// Record the page a worker is fetching, or nil when it finishes
+func (workers *workerPages) set(worker int, page *url.URL) {
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+	workers.pages[worker] = page
+}
+
+// Get the page each worker is fetching
+func (workers *workerPages) current() []*url.URL {
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+	return append([]*url.URL(nil), workers.pages...)
+}
+
+// Take a snapshot of the crawl's progress
+func (crawler *Crawler) progress() Progress {
+	var progress Progress
+	progress.Queued, progress.InFlight, progress.Done = crawler.queue.Counts()
+	progress.Failed, progress.Bytes = crawler.stats.progress()
+	progress.Elapsed = crawler.stats.Elapsed()
+	if crawler.workers != nil {
+		progress.Current = crawler.workers.current()
+	}
+
+	// Estimate the remaining time from the rate at which pages are completed
+	remaining := progress.Queued + progress.InFlight
+	if progress.Done > 0 && remaining > 0 {
+		perPage := progress.Elapsed / time.Duration(progress.Done)
+		progress.ETA = perPage * time.Duration(remaining)
+	}
+	return progress
+}
+
+// Report the crawl's progress periodically until stop is closed, and then
+// once more
+func (crawler *Crawler) reportProgress(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	interval := crawler.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			crawler.OnProgress(crawler.progress())
+		case <-stop:
+			crawler.OnProgress(crawler.progress())
+			return
+		}
+	}
+}
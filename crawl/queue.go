@@ -3,16 +3,71 @@ package crawl
 import (
 	"io"
 	"net/url"
+	"sync"
 )
 
 type CrawlQueueStorage interface {
 	io.Closer
 
 	// Store a new page to crawl later
-	Add(site *url.URL, depth int)
+	Add(item QueueItem)
 
-	// Get a page to crawl
-	Next() (site *url.URL, depth int)
+	// Get a page to crawl, or false if there are none
+	Next() (item QueueItem, ok bool)
+
+	// Record the digest of a page's content, and the path where it was saved
+	AddDigest(digest, path string)
+
+	// Get the path where content with a given digest was saved, if any
+	Digest(digest string) (path string, ok bool)
+
+	// Record a link from one page to another
+	AddEdge(edge Edge)
+
+	// Record a cookie, formatted as a line of a Netscape cookies file
+	AddCookie(line string)
+
+	// Get the cookies recorded so far, in the order they were set
+	Cookies() []string
+
+	// Get the links recorded so far, in the order they were found
+	Edges() ([]Edge, error)
+
+	// Record a page counted against a quota, given by its Match
+	AddQuotaPage(match string)
+
+	// Get the number of pages counted against each quota so far, by Match
+	QuotaPages() map[string]int
+
+	// Get the number of pages waiting to be crawled
+	Len() int
+}
+
+// A page in the crawl's frontier, with how the crawl reached it
+type QueueItem struct {
+
+	// The page to crawl
+	URL *url.URL
+
+	// The number of links followed from the seed to reach the page, counting
+	// the seed as 1
+	Depth int
+
+	// The seed from which the page was reached, whose maximum depth applies
+	// to it, or nil to use that of the seed whose scope contains the page
+	Seed *url.URL
+
+	// The number of links in a row followed outside the seeds' scopes to
+	// reach the page
+	Hops int
+
+	// Whether the page is a requisite, such as an image or stylesheet, of the
+	// page which linked to it
+	Requisite bool
+
+	// Whether the page is outside the crawl, and only linked to, so it is
+	// checked rather than crawled when checking links
+	Check bool
 }
 
 // Manages the crawl's frontier
@@ -23,13 +78,27 @@ type CrawlQueue struct {
 
 	// Whether we resumed a prior crawl
 	DidResume bool
+
+	// The number of pages handed out by Next() which are not yet Done()
+	inFlight int
+
+	// The number of pages marked Done() in this session
+	done int
+
+	// Whether the crawl is stopping, so no more pages should be handed out
+	stopped bool
+
+	mu    sync.Mutex
+	ready *sync.Cond
 }
 
 // Create a new queue
-func NewQueue() CrawlQueue {
-	return CrawlQueue{
+func NewQueue() *CrawlQueue {
+	queue := &CrawlQueue{
 		Storage: NewMemQueueStorage(),
 	}
+	queue.ready = sync.NewCond(&queue.mu)
+	return queue
 }
 
 // Use the following resume file
@@ -40,16 +109,113 @@ func (queue *CrawlQueue) ResumeFrom(path string) error {
 }
 
 // Store a new page to crawl later
-func (queue *CrawlQueue) Add(site *url.URL, depth int) {
-	loc := CanonicalURL(site)
-	if !queue.Crawled(loc) {
-		queue.Storage.Add(loc, depth)
+func (queue *CrawlQueue) Add(item QueueItem) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	item.URL = CanonicalURL(item.URL)
+	if !queue.Crawled(item.URL) {
+		queue.Storage.Add(item)
+		queue.ready.Broadcast()
+	}
+}
+
+// Get a page to crawl now, blocking until one is available. Returns false
+// once the frontier is empty and no pages are still being crawled, or once
+// the queue is stopped. Call Done() after crawling the page.
+func (queue *CrawlQueue) Next() (item QueueItem, ok bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	for {
+		if queue.stopped {
+			return QueueItem{}, false
+		} else if item, ok = queue.Storage.Next(); ok {
+			queue.inFlight++
+			return
+		} else if queue.inFlight == 0 {
+			return
+		}
+		queue.ready.Wait()
 	}
 }
 
-// Get a page to crawl now, blocking until one is available
-func (queue *CrawlQueue) Next() (site *url.URL, depth int) {
-	return queue.Storage.Next()
+// Mark a page returned by Next() as crawled
+func (queue *CrawlQueue) Done() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.inFlight--
+	queue.done++
+	queue.ready.Broadcast()
+}
+
+// Stop handing out pages, leaving the rest of the frontier in storage
+func (queue *CrawlQueue) Stop() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.stopped = true
+	queue.ready.Broadcast()
+}
+
+// Get the number of pages waiting, being crawled, and crawled in this session
+func (queue *CrawlQueue) Counts() (queued, inFlight, done int) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.Storage.Len(), queue.inFlight, queue.done
+}
+
+// Record the digest of a saved page's content
+func (queue *CrawlQueue) AddDigest(digest, path string) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.Storage.AddDigest(digest, path)
+}
+
+// Find the path where content with a given digest was saved
+func (queue *CrawlQueue) Digest(digest string) (path string, ok bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.Storage.Digest(digest)
+}
+
+// Record a link from one page to another
+func (queue *CrawlQueue) AddEdge(edge Edge) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.Storage.AddEdge(edge)
+}
+
+// Get the links recorded so far
+func (queue *CrawlQueue) Edges() ([]Edge, error) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.Storage.Edges()
+}
+
+// Record a cookie, so a resumed crawl keeps its session
+func (queue *CrawlQueue) AddCookie(line string) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.Storage.AddCookie(line)
+}
+
+// Get the cookies recorded so far
+func (queue *CrawlQueue) Cookies() []string {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.Storage.Cookies()
+}
+
+// Record a page counted against a quota, so a resumed crawl respects it
+func (queue *CrawlQueue) AddQuotaPage(match string) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.Storage.AddQuotaPage(match)
+}
+
+// Get the number of pages counted against each quota so far
+func (queue *CrawlQueue) QuotaPages() map[string]int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.Storage.QuotaPages()
 }
 
 // Ask whether we've already crawled a given URL
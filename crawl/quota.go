@@ -0,0 +1,207 @@
+package crawl
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A limit on the number of pages crawled from part of the web, such as a
+// site's calendar or search pages, so that part can't use up the crawl
+type Quota struct {
+
+	// The pages the quota covers: a host such as "example.com", a host and
+	// path prefix such as "example.com/calendar/", a path prefix on any host
+	// such as "/search/", or "~" followed by a regular expression matched
+	// against the whole URL, such as "~[?&]page=[0-9]+"
+	Match string
+
+	// The maximum number of pages to crawl from those the quota covers.
+	// Failed requests and error statuses don't count.
+	Pages int
+
+	// The compiled regular expression, for a pattern
+	pattern *regexp.Regexp
+}
+
+// Create a quota on the pages a match covers, described at Quota.Match
+func NewQuota(match string, pages int) (Quota, error) {
+	quota := Quota{Match: match, Pages: pages}
+	if match == "" || match == "~" {
+		return quota, fmt.Errorf("The quota doesn't say which pages it covers")
+	} else if pages < 0 {
+		return quota, fmt.Errorf("Invalid number of pages %d", pages)
+	}
+	if strings.HasPrefix(match, "~") {
+		var err error
+		if quota.pattern, err = regexp.Compile(match[1:]); err != nil {
+			return quota, err
+		}
+	}
+	return quota, nil
+}
+
+// Parse a quota written as "<match>=<pages>", such as "example.com/cal/=100"
+func ParseQuota(spec string) (Quota, error) {
+	i := strings.LastIndex(spec, "=")
+	if i < 0 {
+		return Quota{}, fmt.Errorf("Expected <match>=<pages>")
+	}
+	pages, err := strconv.Atoi(spec[i+1:])
+	if err != nil {
+		return Quota{}, fmt.Errorf("Invalid number of pages %q", spec[i+1:])
+	}
+	return NewQuota(spec[:i], pages)
+}
+
+// Write the quota in the format read by ParseQuota()
+func (quota Quota) String() string {
+	return quota.Match + "=" + strconv.Itoa(quota.Pages)
+}
+
+// Ask whether the quota covers a URL
+func (quota Quota) Covers(site *url.URL) bool {
+	if quota.pattern != nil {
+		return quota.pattern.MatchString(site.String())
+	}
+	host, prefix := quota.Match, ""
+	if i := strings.Index(host, "/"); i >= 0 {
+		host, prefix = host[:i], host[i:]
+	}
+	if host != "" && !strings.EqualFold(host, site.Host) && !strings.EqualFold(host, site.Hostname()) {
+		return false
+	}
+	path := site.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+// The pages crawled against each quota, shared by the crawl's workers
+type quotaState struct {
+	quotas []Quota
+
+	// The number of pages crawled against each quota, by its Match
+	spent map[string]int
+
+	// The number of pages held against each quota by workers fetching them
+	held map[string]int
+
+	// Called with a quota's Match each time a page is crawled against it, so
+	// a resumed crawl can pick up the count
+	record func(match string)
+
+	mu      sync.Mutex
+	settled *sync.Cond
+}
+
+// Track the pages crawled against some quotas, starting from the counts
+// recorded by an earlier session
+func newQuotaState(quotas []Quota, spent map[string]int, record func(match string)) *quotaState {
+	state := &quotaState{
+		quotas: quotas,
+		spent:  make(map[string]int),
+		held:   make(map[string]int),
+		record: record,
+	}
+	for match, pages := range spent {
+		state.spent[match] = pages
+	}
+	state.settled = sync.NewCond(&state.mu)
+	return state
+}
+
+// Ask whether a URL is covered by a quota which is already used up by the
+// pages crawled, not counting those still being fetched
+func (state *quotaState) exhausted(site *url.URL) bool {
+	if state == nil {
+		return false
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for _, quota := range state.quotas {
+		if quota.Covers(site) && state.spent[quota.Match] >= quota.Pages {
+			return true
+		}
+	}
+	return false
+}
+
+// Hold a page against the quotas which cover it while it is fetched, so
+// other workers can't overshoot them, waiting while the pages left are held
+// by other workers in case one of them fails. Returns false, and holds
+// nothing, if any of those quotas is used up. Call settle() once the page is
+// fetched.
+func (state *quotaState) take(site *url.URL) bool {
+	if state == nil {
+		return true
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	covering := state.covering(site)
+	for {
+		waiting := false
+		for _, match := range covering {
+			pages := state.pages(match)
+			if state.spent[match] >= pages {
+				return false
+			} else if state.spent[match]+state.held[match] >= pages {
+				waiting = true
+			}
+		}
+		if !waiting {
+			break
+		}
+		state.settled.Wait()
+	}
+	for _, match := range covering {
+		state.held[match]++
+	}
+	return true
+}
+
+// Settle a page held by take(): count it against its quotas if it was
+// crawled, or give it back if the request failed, so only pages which were
+// successfully fetched use up a quota
+func (state *quotaState) settle(site *url.URL, crawled bool) {
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for _, match := range state.covering(site) {
+		state.held[match]--
+		if crawled {
+			state.spent[match]++
+			if state.record != nil {
+				state.record(match)
+			}
+		}
+	}
+	state.settled.Broadcast()
+}
+
+// Get the Match of each quota which covers a URL
+func (state *quotaState) covering(site *url.URL) []string {
+	var matches []string
+	for _, quota := range state.quotas {
+		if quota.Covers(site) {
+			matches = append(matches, quota.Match)
+		}
+	}
+	return matches
+}
+
+// Get the number of pages a quota allows
+func (state *quotaState) pages(match string) int {
+	for _, quota := range state.quotas {
+		if quota.Match == match {
+			return quota.Pages
+		}
+	}
+	return 0
+}
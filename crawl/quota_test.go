@@ -0,0 +1,186 @@
+package crawl
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	Convey("Given quotas written on the command line", t, func() {
+		Convey("Then valid quotas are parsed", func() {
+			quota, err := ParseQuota("example.com/calendar/=100")
+			So(err, ShouldBeNil)
+			So(quota.Match, ShouldEqual, "example.com/calendar/")
+			So(quota.Pages, ShouldEqual, 100)
+			So(quota.String(), ShouldEqual, "example.com/calendar/=100")
+
+			quota, err = ParseQuota("~[?&]q=[^&]+=5")
+			So(err, ShouldBeNil)
+			So(quota.Match, ShouldEqual, "~[?&]q=[^&]+")
+			So(quota.Pages, ShouldEqual, 5)
+		})
+
+		Convey("Then invalid quotas are rejected", func() {
+			for _, spec := range []string{"example.com", "example.com=many", "=5",
+				"~=5", "~[=5", "example.com=-1"} {
+				_, err := ParseQuota(spec)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("Given quotas on a host, a path prefix and a pattern", t, func() {
+		parse := func(spec string) Quota {
+			quota, err := ParseQuota(spec)
+			So(err, ShouldBeNil)
+			return quota
+		}
+		covers := func(quota Quota, site string) bool {
+			u, _ := url.Parse(site)
+			return quota.Covers(u)
+		}
+		host := parse("Example.com=10")
+		prefix := parse("example.com/calendar/=10")
+		anyHost := parse("/search/=10")
+		pattern := parse("~[?&]page=[0-9]+=10")
+
+		Convey("Then each covers the URLs it matches", func() {
+			So(covers(host, "http://example.com/"), ShouldBeTrue)
+			So(covers(host, "http://example.com:8080/docs/"), ShouldBeTrue)
+			So(covers(host, "http://www.example.com/"), ShouldBeFalse)
+			So(covers(prefix, "http://example.com/calendar/2014/"), ShouldBeTrue)
+			So(covers(prefix, "http://example.com/docs/"), ShouldBeFalse)
+			So(covers(prefix, "http://other.com/calendar/"), ShouldBeFalse)
+			So(covers(anyHost, "http://other.com/search/?q=x"), ShouldBeTrue)
+			So(covers(anyHost, "http://other.com/"), ShouldBeFalse)
+			So(covers(pattern, "http://example.com/list?sort=up&page=3"), ShouldBeTrue)
+			So(covers(pattern, "http://example.com/list?sort=up"), ShouldBeFalse)
+		})
+
+		Convey("Then pages are counted against every quota which covers them", func() {
+			var recorded []string
+			state := newQuotaState([]Quota{parse("example.com=3"), parse("example.com/calendar/=1")},
+				map[string]int{"example.com": 1}, func(match string) {
+					recorded = append(recorded, match)
+				})
+			calendar, _ := url.Parse("http://example.com/calendar/")
+			docs, _ := url.Parse("http://example.com/docs/")
+			So(state.take(calendar), ShouldBeTrue)
+			So(state.exhausted(calendar), ShouldBeFalse)
+			So(recorded, ShouldBeEmpty)
+			state.settle(calendar, true)
+			So(state.exhausted(calendar), ShouldBeTrue)
+			So(state.take(calendar), ShouldBeFalse)
+			So(state.exhausted(docs), ShouldBeFalse)
+			So(state.take(docs), ShouldBeTrue)
+			state.settle(docs, true)
+			So(state.take(docs), ShouldBeFalse)
+			So(recorded, ShouldResemble, []string{"example.com", "example.com/calendar/", "example.com"})
+		})
+
+		Convey("Then pages which fail are given back to their quotas", func() {
+			var recorded []string
+			state := newQuotaState([]Quota{parse("example.com=1")}, nil, func(match string) {
+				recorded = append(recorded, match)
+			})
+			page, _ := url.Parse("http://example.com/page.html")
+			So(state.take(page), ShouldBeTrue)
+			took := make(chan bool, 1)
+			go func() {
+				took <- state.take(page)
+			}()
+			waiting := true
+			select {
+			case ok := <-took:
+				took <- ok
+				waiting = false
+			case <-time.After(20 * time.Millisecond):
+			}
+			So(waiting, ShouldBeTrue)
+			So(state.exhausted(page), ShouldBeFalse)
+			state.settle(page, false)
+			So(<-took, ShouldBeTrue)
+			So(state.exhausted(page), ShouldBeFalse)
+			state.settle(page, true)
+			So(state.exhausted(page), ShouldBeTrue)
+			So(state.take(page), ShouldBeFalse)
+			So(recorded, ShouldResemble, []string{"example.com"})
+		})
+	})
+
+	Convey("Given a site with a large calendar", t, func() {
+		srv := httptest.NewServer(pageHandler{
+			"/docs/": `<a href="calendar/0.html">0</a> <a href="calendar/1.html">1</a> ` +
+				`<a href="calendar/2.html">2</a> <a href="calendar/3.html">3</a> <a href="about.html">About</a>`,
+			"/docs/about.html":      "about",
+			"/docs/calendar/1.html": "day 1",
+			"/docs/calendar/2.html": "day 2",
+			"/docs/calendar/3.html": "day 3",
+		})
+		Reset(func() {
+			srv.Close()
+		})
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		seed, _ := url.Parse(srv.URL + "/docs/")
+		quota, err := NewQuota("/docs/calendar/", 2)
+		So(err, ShouldBeNil)
+		crawler := Crawler{
+			Seeds:    []Seed{{URL: seed}},
+			Folder:   folder,
+			MaxDepth: 2,
+			Quotas:   []Quota{quota},
+			Resume:   filepath.Join(folder, "resume.txt"),
+		}
+		calendarPages := func() int {
+			files, _ := ioutil.ReadDir(filepath.Join(folder, LocalPath(seed), "..", "calendar"))
+			return len(files)
+		}
+
+		Convey("When several workers crawl it with a quota on the calendar", func() {
+			crawler.Workers = 4
+			stats, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then a page given back by a missing page is crawled by another worker", func() {
+				So(calendarPages(), ShouldEqual, 2)
+				So(stats.Skipped[SkipQuota], ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I crawl it with a quota on the calendar", func() {
+			stats, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the pages over the quota are skipped and counted", func() {
+				So(calendarPages(), ShouldEqual, 2)
+				So(stats.Fetched, ShouldEqual, 4)
+				So(stats.Skipped[SkipQuota], ShouldEqual, 1)
+			})
+
+			Convey("Then a missing page is not counted against the quota", func() {
+				So(stats.Failed["404"], ShouldEqual, 1)
+			})
+
+			Convey("Then a resumed crawl respects the pages already counted", func() {
+				crawler.Quotas[0].Pages = 3
+				resumed, _ := url.Parse(srv.URL + "/docs/calendar/3.html")
+				crawler.init()
+				defer crawler.cleanup()
+				So(crawler.quotas.exhausted(resumed), ShouldBeFalse)
+				So(crawler.quotas.take(resumed), ShouldBeTrue)
+				crawler.quotas.settle(resumed, true)
+				So(crawler.quotas.take(resumed), ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,158 @@
+package crawl
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A URL from which crawling should begin. The seed's scope is the pages on
+// its host, or with Crawler.FolderScope, only those whose paths begin with
+// the folder containing it.
+type Seed struct {
+
+	// The URL to crawl first
+	URL *url.URL
+
+	// The maximum depth to crawl within this seed's scope, or 0 to use the
+	// crawler's MaxDepth
+	MaxDepth int
+}
+
+// Parse a seed written as a URL, optionally followed by a maximum depth
+func ParseSeed(line string) (seed Seed, err error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 {
+		return seed, fmt.Errorf("Invalid seed %q", line)
+	}
+	if seed.URL, err = url.Parse(fields[0]); err != nil {
+		return seed, fmt.Errorf("Invalid URL %q - %v", fields[0], err)
+	} else if !seed.URL.IsAbs() {
+		return seed, fmt.Errorf("Can't fetch non-absolute URL %s", fields[0])
+	}
+	if len(fields) == 2 {
+		if seed.MaxDepth, err = strconv.Atoi(fields[1]); err != nil || seed.MaxDepth < 1 {
+			return seed, fmt.Errorf("Invalid max depth %q for seed %s", fields[1], fields[0])
+		}
+	}
+	return seed, nil
+}
+
+// Load seeds from a file, with one seed per line as accepted by ParseSeed().
+// Blank lines and lines starting with # are ignored.
+func LoadSeeds(path string) ([]Seed, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var seeds []Seed
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seed, err := ParseSeed(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds, scanner.Err()
+}
+
+// Ask whether a URL falls within the seed's scope: the seed's host, or with
+// folderOnly, the folder containing the seed on that host
+func (seed Seed) Contains(site *url.URL, folderOnly bool) bool {
+	path := site.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.EqualFold(site.Host, seed.URL.Host) &&
+		strings.HasPrefix(path, seed.scope(folderOnly))
+}
+
+// Get the path prefix of the pages in the seed's scope
+func (seed Seed) scope(folderOnly bool) string {
+	if !folderOnly {
+		return "/"
+	}
+	return seed.folder()
+}
+
+// Get the folder containing the seed URL
+func (seed Seed) folder() string {
+	folder := seed.URL.Path
+	if i := strings.LastIndex(folder, "/"); i >= 0 {
+		return folder[:i+1]
+	}
+	return "/"
+}
+
+// Get the seed whose scope contains a URL, or nil if the URL is out of scope.
+// Of several such seeds, the one with the narrowest folder containing the URL
+// is chosen. When the crawler has no seeds, every URL is in scope.
+func (crawler *Crawler) seedFor(site *url.URL) (seed *Seed, inScope bool) {
+	seeds := crawler.seeds()
+	best := -1
+	for i := range seeds {
+		s := &seeds[i]
+		if !s.Contains(site, crawler.FolderScope) {
+			continue
+		}
+		rank := 0
+		if s.Contains(site, true) {
+			rank = len(s.folder())
+		}
+		if rank > best {
+			seed, best = s, rank
+		}
+	}
+	return seed, seed != nil || len(seeds) == 0
+}
+
+// Get the crawler's seeds, which may be added to while the crawl runs
+func (crawler *Crawler) seeds() []Seed {
+	crawler.control.mu.Lock()
+	defer crawler.control.mu.Unlock()
+	return crawler.Seeds
+}
+
+// Add a seed to a running crawl, and queue its URL
+func (crawler *Crawler) addSeed(seed Seed) {
+	crawler.control.mu.Lock()
+	crawler.Seeds = append(crawler.Seeds, seed)
+	crawler.control.mu.Unlock()
+	crawler.queue.Add(QueueItem{URL: seed.URL, Depth: 1, Seed: seed.URL})
+}
+
+// Get the maximum depth to crawl for a page: that of the seed it was reached
+// from, or else of the seed whose scope contains it, or else the crawler's
+func (crawler *Crawler) maxDepth(item QueueItem) int {
+	seed, _ := crawler.seedFor(item.URL)
+	if item.Seed != nil {
+		if origin := crawler.seedAt(item.Seed); origin != nil {
+			seed = origin
+		}
+	}
+	if seed != nil && seed.MaxDepth > 0 {
+		return seed.MaxDepth
+	}
+	return crawler.MaxDepth
+}
+
+// Get the seed with a URL, or nil if there is none
+func (crawler *Crawler) seedAt(site *url.URL) *Seed {
+	seeds := crawler.seeds()
+	for i := range seeds {
+		if seeds[i].URL.String() == site.String() {
+			return &seeds[i]
+		}
+	}
+	return nil
+}
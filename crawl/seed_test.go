@@ -0,0 +1,106 @@
+package crawl
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeed(t *testing.T) {
+	Convey("Given a seed", t, func() {
+		seed, err := ParseSeed("http://domain.com/docs/intro.html 3")
+		So(err, ShouldBeNil)
+		So(seed.MaxDepth, ShouldEqual, 3)
+
+		Convey("Pages on the seed's host are in scope", func() {
+			for _, s := range []string{
+				"http://domain.com/",
+				"http://DOMAIN.com/docs/intro.html",
+				"http://domain.com/blog/docs/",
+			} {
+				site, _ := url.Parse(s)
+				So(seed.Contains(site, false), ShouldBeTrue)
+			}
+		})
+
+		Convey("Pages on other hosts are out of scope", func() {
+			site, _ := url.Parse("http://domain2.com/docs/intro.html")
+			So(seed.Contains(site, false), ShouldBeFalse)
+		})
+
+		Convey("With a folder scope, pages in the seed's folder are in scope", func() {
+			for _, s := range []string{
+				"http://domain.com/docs/",
+				"http://DOMAIN.com/docs/intro.html",
+				"http://domain.com/docs/api/index.html",
+			} {
+				site, _ := url.Parse(s)
+				So(seed.Contains(site, true), ShouldBeTrue)
+			}
+		})
+
+		Convey("With a folder scope, other pages are out of scope", func() {
+			for _, s := range []string{
+				"http://domain.com/",
+				"http://domain.com/blog/docs/",
+				"http://domain2.com/docs/intro.html",
+			} {
+				site, _ := url.Parse(s)
+				So(seed.Contains(site, true), ShouldBeFalse)
+			}
+		})
+	})
+
+	Convey("Given a seed at the root of a site", t, func() {
+		seed, err := ParseSeed("http://domain.com")
+		So(err, ShouldBeNil)
+		So(seed.MaxDepth, ShouldEqual, 0)
+
+		Convey("The whole site is in scope", func() {
+			site, _ := url.Parse("http://domain.com")
+			So(seed.Contains(site, true), ShouldBeTrue)
+			site, _ = url.Parse("http://domain.com/any/page.html")
+			So(seed.Contains(site, true), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given invalid seeds", t, func() {
+		for _, line := range []string{
+			"",
+			"/relative/url.html",
+			"http://domain.com/ zero",
+			"http://domain.com/ 0",
+			"http://domain.com/ 1 2",
+		} {
+			_, err := ParseSeed(line)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Given a seeds file", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		path := filepath.Join(folder, "seeds.txt")
+		So(ioutil.WriteFile(path, []byte(`# Entry points
+http://domain.com/docs/
+
+http://domain2.com/ 2
+`), 0644), ShouldBeNil)
+		seeds, err := LoadSeeds(path)
+
+		Convey("Then I get the seeds", func() {
+			So(err, ShouldBeNil)
+			So(len(seeds), ShouldEqual, 2)
+			So(seeds[0].URL.String(), ShouldEqual, "http://domain.com/docs/")
+			So(seeds[0].MaxDepth, ShouldEqual, 0)
+			So(seeds[1].URL.String(), ShouldEqual, "http://domain2.com/")
+			So(seeds[1].MaxDepth, ShouldEqual, 2)
+		})
+	})
+}
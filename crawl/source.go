@@ -0,0 +1,112 @@
+package crawl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Where to fetch pages from: the live site, the Wayback Machine, or one of
+// them with the other as a fallback
+type SourcePolicy int
+
+const (
+	// Fetch pages from the live site
+	SourceLive SourcePolicy = iota
+
+	// Fetch pages from the Wayback Machine
+	SourceWayback
+
+	// Fetch pages from the live site, or from the Wayback Machine if the
+	// live site doesn't have them
+	SourceLiveThenWayback
+
+	// Fetch pages from the Wayback Machine, or from the live site if the
+	// archive doesn't have them
+	SourceWaybackThenLive
+)
+
+// The names of the places pages are fetched from, as recorded in the
+// manifest
+const (
+	SourceNameLive    = "live"
+	SourceNameWayback = "wayback"
+)
+
+// Parse a source policy name, as produced by SourcePolicy.String(). Spaces
+// around the comma are ignored.
+func ParseSourcePolicy(name string) (SourcePolicy, error) {
+	name = strings.Replace(name, " ", "", -1)
+	for _, policy := range []SourcePolicy{SourceLive, SourceWayback,
+		SourceLiveThenWayback, SourceWaybackThenLive} {
+		if policy.String() == name {
+			return policy, nil
+		}
+	}
+	return SourceLive, fmt.Errorf("Unknown source %q", name)
+}
+
+// Get the name of a source policy: the sources to try, in order, separated
+// by commas
+func (policy SourcePolicy) String() string {
+	switch policy {
+	case SourceWayback:
+		return SourceNameWayback
+	case SourceLiveThenWayback:
+		return SourceNameLive + "," + SourceNameWayback
+	case SourceWaybackThenLive:
+		return SourceNameWayback + "," + SourceNameLive
+	default:
+		return SourceNameLive
+	}
+}
+
+// Ask whether the policy fetches any pages from the Wayback Machine
+func (policy SourcePolicy) UsesWayback() bool {
+	return policy != SourceLive
+}
+
+// Tries each of several fetchers in turn, until one has the page
+type fallbackFetcher []Fetcher
+
+// Send a request to each fetcher until one has the page
+func (fetchers fallbackFetcher) Do(req *http.Request) (*http.Response, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+	for i, fetcher := range fetchers {
+		resp, err = fetcher.Do(req.Clone(req.Context()))
+		if i == len(fetchers)-1 || !fallBack(resp, err) {
+			break
+		} else if err == nil {
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+// Ask whether to try the next source after a response: when the page is
+// missing or gone, the server failed, or its host name doesn't exist
+func fallBack(resp *http.Response, err error) bool {
+	if err != nil {
+		var dnsErr *net.DNSError
+		return errors.As(err, &dnsErr)
+	}
+	return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone ||
+		resp.StatusCode >= 500
+}
+
+// Get the name of the source which served a response: responses from an
+// archive give the time they were captured, and those from a Memento
+// archive also give the archive's name
+func sourceOf(resp *http.Response) string {
+	if capturedAt(resp).IsZero() {
+		return SourceNameLive
+	} else if name := resp.Header.Get(archiveHeader); name != "" {
+		return name
+	}
+	return SourceNameWayback
+}
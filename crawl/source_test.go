@@ -0,0 +1,167 @@
+package crawl
+
+import (
+	"bufio"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A fetcher for a host name which doesn't exist
+type unknownHostFetcher struct{}
+
+// Fail to look up the host
+func (fetcher unknownHostFetcher) Do(req *http.Request) (*http.Response, error) {
+	return nil, &url.Error{Op: "Get", URL: req.URL.String(),
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: req.URL.Host}}}
+}
+
+func TestSources(t *testing.T) {
+	Convey("Given source policy names", t, func() {
+		Convey("Then each policy's name is parsed", func() {
+			for _, policy := range []SourcePolicy{SourceLive, SourceWayback,
+				SourceLiveThenWayback, SourceWaybackThenLive} {
+				parsed, err := ParseSourcePolicy(policy.String())
+				So(err, ShouldBeNil)
+				So(parsed, ShouldEqual, policy)
+			}
+			parsed, err := ParseSourcePolicy("wayback, live")
+			So(err, ShouldBeNil)
+			So(parsed, ShouldEqual, SourceWaybackThenLive)
+		})
+
+		Convey("Then an unknown name is an error", func() {
+			_, err := ParseSourcePolicy("archive")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a partly dead site, and an archive of it", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/":
+				w.Write([]byte(`<a href="gone.html">Gone</a> <a href="alive.html">Alive</a>`))
+			case "/gone.html":
+				http.Error(w, "gone", http.StatusGone)
+			case "/alive.html":
+				w.Write([]byte("live copy"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		Reset(func() {
+			live.Close()
+		})
+		archive := newFakeArchive([][]string{
+			{live.URL + "/gone.html", "20140101000000", "200", "text/html", "A"},
+			{live.URL + "/alive.html", "20140101000000", "200", "text/html", "B"},
+		}, map[string]string{
+			live.URL + "/gone.html":  "archived copy",
+			live.URL + "/alive.html": "archived copy",
+		})
+		Reset(func() {
+			archive.Close()
+		})
+		seed, _ := url.Parse(live.URL + "/")
+		crawler := Crawler{
+			Seeds:          []Seed{{URL: seed}},
+			Folder:         folder,
+			Manifest:       filepath.Join(folder, "manifest.jsonl"),
+			MaxDepth:       2,
+			WaybackAfter:   time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC),
+			WaybackArchive: archive.URL + "/web/",
+			WaybackCDX:     archive.URL + "/cdx",
+		}
+		saved := func(name string) string {
+			data, err := ioutil.ReadFile(filepath.Join(folder, seed.Host, name))
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+		sources := func() map[string]string {
+			file, err := os.Open(crawler.Manifest)
+			So(err, ShouldBeNil)
+			defer file.Close()
+			sources := make(map[string]string)
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				var entry ManifestEntry
+				So(json.Unmarshal(scanner.Bytes(), &entry), ShouldBeNil)
+				sources[filepath.Base(entry.Path)] = entry.Source
+			}
+			return sources
+		}
+
+		Convey("When I crawl the live site with the archive as a fallback", func() {
+			crawler.Source = SourceLiveThenWayback
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then missing pages come from the archive", func() {
+				So(saved("alive.html"), ShouldEqual, "live copy")
+				So(saved("gone.html"), ShouldEqual, "archived copy")
+			})
+
+			Convey("Then the manifest records where each page came from", func() {
+				So(sources(), ShouldResemble, map[string]string{
+					"index.html": SourceNameLive,
+					"alive.html": SourceNameLive,
+					"gone.html":  SourceNameWayback,
+				})
+			})
+		})
+
+		Convey("When I crawl the archive with the live site as a fallback", func() {
+			crawler.Source = SourceWaybackThenLive
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then pages come from the archive when it has them", func() {
+				So(saved("alive.html"), ShouldEqual, "archived copy")
+				So(saved("gone.html"), ShouldEqual, "archived copy")
+				So(sources(), ShouldResemble, map[string]string{
+					"index.html": SourceNameLive,
+					"alive.html": SourceNameWayback,
+					"gone.html":  SourceNameWayback,
+				})
+			})
+		})
+	})
+
+	Convey("Given a host which no longer exists", t, func() {
+		archive := newFakeArchive([][]string{
+			{"http://dead.example.com/", "20140101000000", "200", "text/html", "A"},
+		}, map[string]string{"http://dead.example.com/": "archived copy"})
+		Reset(func() {
+			archive.Close()
+		})
+		fetcher := fallbackFetcher{
+			unknownHostFetcher{},
+			&WaybackFetcher{Archive: archive.URL + "/web/", CDX: archive.URL + "/cdx"},
+		}
+
+		Convey("Then its pages come from the archive", func() {
+			req, err := http.NewRequest("GET", "http://dead.example.com/", nil)
+			So(err, ShouldBeNil)
+			resp, err := fetcher.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "archived copy")
+			So(sourceOf(resp), ShouldEqual, SourceNameWayback)
+		})
+	})
+}
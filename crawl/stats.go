@@ -0,0 +1,314 @@
+package crawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Reasons for skipping a URL
+const (
+	// The URL is outside the scope of every seed
+	SkipOutOfScope = "out-of-scope"
+
+	// The page's content duplicates a page we already saved
+	SkipDuplicate = "duplicate"
+
+	// The URL is covered by a quota which is used up
+	SkipQuota = "quota"
+)
+
+// The failure reason for a request which got no response, other than the
+// timeouts such as FailConnectTimeout
+const FailError = "error"
+
+// The number of recent errors to remember
+const maxRecentErrors = 20
+
+// A failed request
+type FetchError struct {
+	Time   time.Time `json:"time"`
+	URL    string    `json:"url"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error"`
+}
+
+// Statistics accumulated over a crawl
+type Stats struct {
+
+	// The number of pages fetched successfully
+	Fetched int `json:"fetched"`
+
+	// The number of pages saved to the crawl folder
+	Saved int `json:"saved"`
+
+	// The number of URLs skipped, by reason
+	Skipped map[string]int `json:"skipped"`
+
+	// The number of failed requests, by status code or failure reason
+	Failed map[string]int `json:"failed"`
+
+	// The number of bytes downloaded
+	Bytes int64 `json:"bytes"`
+
+	// The number of requests retried because the server asked us to slow
+	// down
+	Retries int `json:"retries"`
+
+	// The number of pages fetched from each host
+	Hosts map[string]int `json:"hosts"`
+
+	// The number of pages fetched at each depth
+	Depths map[int]int `json:"depths"`
+
+	// The result of checking each link, when checking links
+	Links []LinkResult `json:"links,omitempty"`
+
+	// When the crawl started and ended
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	recent    []FetchError
+	responses map[hostStatus]int
+	latency   histogram
+	mu        sync.Mutex
+}
+
+// A response status from a host, or "error" if it sent no response
+type hostStatus struct {
+	host, status string
+}
+
+// Create empty statistics for a crawl starting now
+func NewStats() *Stats {
+	return &Stats{
+		Skipped: make(map[string]int),
+		Failed:  make(map[string]int),
+		Hosts:   make(map[string]int),
+		Depths:  make(map[int]int),
+		Start:   time.Now(),
+
+		responses: make(map[hostStatus]int),
+		latency:   newHistogram(latencyBuckets),
+	}
+}
+
+// Count an HTTP request and the time it took, with status 0 if it got no
+// response
+func (stats *Stats) request(host string, status int, latency time.Duration) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	key := hostStatus{host, FailError}
+	if status != 0 {
+		key.status = strconv.Itoa(status)
+	}
+	stats.responses[key]++
+	stats.latency.observe(latency.Seconds())
+}
+
+// Count a retried request
+func (stats *Stats) retried() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.Retries++
+}
+
+// Count a response
+func (stats *Stats) fetched(site *url.URL, depth, status, size int) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.Bytes += int64(size)
+	if status >= 400 {
+		stats.Failed[strconv.Itoa(status)]++
+		stats.addError(site, strconv.Itoa(status), fmt.Sprintf("%d %s", status, http.StatusText(status)))
+	} else {
+		stats.Fetched++
+		stats.Hosts[site.Host]++
+		stats.Depths[depth]++
+	}
+}
+
+// Count a request which failed for a reason other than its status
+func (stats *Stats) failed(site *url.URL, reason string, err error) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.Failed[reason]++
+	stats.addError(site, reason, err.Error())
+}
+
+// Remember a failed request, forgetting the oldest if there are too many. The
+// caller must hold the lock.
+func (stats *Stats) addError(site *url.URL, reason, msg string) {
+	if len(stats.recent) == maxRecentErrors {
+		stats.recent = stats.recent[1:]
+	}
+	stats.recent = append(stats.recent, FetchError{
+		Time:   time.Now(),
+		URL:    site.String(),
+		Reason: reason,
+		Error:  msg,
+	})
+}
+
+// Get the most recent failed requests, oldest first
+func (stats *Stats) RecentErrors() []FetchError {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return append([]FetchError(nil), stats.recent...)
+}
+
+// Count a saved page
+func (stats *Stats) saved() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.Saved++
+}
+
+// Count a skipped URL
+func (stats *Stats) skipped(reason string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.Skipped[reason]++
+}
+
+// Get the counts shown in the crawl's progress
+func (stats *Stats) progress() (failed int, bytes int64) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.TotalFailed(), stats.Bytes
+}
+
+// Mark the end of the crawl
+func (stats *Stats) finish() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.End = time.Now()
+}
+
+// Get the time the crawl took, or has taken so far
+func (stats *Stats) Elapsed() time.Duration {
+	if stats.End.IsZero() {
+		return time.Since(stats.Start)
+	}
+	return stats.End.Sub(stats.Start)
+}
+
+// Get the pages fetched per second
+func (stats *Stats) PageRate() float64 {
+	if secs := stats.Elapsed().Seconds(); secs > 0 {
+		return float64(stats.Fetched) / secs
+	}
+	return 0
+}
+
+// Get the bytes downloaded per second
+func (stats *Stats) ByteRate() float64 {
+	if secs := stats.Elapsed().Seconds(); secs > 0 {
+		return float64(stats.Bytes) / secs
+	}
+	return 0
+}
+
+// Get the links which were found to be broken
+func (stats *Stats) BrokenLinks() []LinkResult {
+	var broken []LinkResult
+	for _, link := range stats.Links {
+		if link.Broken() {
+			broken = append(broken, link)
+		}
+	}
+	return broken
+}
+
+// Get the total number of skipped URLs
+func (stats *Stats) TotalSkipped() int {
+	return sumCounts(stats.Skipped)
+}
+
+// Get the total number of failed requests
+func (stats *Stats) TotalFailed() int {
+	return sumCounts(stats.Failed)
+}
+
+// Encode the statistics as JSON, including the elapsed time and throughput
+func (stats *Stats) MarshalJSON() ([]byte, error) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	type fields Stats
+	return json.Marshal(struct {
+		*fields
+		ElapsedSecs float64 `json:"elapsed_secs"`
+		PageRate    float64 `json:"pages_per_sec"`
+		ByteRate    float64 `json:"bytes_per_sec"`
+	}{
+		fields:      (*fields)(stats),
+		ElapsedSecs: stats.Elapsed().Seconds(),
+		PageRate:    stats.PageRate(),
+		ByteRate:    stats.ByteRate(),
+	})
+}
+
+// Write a human-readable summary of the statistics
+func (stats *Stats) WriteReport(w io.Writer) error {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Fetched %d pages (%s) in %v: %.2f pages/sec, %s/sec\n",
+		stats.Fetched, FormatBytes(float64(stats.Bytes)),
+		stats.Elapsed().Round(time.Second), stats.PageRate(),
+		FormatBytes(stats.ByteRate()))
+	fmt.Fprintf(tw, "Saved:\t%d\n", stats.Saved)
+	fmt.Fprintf(tw, "Skipped:\t%d\n", stats.TotalSkipped())
+	writeCounts(tw, stats.Skipped)
+	fmt.Fprintf(tw, "Failed:\t%d\n", stats.TotalFailed())
+	writeCounts(tw, stats.Failed)
+	fmt.Fprintf(tw, "Hosts:\t%d\n", len(stats.Hosts))
+	writeCounts(tw, stats.Hosts)
+	fmt.Fprintf(tw, "Depths:\n")
+	depths := make(map[string]int, len(stats.Depths))
+	for depth, count := range stats.Depths {
+		depths[fmt.Sprintf("%3d", depth)] = count
+	}
+	writeCounts(tw, depths)
+	return tw.Flush()
+}
+
+// Format a number of bytes for display
+func FormatBytes(bytes float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for ; bytes >= 1024 && i < len(units)-1; i++ {
+		bytes /= 1024
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", bytes, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[i])
+}
+
+// Add up a set of counts
+func sumCounts(counts map[string]int) (total int) {
+	for _, count := range counts {
+		total += count
+	}
+	return
+}
+
+// Write a set of counts, indented and sorted by key
+func writeCounts(w io.Writer, counts map[string]int) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %s\t%d\n", key, counts[key])
+	}
+}
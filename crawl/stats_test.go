@@ -0,0 +1,81 @@
+package crawl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	Convey("Given the statistics for a finished crawl", t, func() {
+		site := func(s string) *url.URL {
+			u, _ := url.Parse(s)
+			return u
+		}
+		stats := NewStats()
+		stats.fetched(site("http://a.com/"), 1, 200, 1024)
+		stats.fetched(site("http://a.com/page.html"), 2, 200, 1024)
+		stats.fetched(site("http://b.com/missing.html"), 2, 404, 100)
+		stats.failed(site("http://c.com/"), FailError, errors.New("connection refused"))
+		stats.saved()
+		stats.saved()
+		stats.skipped(SkipOutOfScope)
+		stats.finish()
+		stats.Start = stats.End.Add(-2 * time.Second)
+
+		Convey("Then I count the pages", func() {
+			So(stats.Fetched, ShouldEqual, 2)
+			So(stats.Saved, ShouldEqual, 2)
+			So(stats.Bytes, ShouldEqual, int64(2148))
+			So(stats.TotalSkipped(), ShouldEqual, 1)
+			So(stats.TotalFailed(), ShouldEqual, 2)
+			So(stats.Failed, ShouldResemble, map[string]int{"404": 1, FailError: 1})
+			So(stats.Hosts, ShouldResemble, map[string]int{"a.com": 2})
+			So(stats.Depths, ShouldResemble, map[int]int{1: 1, 2: 1})
+		})
+
+		Convey("Then I remember the recent errors", func() {
+			recent := stats.RecentErrors()
+			So(len(recent), ShouldEqual, 2)
+			So(recent[0].URL, ShouldEqual, "http://b.com/missing.html")
+			So(recent[0].Error, ShouldEqual, "404 Not Found")
+			So(recent[1].Error, ShouldEqual, "connection refused")
+		})
+
+		Convey("Then I calculate the throughput", func() {
+			So(stats.Elapsed(), ShouldEqual, 2*time.Second)
+			So(stats.PageRate(), ShouldEqual, 1.0)
+			So(stats.ByteRate(), ShouldEqual, 1074.0)
+		})
+
+		Convey("When I write a report", func() {
+			var buf bytes.Buffer
+			So(stats.WriteReport(&buf), ShouldBeNil)
+
+			Convey("Then it summarizes the crawl", func() {
+				So(buf.String(), ShouldStartWith,
+					"Fetched 2 pages (2.1 KB) in 2s: 1.00 pages/sec, 1.0 KB/sec\n")
+				So(buf.String(), ShouldContainSubstring, "  out-of-scope  1\n")
+				So(buf.String(), ShouldContainSubstring, "  404           1\n")
+			})
+		})
+
+		Convey("When I encode it as JSON", func() {
+			data, err := json.Marshal(stats)
+			So(err, ShouldBeNil)
+			var decoded map[string]interface{}
+			So(json.Unmarshal(data, &decoded), ShouldBeNil)
+
+			Convey("Then it includes the counts and throughput", func() {
+				So(decoded["fetched"], ShouldEqual, 2.0)
+				So(decoded["elapsed_secs"], ShouldEqual, 2.0)
+				So(decoded["pages_per_sec"], ShouldEqual, 1.0)
+				So(decoded["depths"], ShouldResemble, map[string]interface{}{"1": 1.0, "2": 1.0})
+			})
+		})
+	})
+}
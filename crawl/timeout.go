@@ -0,0 +1,341 @@
+package crawl
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Failure reasons for requests which took too long
+const (
+	// Connecting to the server or proxy took longer than Timeouts.Connect
+	FailConnectTimeout = "connect-timeout"
+
+	// The TLS handshake took longer than Timeouts.TLSHandshake
+	FailTLSTimeout = "tls-timeout"
+
+	// The server took longer than Timeouts.Header to start responding
+	FailHeaderTimeout = "header-timeout"
+
+	// The whole request took longer than Timeouts.Total
+	FailTimeout = "timeout"
+
+	// The response body downloaded slower than Timeouts.MinRate
+	FailTooSlow = "too-slow"
+)
+
+// Limits on how long each part of a request may take. A zero value means no
+// limit.
+type Timeouts struct {
+
+	// The time to connect to the server or proxy
+	Connect time.Duration
+
+	// The time for the TLS handshake
+	TLSHandshake time.Duration
+
+	// The time from sending the request to receiving the response headers
+	Header time.Duration
+
+	// The time for the whole request, including reading the response body
+	Total time.Duration
+
+	// The minimum bytes per second at which the response body must download,
+	// measured over each MinRateWindow. Time spent waiting for the crawl's
+	// own bandwidth limit doesn't count.
+	MinRate int64
+
+	// The period over which MinRate is measured (default 30 seconds)
+	MinRateWindow time.Duration
+}
+
+// The default period over which the minimum download rate is measured
+const defaultMinRateWindow = 30 * time.Second
+
+// An error for a request which was aborted because it took too long
+type TimeoutError struct {
+
+	// Why the request was aborted, such as FailConnectTimeout
+	Reason string
+
+	// The limit the request exceeded
+	Limit time.Duration
+
+	// The minimum download rate, for FailTooSlow
+	MinRate int64
+}
+
+// Describe the timeout
+func (err *TimeoutError) Error() string {
+	switch err.Reason {
+	case FailConnectTimeout:
+		return fmt.Sprintf("Timed out connecting after %v", err.Limit)
+	case FailTLSTimeout:
+		return fmt.Sprintf("Timed out in the TLS handshake after %v", err.Limit)
+	case FailHeaderTimeout:
+		return fmt.Sprintf("Timed out waiting for the response after %v", err.Limit)
+	case FailTooSlow:
+		return fmt.Sprintf("Aborted a download slower than %s/sec over %v",
+			FormatBytes(float64(err.MinRate)), err.Limit)
+	default:
+		return fmt.Sprintf("Timed out after %v", err.Limit)
+	}
+}
+
+// Ask whether the error is a timeout, for net.Error
+func (err *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Get the failure reason for an error from a request
+func failReason(err error) string {
+	if timeout, ok := err.(*TimeoutError); ok {
+		return timeout.Reason
+	}
+	return FailError
+}
+
+// Enforces the timeouts for a single request, cancelling it when one expires
+type requestWatch struct {
+	timeouts Timeouts
+	cancel   context.CancelFunc
+
+	mu        sync.Mutex
+	timers    map[string]*watchTimer
+	expired   *TimeoutError
+	read      int64
+	closed    bool
+	excluding bool
+
+	// The total time spent in finished waits excluded from the timeouts, and
+	// when the current one started
+	excluded       time.Duration
+	excludingSince time.Time
+}
+
+// A running timer, with when it expires and the limit it enforces
+type watchTimer struct {
+	*time.Timer
+	deadline time.Time
+	limit    time.Duration
+}
+
+// The context key under which a request's watch is kept, so that fetchers
+// can exclude their waits from its timeouts
+type watchKey struct{}
+
+// Start watching a request, returning the request to send in its place
+func (timeouts Timeouts) watch(req *http.Request) (*http.Request, *requestWatch) {
+	ctx, cancel := context.WithCancel(req.Context())
+	watch := &requestWatch{
+		timeouts: timeouts,
+		cancel:   cancel,
+		timers:   make(map[string]*watchTimer),
+	}
+	ctx = context.WithValue(ctx, watchKey{}, watch)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) {
+			watch.start(FailConnectTimeout, timeouts.Connect)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			watch.stop(FailConnectTimeout)
+		},
+		TLSHandshakeStart: func() {
+			watch.start(FailTLSTimeout, timeouts.TLSHandshake)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			watch.stop(FailTLSTimeout)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			watch.start(FailHeaderTimeout, timeouts.Header)
+		},
+		GotFirstResponseByte: func() {
+			watch.stop(FailHeaderTimeout)
+		},
+	})
+	watch.start(FailTimeout, timeouts.Total)
+	return req.WithContext(ctx), watch
+}
+
+// Start a timer which aborts the request if it expires
+func (watch *requestWatch) start(reason string, limit time.Duration) {
+	if limit <= 0 {
+		return
+	}
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+	if _, running := watch.timers[reason]; running || watch.closed {
+		return
+	}
+	watch.timers[reason] = watch.newTimer(reason, limit, limit)
+}
+
+// Create a timer which aborts the request after a time, reporting the limit
+// it enforces
+func (watch *requestWatch) newTimer(reason string, limit, after time.Duration) *watchTimer {
+	return &watchTimer{
+		Timer: time.AfterFunc(after, func() {
+			watch.expire(&TimeoutError{Reason: reason, Limit: limit})
+		}),
+		deadline: time.Now().Add(after),
+		limit:    limit,
+	}
+}
+
+// Run a function, such as one waiting for the crawl's own rate limits,
+// without counting the time it takes against the request's timeouts
+func (watch *requestWatch) exclude(wait func()) {
+	watch.mu.Lock()
+	if watch.closed || watch.excluding {
+		watch.mu.Unlock()
+		wait()
+		return
+	}
+	watch.excluding = true
+	now := time.Now()
+	watch.excludingSince = now
+	remaining := make(map[string]time.Duration)
+	for reason, timer := range watch.timers {
+		if timer.Stop() {
+			remaining[reason] = timer.deadline.Sub(now)
+		}
+	}
+	watch.mu.Unlock()
+
+	wait()
+
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+	watch.excluding = false
+	watch.excluded += time.Since(now)
+	if watch.closed {
+		return
+	}
+	for reason, left := range remaining {
+		if timer, ok := watch.timers[reason]; ok {
+			watch.timers[reason] = watch.newTimer(reason, timer.limit, left)
+		}
+	}
+}
+
+// Get the total time spent in waits excluded from the timeouts so far,
+// including any wait in progress. The caller must hold the lock.
+func (watch *requestWatch) waited() time.Duration {
+	if watch.excluding {
+		return watch.excluded + time.Since(watch.excludingSince)
+	}
+	return watch.excluded
+}
+
+// Run a function without counting the time it takes against the timeouts of
+// the request with a given context, if the request is watched
+func excludeWait(ctx context.Context, wait func()) {
+	if watch, ok := ctx.Value(watchKey{}).(*requestWatch); ok {
+		watch.exclude(wait)
+	} else {
+		wait()
+	}
+}
+
+// Stop a timer
+func (watch *requestWatch) stop(reason string) {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+	if timer, ok := watch.timers[reason]; ok {
+		timer.Stop()
+		delete(watch.timers, reason)
+	}
+}
+
+// Abort the request, unless it already finished
+func (watch *requestWatch) expire(err *TimeoutError) {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+	if watch.closed || watch.expired != nil {
+		return
+	}
+	watch.expired = err
+	watch.cancel()
+}
+
+// Watch the download rate of a response body, aborting the request if it
+// falls below the minimum. Each window is extended by the time spent in
+// excluded waits, such as for the bandwidth limit, from when the body starts.
+func (watch *requestWatch) body(r io.Reader) io.Reader {
+	if watch.timeouts.MinRate <= 0 {
+		return r
+	}
+	window := watch.timeouts.MinRateWindow
+	if window <= 0 {
+		window = defaultMinRateWindow
+	}
+	watch.mu.Lock()
+	lastWaited := watch.waited()
+	watch.mu.Unlock()
+	var (
+		check   func()
+		last    int64
+		started = time.Now()
+	)
+	check = func() {
+		watch.mu.Lock()
+		read, waited, closed := watch.read, watch.waited(), watch.closed
+		watch.mu.Unlock()
+		active := time.Since(started) - (waited - lastWaited)
+		if closed {
+			return
+		} else if active < window {
+			time.AfterFunc(window-active, check)
+			return
+		} else if float64(read-last) < float64(watch.timeouts.MinRate)*active.Seconds() {
+			watch.expire(&TimeoutError{Reason: FailTooSlow, Limit: window, MinRate: watch.timeouts.MinRate})
+			return
+		}
+		last, lastWaited, started = read, waited, time.Now()
+		time.AfterFunc(window, check)
+	}
+	time.AfterFunc(window, check)
+	return watchedReader{r, watch}
+}
+
+// Get the error for a failed request: the timeout which aborted it, if any,
+// or else the error itself
+func (watch *requestWatch) error(err error) error {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+	if watch.expired != nil {
+		return watch.expired
+	}
+	return err
+}
+
+// Stop watching the request
+func (watch *requestWatch) close() {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+	watch.closed = true
+	for _, timer := range watch.timers {
+		timer.Stop()
+	}
+	watch.cancel()
+}
+
+// Counts the bytes read from a watched response body
+type watchedReader struct {
+	r     io.Reader
+	watch *requestWatch
+}
+
+// Read bytes
+func (r watchedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.watch.mu.Lock()
+	r.watch.read += int64(n)
+	r.watch.mu.Unlock()
+	return n, err
+}
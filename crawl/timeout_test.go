@@ -0,0 +1,209 @@
+package crawl
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// A fetcher which starts connecting or a TLS handshake, and never finishes
+type stuckFetcher struct {
+	tls bool
+}
+
+// Block until the request is cancelled
+func (fetcher stuckFetcher) Do(req *http.Request) (*http.Response, error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if fetcher.tls {
+		trace.TLSHandshakeStart()
+	} else {
+		trace.ConnectStart("tcp", req.URL.Host)
+	}
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestTimeouts(t *testing.T) {
+	Convey("Given a slow server", t, func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wait := func(d time.Duration) bool {
+				select {
+				case <-time.After(d):
+					return true
+				case <-r.Context().Done():
+					return false
+				}
+			}
+			switch r.URL.Path {
+			case "/silent":
+				wait(time.Second)
+			case "/stall":
+				w.Write([]byte("start"))
+				w.(http.Flusher).Flush()
+				wait(time.Second)
+			case "/large":
+				w.Write(make([]byte, 4000))
+			case "/trickle":
+				for i := 0; i < 50 && wait(20*time.Millisecond); i++ {
+					w.Write([]byte("."))
+					w.(http.Flusher).Flush()
+				}
+			}
+		}))
+		Reset(func() {
+			srv.Close()
+		})
+		srvURL, _ := url.Parse(srv.URL)
+		page := func(path string) *url.URL {
+			site, _ := srvURL.Parse(path)
+			return site
+		}
+
+		crawler := Crawler{
+			queue:   NewQueue(),
+			limiter: NewRateLimiter(HostLimit{}, nil, 0),
+			stats:   NewStats(),
+		}
+		reason := func(err error) string {
+			if timeout, ok := err.(*TimeoutError); ok {
+				return timeout.Reason
+			}
+			return ""
+		}
+
+		Convey("When the server doesn't respond in time", func() {
+			crawler.Timeouts.Header = 50 * time.Millisecond
+			_, _, err := crawler.get(page("/silent"))
+
+			Convey("Then I give up waiting for the headers", func() {
+				So(reason(err), ShouldEqual, FailHeaderTimeout)
+			})
+		})
+
+		Convey("When the server stalls sending the body", func() {
+			crawler.Timeouts.Header = 50 * time.Millisecond
+			crawler.Timeouts.Total = 150 * time.Millisecond
+			_, _, err := crawler.get(page("/stall"))
+
+			Convey("Then I give up on the whole request", func() {
+				So(reason(err), ShouldEqual, FailTimeout)
+				So(err.Error(), ShouldEqual, "Timed out after 150ms")
+			})
+		})
+
+		Convey("When the server trickles the body", func() {
+			crawler.Timeouts.MinRate = 1000
+			crawler.Timeouts.MinRateWindow = 100 * time.Millisecond
+			_, _, err := crawler.get(page("/trickle"))
+
+			Convey("Then I abort the download", func() {
+				So(reason(err), ShouldEqual, FailTooSlow)
+			})
+		})
+
+		Convey("When the download is held back by the bandwidth limit", func() {
+			crawler.Timeouts.MinRate = 50000
+			crawler.Timeouts.MinRateWindow = 100 * time.Millisecond
+			crawler.limiter = NewRateLimiter(HostLimit{}, nil, 2000)
+			_, body, err := crawler.get(page("/large"))
+
+			Convey("Then the wait doesn't count against the minimum rate", func() {
+				So(err, ShouldBeNil)
+				So(len(body), ShouldEqual, 4000)
+			})
+		})
+
+		Convey("When the server trickles the body after I wait for the host's rate limit", func() {
+			crawler.Timeouts.MinRate = 1000
+			crawler.Timeouts.MinRateWindow = 100 * time.Millisecond
+			crawler.limiter = NewRateLimiter(HostLimit{Concurrency: 1}, nil, 0)
+			release := crawler.limiter.Acquire(srvURL.Host)
+			time.AfterFunc(500*time.Millisecond, release)
+			start := time.Now()
+			_, _, err := crawler.get(page("/trickle"))
+
+			Convey("Then the wait doesn't delay the first check of the download rate", func() {
+				So(reason(err), ShouldEqual, FailTooSlow)
+				So(time.Since(start), ShouldBeLessThan, 800*time.Millisecond)
+			})
+		})
+
+		Convey("When the server responds in time", func() {
+			crawler.Timeouts = Timeouts{
+				Connect:       time.Second,
+				Header:        time.Second,
+				Total:         2 * time.Second,
+				MinRate:       1,
+				MinRateWindow: time.Second,
+			}
+			resp, _, err := crawler.get(page("/"))
+
+			Convey("Then I get the response", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When I wait for the host's rate limit", func() {
+			crawler.Timeouts.Total = 100 * time.Millisecond
+			crawler.limiter = NewRateLimiter(HostLimit{Concurrency: 1}, nil, 0)
+			release := crawler.limiter.Acquire(srvURL.Host)
+			time.AfterFunc(200*time.Millisecond, release)
+			resp, _, err := crawler.get(page("/"))
+
+			Convey("Then the wait doesn't count against the timeouts", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When I crawl a page which times out", func() {
+			crawler.Timeouts.Header = 50 * time.Millisecond
+			crawler.fetch(QueueItem{URL: page("/silent"), Depth: 1}, false)
+
+			Convey("Then I count the failure by its reason", func() {
+				So(crawler.stats.Failed, ShouldResemble, map[string]int{FailHeaderTimeout: 1})
+				errors := crawler.stats.RecentErrors()
+				So(len(errors), ShouldEqual, 1)
+				So(errors[0].Reason, ShouldEqual, FailHeaderTimeout)
+			})
+		})
+	})
+
+	Convey("Given a server which can't be reached", t, func() {
+		site, _ := url.Parse("http://unreachable.invalid/")
+		crawler := Crawler{
+			Timeouts: Timeouts{
+				Connect:      50 * time.Millisecond,
+				TLSHandshake: 50 * time.Millisecond,
+			},
+			queue:   NewQueue(),
+			limiter: NewRateLimiter(HostLimit{}, nil, 0),
+			stats:   NewStats(),
+		}
+
+		Convey("When connecting never finishes", func() {
+			crawler.Fetcher = stuckFetcher{}
+			_, _, err := crawler.get(site)
+
+			Convey("Then I give up connecting", func() {
+				So(err, ShouldHaveSameTypeAs, &TimeoutError{})
+				So(err.(*TimeoutError).Reason, ShouldEqual, FailConnectTimeout)
+			})
+		})
+
+		Convey("When the TLS handshake never finishes", func() {
+			crawler.Fetcher = stuckFetcher{tls: true}
+			_, _, err := crawler.get(site)
+
+			Convey("Then I give up on the handshake", func() {
+				So(err, ShouldHaveSameTypeAs, &TimeoutError{})
+				So(err.(*TimeoutError).Reason, ShouldEqual, FailTLSTimeout)
+			})
+		})
+	})
+}
@@ -0,0 +1,121 @@
+package crawl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// How to connect to a host: through which proxy, and with which TLS settings
+type Connection struct {
+
+	// The HTTP, HTTPS or SOCKS5 proxy to send requests through, or nil to
+	// use the proxy given by the environment, if any
+	Proxy *url.URL
+
+	// The TLS settings, or nil for the defaults
+	TLS *tls.Config
+}
+
+// Load TLS settings: a file of extra PEM certificate authorities to trust, a
+// client certificate and key for mutual TLS, and whether to skip verifying
+// servers' certificates. Returns nil if all are empty.
+func LoadTLSConfig(caCert, clientCert, clientKey string, insecure bool) (*tls.Config, error) {
+	if caCert == "" && clientCert == "" && clientKey == "" && !insecure {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", caCert)
+		}
+	}
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, fmt.Errorf("A client certificate needs both a certificate and a key")
+		}
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Sends each request with the transport for its host
+type hostTransport struct {
+	base  http.RoundTripper
+	hosts map[string]http.RoundTripper
+}
+
+// Send a request
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := t.hosts[req.URL.Host]; ok {
+		return transport.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Create the transport for the crawler's connection settings, or nil to use
+// http.DefaultTransport
+func (crawler *Crawler) newTransport() http.RoundTripper {
+	if crawler.Connection == (Connection{}) && len(crawler.HostConnections) == 0 &&
+		len(crawler.NoProxy) == 0 {
+		return nil
+	}
+	transport := &hostTransport{
+		base:  crawler.connect(crawler.Connection),
+		hosts: make(map[string]http.RoundTripper),
+	}
+	for host, conn := range crawler.HostConnections {
+		transport.hosts[host] = crawler.connect(conn)
+	}
+	return transport
+}
+
+// Create a transport which connects with the given settings
+func (crawler *Crawler) connect(conn Connection) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL.Hostname(), crawler.NoProxy) {
+			return nil, nil
+		} else if conn.Proxy != nil {
+			return conn.Proxy, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+	if conn.TLS != nil {
+		transport.TLSClientConfig = conn.TLS.Clone()
+	}
+	return transport
+}
+
+// Ask whether to connect to a host directly rather than through the proxy.
+// Each entry in noProxy is a host, which also matches its subdomains, or "*"
+// for every host.
+func bypassProxy(host string, noProxy []string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(entry), "."))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if entry == "*" || host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package crawl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write a self-signed client certificate and its key to PEM files
+func writeClientCert(folder string) (certPath, keyPath string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webcp-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return
+	}
+	certPath = filepath.Join(folder, "client.pem")
+	keyPath = filepath.Join(folder, "client.key")
+	if err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return
+	}
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return
+}
+
+func TestTransport(t *testing.T) {
+	Convey("Given a list of hosts to reach without the proxy", t, func() {
+		noProxy := []string{"intranet.corp", ".example.com", "localhost:8080"}
+
+		Convey("Then the hosts and their subdomains bypass the proxy", func() {
+			So(bypassProxy("intranet.corp", noProxy), ShouldBeTrue)
+			So(bypassProxy("wiki.intranet.corp", noProxy), ShouldBeTrue)
+			So(bypassProxy("example.com", noProxy), ShouldBeTrue)
+			So(bypassProxy("LOCALHOST", noProxy), ShouldBeTrue)
+		})
+
+		Convey("Then other hosts use the proxy", func() {
+			So(bypassProxy("notintranet.corp", noProxy), ShouldBeFalse)
+			So(bypassProxy("example.org", noProxy), ShouldBeFalse)
+		})
+
+		Convey("Then * bypasses the proxy for every host", func() {
+			So(bypassProxy("example.org", []string{"*"}), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a crawler with no connection settings", t, func() {
+		crawler := Crawler{}
+
+		Convey("Then it uses the default transport", func() {
+			So(crawler.newTransport(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a proxy", t, func() {
+		var proxied []string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied = append(proxied, r.URL.String())
+		}))
+		Reset(func() {
+			proxy.Close()
+		})
+		proxyURL, _ := url.Parse(proxy.URL)
+		crawler := Crawler{
+			Connection: Connection{Proxy: proxyURL},
+			NoProxy:    []string{"direct.invalid"},
+			queue:      NewQueue(),
+			limiter:    NewRateLimiter(HostLimit{}, nil, 0),
+			stats:      NewStats(),
+		}
+		crawler.client = crawler.newClient()
+
+		Convey("When I fetch a page", func() {
+			site, _ := url.Parse("http://www.example.com/page.html")
+			resp, _, err := crawler.get(site)
+
+			Convey("Then I send the request through the proxy", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(proxied, ShouldResemble, []string{"http://www.example.com/page.html"})
+			})
+		})
+
+		Convey("When I fetch a page from a host which bypasses the proxy", func() {
+			site, _ := url.Parse("http://direct.invalid/")
+			_, _, err := crawler.get(site)
+
+			Convey("Then I connect directly", func() {
+				So(err, ShouldNotBeNil)
+				So(proxied, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a server with a private certificate which requires a client certificate", t, func() {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+		srv.StartTLS()
+		Reset(func() {
+			srv.Close()
+		})
+		srvURL, _ := url.Parse(srv.URL)
+
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		caPath := filepath.Join(folder, "ca.pem")
+		So(ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: srv.Certificate().Raw,
+		}), 0644), ShouldBeNil)
+		certPath, keyPath, err := writeClientCert(folder)
+		So(err, ShouldBeNil)
+
+		fetch := func(conn Connection) error {
+			crawler := Crawler{
+				HostConnections: map[string]Connection{srvURL.Host: conn},
+				queue:           NewQueue(),
+				limiter:         NewRateLimiter(HostLimit{}, nil, 0),
+				stats:           NewStats(),
+			}
+			crawler.client = crawler.newClient()
+			_, _, err := crawler.get(srvURL)
+			return err
+		}
+
+		Convey("When I trust its certificate and present mine", func() {
+			config, err := LoadTLSConfig(caPath, certPath, keyPath, false)
+			So(err, ShouldBeNil)
+
+			Convey("Then I can fetch its pages", func() {
+				So(fetch(Connection{TLS: config}), ShouldBeNil)
+			})
+		})
+
+		Convey("When I don't verify its certificate", func() {
+			config, err := LoadTLSConfig("", certPath, keyPath, true)
+			So(err, ShouldBeNil)
+
+			Convey("Then I can fetch its pages", func() {
+				So(fetch(Connection{TLS: config}), ShouldBeNil)
+			})
+		})
+
+		Convey("When I don't trust its certificate", func() {
+			config, err := LoadTLSConfig("", certPath, keyPath, false)
+			So(err, ShouldBeNil)
+
+			Convey("Then I can't fetch its pages", func() {
+				So(fetch(Connection{TLS: config}), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I don't present a client certificate", func() {
+			config, err := LoadTLSConfig(caPath, "", "", false)
+			So(err, ShouldBeNil)
+
+			Convey("Then I can't fetch its pages", func() {
+				So(fetch(Connection{TLS: config}), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I give a client certificate without its key", func() {
+			_, err := LoadTLSConfig("", certPath, "", false)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,234 @@
+package crawl
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Reasons for skipping URLs which look like crawler traps
+const (
+	// The URL is longer than TrapLimits.URLLength
+	SkipLongURL = "trap-url-length"
+
+	// A segment of the URL's path appears more than TrapLimits.SegmentRepeats
+	// times, as in /a/b/a/b/a/b
+	SkipRepeatedSegments = "trap-repeated-segments"
+
+	// More than TrapLimits.QueryVariants query strings were queued for the
+	// URL's path, as with an endless calendar or session IDs in the query
+	SkipQueryVariants = "trap-query-variants"
+
+	// The page is nearly identical to more than TrapLimits.SimilarPages
+	// pages already crawled from its host, so its links were not followed
+	SkipSimilarPage = "trap-similar-page"
+)
+
+// The number of bits in which two pages' fingerprints may differ for the
+// pages to count as nearly identical
+const similarBits = 3
+
+// The fewest words a page may have to be fingerprinted. Shorter pages, such
+// as redirects, frames and pages drawn by scripts, have too little text to
+// tell them apart.
+const minFingerprintWords = 20
+
+var (
+	// The tags, scripts and styles of a page, which are left out of its
+	// fingerprint
+	fingerprintMarkup = regexp.MustCompile(`(?is)<script.*?</script>|<style.*?</style>|<[^>]*>`)
+
+	// The words of a page which count towards its fingerprint. Numbers are
+	// left out, so pages differing only by dates or IDs look the same.
+	fingerprintWords = regexp.MustCompile(`[\pL]+`)
+)
+
+// Limits which keep the crawl out of crawler traps, such as infinite
+// calendars, session IDs in URLs and links which repeat their page's path. A
+// zero value means no limit.
+type TrapLimits struct {
+
+	// The maximum length of a URL to crawl
+	URLLength int
+
+	// The maximum number of times any one segment may appear in a URL's path
+	SegmentRepeats int
+
+	// The maximum number of query strings to crawl for a single path
+	QueryVariants int
+
+	// The maximum number of nearly identical pages from a host whose links
+	// are followed
+	SimilarPages int
+}
+
+// The limits which keep a crawl out of traps by default
+var DefaultTrapLimits = TrapLimits{
+	URLLength:      2048,
+	SegmentRepeats: 2,
+	QueryVariants:  500,
+}
+
+// The URLs and pages seen by the trap heuristics, shared by the crawl's
+// workers
+type trapState struct {
+	limits TrapLimits
+
+	// The query strings queued for each path, by host and path
+	queries map[string]map[string]bool
+
+	// The fingerprints of the pages crawled, by host
+	pages map[string]*pageIndex
+
+	mu sync.Mutex
+}
+
+// The fingerprints of the pages crawled from a host, and the indexes of those
+// with each value of each 16-bit band, so that pages differing in at most
+// similarBits bits are found without comparing every pair
+type pageIndex struct {
+	fingerprints []uint64
+	bands        [4]map[uint16][]int
+}
+
+// Track the URLs and pages seen by the trap heuristics
+func newTrapState(limits TrapLimits) *trapState {
+	return &trapState{
+		limits:  limits,
+		queries: make(map[string]map[string]bool),
+		pages:   make(map[string]*pageIndex),
+	}
+}
+
+// Check a URL about to be queued against the limits on URLs, returning the
+// reason to skip it, or "" to queue it
+func (state *trapState) check(site *url.URL) string {
+	if state == nil {
+		return ""
+	}
+	limits := state.limits
+	if limits.URLLength > 0 && len(site.String()) > limits.URLLength {
+		return SkipLongURL
+	}
+	if limits.SegmentRepeats > 0 {
+		counts := make(map[string]int)
+		for _, segment := range strings.Split(site.EscapedPath(), "/") {
+			if segment == "" {
+				continue
+			}
+			if counts[segment]++; counts[segment] > limits.SegmentRepeats {
+				return SkipRepeatedSegments
+			}
+		}
+	}
+	if limits.QueryVariants > 0 && site.RawQuery != "" {
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		path := strings.ToLower(site.Host) + site.EscapedPath()
+		queries := state.queries[path]
+		if queries == nil {
+			queries = make(map[string]bool)
+			state.queries[path] = queries
+		}
+		if !queries[site.RawQuery] {
+			if len(queries) >= limits.QueryVariants {
+				return SkipQueryVariants
+			}
+			queries[site.RawQuery] = true
+		}
+	}
+	return ""
+}
+
+// Record a crawled HTML page's fingerprint, and ask whether more than the
+// limit of nearly identical pages have already been crawled from its host.
+// Other content, and pages with too few words to tell apart, are never
+// similar.
+func (state *trapState) similar(site *url.URL, contentType string, body []byte) bool {
+	if state == nil || state.limits.SimilarPages <= 0 {
+		return false
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "text/html" {
+		return false
+	}
+	fp, words := fingerprint(body)
+	if words < minFingerprintWords {
+		return false
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	host := strings.ToLower(site.Host)
+	index := state.pages[host]
+	if index == nil {
+		index = &pageIndex{}
+		for i := range index.bands {
+			index.bands[i] = make(map[uint16][]int)
+		}
+		state.pages[host] = index
+	}
+
+	// Count the earlier pages within similarBits of this one. Any such page
+	// matches it exactly in at least one band.
+	var (
+		seen    = make(map[int]bool)
+		similar int
+	)
+	for band := range index.bands {
+		for _, i := range index.bands[band][fingerprintBand(fp, band)] {
+			if seen[i] {
+				continue
+			}
+			seen[i] = true
+			if bits.OnesCount64(index.fingerprints[i]^fp) <= similarBits {
+				if similar++; similar >= state.limits.SimilarPages {
+					return true
+				}
+			}
+		}
+	}
+
+	i := len(index.fingerprints)
+	index.fingerprints = append(index.fingerprints, fp)
+	for band := range index.bands {
+		key := fingerprintBand(fp, band)
+		index.bands[band][key] = append(index.bands[band][key], i)
+	}
+	return false
+}
+
+// Get a fingerprint of a page's text, a 64-bit SimHash of its words, which
+// differs in few bits between pages with mostly the same words, and the
+// number of words it was made from
+func fingerprint(body []byte) (uint64, int) {
+	var weights [64]int
+	text := fingerprintMarkup.ReplaceAll(body, []byte(" "))
+	words := fingerprintWords.FindAll(text, -1)
+	for _, word := range words {
+		hash := fnv.New64a()
+		hash.Write([]byte(strings.ToLower(string(word))))
+		sum := hash.Sum64()
+		for bit := range weights {
+			if sum&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	var fp uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fp |= 1 << uint(bit)
+		}
+	}
+	return fp, len(words)
+}
+
+// Get one of the four 16-bit bands of a fingerprint
+func fingerprintBand(fp uint64, band int) uint16 {
+	return uint16(fp >> uint(16*band))
+}
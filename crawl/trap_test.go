@@ -0,0 +1,167 @@
+package crawl
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A site full of traps: a folder which links to a subfolder of itself, a
+// calendar which links to the next month forever, and pages which only
+// differ by a session ID linking to each other
+type trapSite struct{}
+
+func (trapSite) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/docs/":
+		io.WriteString(w, `<a href="a/">Loop</a> <a href="calendar?month=1">Calendar</a> `+
+			`<a href="session/1.html">Session</a>`)
+	case strings.HasPrefix(req.URL.Path, "/docs/a/"):
+		io.WriteString(w, `<a href="a/">Loop</a>`)
+	case req.URL.Path == "/docs/calendar":
+		month, _ := strconv.Atoi(req.URL.Query().Get("month"))
+		fmt.Fprintf(w, `<a href="calendar?month=%d">Next month</a>`, month+1)
+	case strings.HasPrefix(req.URL.Path, "/docs/session/"):
+		id, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/docs/session/"), ".html"))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<html><body><h1>Welcome</h1><p>Your session %d has started. You can browse `+
+			`the whole catalog, save pages for later and come back to them at any time.</p>`+
+			`<a href="%d.html">Continue</a></body></html>`, id, id+1)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func TestTraps(t *testing.T) {
+	Convey("Given limits on URLs", t, func() {
+		traps := newTrapState(TrapLimits{URLLength: 40, SegmentRepeats: 2, QueryVariants: 2})
+		check := func(site string) string {
+			u, _ := url.Parse(site)
+			return traps.check(u)
+		}
+
+		Convey("Then long URLs are skipped", func() {
+			So(check("http://example.com/docs/"), ShouldEqual, "")
+			So(check("http://example.com/docs/"+strings.Repeat("x", 20)), ShouldEqual, SkipLongURL)
+		})
+
+		Convey("Then URLs which repeat a path segment too often are skipped", func() {
+			So(check("http://example.com/a/b/a/b/"), ShouldEqual, "")
+			So(check("http://example.com/a/b/a/b/a/"), ShouldEqual, SkipRepeatedSegments)
+		})
+
+		Convey("Then too many query strings for a path are skipped", func() {
+			So(check("http://example.com/cal?m=1"), ShouldEqual, "")
+			So(check("http://example.com/cal?m=2"), ShouldEqual, "")
+			So(check("http://example.com/cal?m=1"), ShouldEqual, "")
+			So(check("http://example.com/cal?m=3"), ShouldEqual, SkipQueryVariants)
+			So(check("http://example.com/other?m=3"), ShouldEqual, "")
+			So(check("http://example.com/cal"), ShouldEqual, "")
+		})
+	})
+
+	Convey("Given the default limits on URLs", t, func() {
+		traps := newTrapState(DefaultTrapLimits)
+		check := func(site string) string {
+			u, _ := url.Parse(site)
+			return traps.check(u)
+		}
+
+		Convey("Then a path which repeats its segments is skipped", func() {
+			So(check("http://example.com/a/b/a/b/"), ShouldEqual, "")
+			So(check("http://example.com/a/b/a/b/a/b"), ShouldEqual, SkipRepeatedSegments)
+		})
+	})
+
+	Convey("Given a limit on nearly identical pages", t, func() {
+		traps := newTrapState(TrapLimits{SimilarPages: 2})
+		page := func(id, text string) []byte {
+			return []byte(`<html><head><script>var session = "` + id + `";</script></head><body>` +
+				text + ` <a href="/page?sid=` + id + `">Next</a></body></html>`)
+		}
+		const (
+			html = "text/html; charset=utf-8"
+			text = "The quick brown fox jumps over the lazy dog while the cat watches from the fence, " +
+				"and the farmer counts his sheep before the rain comes"
+		)
+		site, _ := url.Parse("http://example.com/page")
+		other, _ := url.Parse("http://example.org/page")
+		similar := func(site *url.URL, contentType string, body []byte) bool {
+			return traps.similar(site, contentType, body)
+		}
+
+		Convey("Then pages differing only in markup and numbers are similar", func() {
+			fp1, _ := fingerprint(page("1", text+" on day 1"))
+			fp2, _ := fingerprint(page("2", text+" on day 2"))
+			So(fp1, ShouldEqual, fp2)
+		})
+
+		Convey("Then pages past the limit are reported", func() {
+			So(similar(site, html, page("a1", text)), ShouldBeFalse)
+			So(similar(site, html, page("b2", text)), ShouldBeFalse)
+			So(similar(site, html, page("d4", "Something else entirely, about gardening and tomatoes "+
+				"and the slugs which eat them, and how to keep them out of the garden this summer")), ShouldBeFalse)
+			So(similar(site, html, page("c3", text)), ShouldBeTrue)
+		})
+
+		Convey("Then pages are only compared with those from the same host", func() {
+			So(similar(site, html, page("a1", text)), ShouldBeFalse)
+			So(similar(site, html, page("b2", text)), ShouldBeFalse)
+			So(similar(other, html, page("c3", text)), ShouldBeFalse)
+		})
+
+		Convey("Then only HTML pages with enough words are compared", func() {
+			for i := 0; i < 3; i++ {
+				So(similar(site, "text/plain", page("a1", text)), ShouldBeFalse)
+				So(similar(site, "image/png", []byte("\x89PNG")), ShouldBeFalse)
+				So(similar(site, html, page("b2", "Loading")), ShouldBeFalse)
+			}
+		})
+	})
+
+	Convey("Given a site full of crawler traps", t, func() {
+		srv := httptest.NewServer(trapSite{})
+		Reset(func() {
+			srv.Close()
+		})
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		seed, _ := url.Parse(srv.URL + "/docs/")
+		crawler := Crawler{
+			Seeds:    []Seed{{URL: seed}},
+			Folder:   folder,
+			MaxDepth: 50,
+			Traps: TrapLimits{
+				URLLength:      2048,
+				SegmentRepeats: 3,
+				QueryVariants:  3,
+				SimilarPages:   4,
+			},
+		}
+
+		Convey("When I crawl it", func() {
+			stats, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the crawl ends, reporting each trap", func() {
+				So(stats.Skipped[SkipRepeatedSegments], ShouldEqual, 1)
+				So(stats.Skipped[SkipQueryVariants], ShouldEqual, 1)
+				So(stats.Skipped[SkipSimilarPage], ShouldEqual, 1)
+
+				// The seed, three loop folders, three months and five sessions
+				So(stats.Fetched, ShouldEqual, 12)
+			})
+		})
+	})
+}
@@ -0,0 +1,72 @@
+package crawl
+
+import (
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// The markup archives inject into the pages they serve: the Wayback
+	// Machine's scripts, styles and toolbar and the comments at the end of
+	// each page, and pywb's inserts
+	archiveMarkup = []*regexp.Regexp{
+		regexp.MustCompile(`(?s)<script[^>]*(?:bundle-playback|wombat|archive\.org/includes)[^>]*>.*?<!-- End Wayback Rewrite JS Include -->[ \t]*\r?\n?`),
+		regexp.MustCompile(`(?s)<!-- BEGIN WAYBACK TOOLBAR INSERT -->.*?<!-- END WAYBACK TOOLBAR INSERT -->[ \t]*\r?\n?`),
+		regexp.MustCompile(`(?s)<!--\s*(?:FILE ARCHIVED ON|playback timings).*?-->[ \t]*\r?\n?`),
+		regexp.MustCompile(`(?s)<!-- WB Insert -->.*?<!-- End WB Insert -->[ \t]*\r?\n?`),
+	}
+
+	// The URL of a capture: the archive's prefix, a timestamp with an
+	// optional modifier such as "id_" or "im_", and the original URL
+	archivedURL = regexp.MustCompile(`^(.*?/)\d{1,14}(?:[a-z]{2}_)?/https?:`)
+)
+
+// Remove the markup an archive injected into a page it served, and restore
+// the original URLs of the links it rewrote to point into the archive, so
+// the page is saved and parsed as it was on the live site. The archived URL
+// is that of the capture served, from which the archive's prefix is found.
+// Only text content is changed.
+func CleanArchived(body []byte, archived *url.URL, contentType string) []byte {
+	if !isText(contentType) {
+		return body
+	}
+	for _, markup := range archiveMarkup {
+		body = markup.ReplaceAll(body, nil)
+	}
+	if rewritten := rewrittenURLs(archived); rewritten != nil {
+		body = rewritten.ReplaceAll(body, []byte("$1"))
+	}
+	return body
+}
+
+// Get a pattern matching links rewritten to point into the archive which
+// served a capture, whether as absolute, scheme-relative or path-only URLs.
+// The first group is the original URL's scheme.
+func rewrittenURLs(archived *url.URL) *regexp.Regexp {
+	if archived == nil {
+		return nil
+	}
+	match := archivedURL.FindStringSubmatch(archived.String())
+	if match == nil {
+		return nil
+	}
+	prefix, err := url.Parse(match[1])
+	if err != nil || prefix.Host == "" {
+		return nil
+	}
+	return regexp.MustCompile(`(?:(?:https?:)?//` + regexp.QuoteMeta(prefix.Host) + `)?` +
+		regexp.QuoteMeta(prefix.EscapedPath()) + `\d{1,14}(?:[a-z]{2}_)?/(https?:)`)
+}
+
+// Ask whether a Content-Type is for text, such as HTML, CSS or JavaScript,
+// in which an archive may have rewritten links
+func isText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == ""
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "javascript") ||
+		strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml")
+}
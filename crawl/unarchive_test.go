@@ -0,0 +1,129 @@
+package crawl
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A page as the Wayback Machine serves it, with its scripts, toolbar and
+// comments injected and its links rewritten
+const WAYBACK_PAGE = `<html><head><script type="text/javascript" src="/_static/js/bundle-playback.js?v=1" charset="utf-8"></script>
+<script type="text/javascript" src="/_static/js/wombat.js?v=1" charset="utf-8"></script>
+<script type="text/javascript">
+  __wm.init("https://web.archive.org/web");
+</script>
+<link rel="stylesheet" type="text/css" href="/_static/css/banner-styles.css?v=1" />
+<!-- End Wayback Rewrite JS Include -->
+<title>Docs</title>
+<link rel="stylesheet" href="/web/20140101000000cs_/http://example.com/docs/style.css">
+</head><body><!-- BEGIN WAYBACK TOOLBAR INSERT -->
+<div id="wm-ipp">Toolbar</div>
+<!-- END WAYBACK TOOLBAR INSERT -->
+<a href="https://web.archive.org/web/20140101000000/http://example.com/docs/a.html">A</a>
+<a href="//web.archive.org/web/20131201000000/https://example.com/docs/b.html">B</a>
+<img src="/web/20140101000000im_/http://example.com/docs/logo.png">
+<a href="/web/about.html">About</a>
+</body></html>
+<!--
+     FILE ARCHIVED ON 00:00:00 Jan 01, 2014 AND RETRIEVED FROM THE
+     INTERNET ARCHIVE ON 12:00:00 Jan 01, 2020.
+-->
+<!--
+    playback timings (ms):
+      captures_list: 0.5
+-->`
+
+// The same page as it was on the live site
+const LIVE_PAGE = `<html><head><title>Docs</title>
+<link rel="stylesheet" href="http://example.com/docs/style.css">
+</head><body><a href="http://example.com/docs/a.html">A</a>
+<a href="https://example.com/docs/b.html">B</a>
+<img src="http://example.com/docs/logo.png">
+<a href="/web/about.html">About</a>
+</body></html>
+`
+
+func TestUnarchive(t *testing.T) {
+	Convey("Given a page served by the Wayback Machine", t, func() {
+		archived, _ := url.Parse("https://web.archive.org/web/20140101000000/http://example.com/docs/")
+
+		Convey("Then the archive's markup and rewritten links are undone", func() {
+			cleaned := CleanArchived([]byte(WAYBACK_PAGE), archived, "text/html; charset=utf-8")
+			So(string(cleaned), ShouldEqual, LIVE_PAGE)
+		})
+
+		Convey("Then content which isn't text is left alone", func() {
+			cleaned := CleanArchived([]byte(WAYBACK_PAGE), archived, "image/png")
+			So(string(cleaned), ShouldEqual, WAYBACK_PAGE)
+		})
+
+		Convey("Then links in stylesheets are restored", func() {
+			css := `body { background: url(/web/20140101000000im_/http://example.com/docs/bg.png); }`
+			cleaned := CleanArchived([]byte(css), archived, "text/css")
+			So(string(cleaned), ShouldEqual, `body { background: url(http://example.com/docs/bg.png); }`)
+		})
+	})
+
+	Convey("Given a Memento archive which rewrites the links in its pages", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		archive := newFakeMemento(map[string]map[string]string{
+			"http://example.com/docs/": {
+				"20140101000000": `<!-- WB Insert --><script src="/static/wb.js"></script><!-- End WB Insert -->` +
+					`<a href="/m/20140101000000/http://example.com/docs/a.html">A</a> ` +
+					`<a href="/m/20140101000000/http://example.com/other/b.html">B</a>`,
+			},
+			"http://example.com/docs/a.html": {
+				"20140101000000": "page a",
+			},
+			"http://example.com/other/b.html": {
+				"20140101000000": "page b",
+			},
+		})
+		Reset(func() {
+			archive.Close()
+		})
+		seed, _ := url.Parse("http://example.com/docs/")
+		crawler := Crawler{
+			Seeds:        []Seed{{URL: seed}},
+			FolderScope:  true,
+			Folder:       folder,
+			MaxDepth:     2,
+			Source:       SourceWayback,
+			WaybackAfter: time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC),
+			Archives:     []MementoArchive{archive.archive("partner")},
+		}
+
+		Convey("When I crawl the site from the archive", func() {
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then pages are saved as they were on the live site", func() {
+				data, err := ioutil.ReadFile(filepath.Join(folder, "example.com", "docs", "index.html"))
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, `<a href="http://example.com/docs/a.html">A</a> `+
+					`<a href="http://example.com/other/b.html">B</a>`)
+			})
+
+			Convey("Then the original URLs are crawled, within the seed's scope", func() {
+				data, err := ioutil.ReadFile(filepath.Join(folder, "example.com", "docs", "a.html"))
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, "page a")
+				_, err = os.Stat(filepath.Join(folder, "example.com", "other", "b.html"))
+				So(os.IsNotExist(err), ShouldBeTrue)
+				for _, path := range archive.requested() {
+					So(strings.Contains(path, "/m/20140101000000/http://example.com/other/"), ShouldBeFalse)
+				}
+			})
+		})
+	})
+}
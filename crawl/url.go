@@ -2,6 +2,9 @@ package crawl
 
 import (
 	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
 )
 
 // Convert a URL into a normalized, canonical form to avoid collision
@@ -9,3 +12,15 @@ func CanonicalURL(src *url.URL) *url.URL {
 	// TODO: implement CanonicalURL
 	return src
 }
+
+// Get the path, relative to the crawl folder, at which to save a URL
+func LocalPath(site *url.URL) string {
+	name := site.Path
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	if site.RawQuery != "" {
+		name += "?" + site.RawQuery
+	}
+	return filepath.Join(site.Host, filepath.FromSlash(path.Clean("/"+name)))
+}
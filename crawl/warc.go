@@ -0,0 +1,244 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Serves the responses recorded in a WARC file, which may be compressed with
+// gzip. URLs without a response or resource record get 404 Not Found. When a
+// URL was captured more than once, the last capture is served.
+type WARCFetcher struct {
+	file       *os.File
+	compressed bool
+	index      map[string]warcLocation
+}
+
+// Where a record is in a WARC file
+type warcLocation struct {
+
+	// The offset of the gzip member holding the record, or 0 for an
+	// uncompressed file
+	member int64
+
+	// The offset of the record from the start of the member or file
+	offset int64
+}
+
+// A WARC record's headers, with the position of its content block
+type warcRecord struct {
+	header http.Header
+	start  int64
+	length int64
+}
+
+// Open a WARC file, and index its records by URL
+func OpenWARCFetcher(path string) (*WARCFetcher, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fetcher := &WARCFetcher{
+		file:       file,
+		compressed: strings.HasSuffix(strings.ToLower(path), ".gz"),
+		index:      make(map[string]warcLocation),
+	}
+	if fetcher.compressed {
+		err = fetcher.indexMembers()
+	} else {
+		err = fetcher.indexRecords(newCountingReader(file), 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Invalid WARC file %s - %v", path, err)
+	}
+	return fetcher, nil
+}
+
+// Index the records in each gzip member of a compressed file
+func (fetcher *WARCFetcher) indexMembers() error {
+	outer := newCountingReader(fetcher.file)
+	var member int64
+	gz, err := gzip.NewReader(outer)
+	for err == nil {
+		gz.Multistream(false)
+		if err = fetcher.indexRecords(newCountingReader(gz), member); err != nil {
+			return err
+		}
+		member = outer.n
+		err = gz.Reset(outer)
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// Index the records in an uncompressed stream, which starts at the given
+// gzip member
+func (fetcher *WARCFetcher) indexRecords(r *countingReader, member int64) error {
+	for {
+		offset := r.n
+		record, err := readWARCRecord(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		offset += record.start
+		if _, err := io.CopyN(ioutil.Discard, r, record.length); err != nil {
+			return err
+		}
+		switch record.header.Get("WARC-Type") {
+		case "response", "resource":
+			uri := strings.Trim(record.header.Get("WARC-Target-URI"), "<>")
+			if site, err := url.Parse(uri); err == nil {
+				fetcher.index[site.String()] = warcLocation{member, offset}
+			}
+		}
+	}
+}
+
+// Serve a recorded response
+func (fetcher *WARCFetcher) Do(req *http.Request) (*http.Response, error) {
+	loc, ok := fetcher.index[req.URL.String()]
+	if !ok {
+		return newResponse(req, http.StatusNotFound, nil, nil), nil
+	}
+
+	// Find the record
+	var r io.Reader = io.NewSectionReader(fetcher.file, loc.member, 1<<62)
+	if fetcher.compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		gz.Multistream(false)
+		r = gz
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, loc.offset); err != nil {
+		return nil, err
+	}
+	cr := newCountingReader(r)
+	record, err := readWARCRecord(cr)
+	if err != nil {
+		return nil, err
+	}
+	block := make([]byte, record.length)
+	if _, err := io.ReadFull(cr, block); err != nil {
+		return nil, err
+	}
+
+	// A resource record holds the page itself, and a response record holds
+	// the whole HTTP response
+	if record.header.Get("WARC-Type") == "resource" {
+		header := make(http.Header)
+		if contentType := record.header.Get("Content-Type"); contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		return newResponse(req, http.StatusOK, header, block), nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// Close the WARC file
+func (fetcher *WARCFetcher) Close() error {
+	return fetcher.file.Close()
+}
+
+// Read a WARC record's headers, leaving the reader at the start of its content
+// block. Returns io.EOF if there are no more records.
+func readWARCRecord(r *countingReader) (record warcRecord, err error) {
+	// Skip the blank lines which end the previous record
+	var (
+		line  string
+		start = r.n
+		pos   int64
+	)
+	for line == "" {
+		pos = r.n
+		if line, err = r.readLine(); err == io.EOF && line == "" {
+			return record, io.EOF
+		} else if err != nil {
+			return record, err
+		}
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return record, fmt.Errorf("Expected a WARC record at offset %d, found %q", pos, line)
+	}
+	record.start = pos - start
+
+	// Read the headers
+	record.header = make(http.Header)
+	for {
+		if line, err = r.readLine(); err != nil {
+			return record, err
+		} else if line == "" {
+			break
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return record, fmt.Errorf("Invalid WARC header %q", line)
+		}
+		record.header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	if record.length, err = strconv.ParseInt(record.header.Get("Content-Length"), 10, 64); err != nil {
+		return record, fmt.Errorf("Invalid WARC record length %q", record.header.Get("Content-Length"))
+	}
+	return record, nil
+}
+
+// A buffered reader which counts the bytes read from it. Because it is an
+// io.ByteReader, gzip reads from it without buffering ahead, so the count
+// gives the offset of the next gzip member.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// Create a counting reader
+func newCountingReader(r io.Reader) *countingReader {
+	return &countingReader{r: bufio.NewReader(r)}
+}
+
+// Read bytes
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// Read a single byte
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+// Read a line, without its line ending
+func (cr *countingReader) readLine() (string, error) {
+	line, err := cr.r.ReadString('\n')
+	cr.n += int64(len(line))
+	return strings.TrimRight(line, "\r\n"), err
+}
@@ -0,0 +1,380 @@
+package crawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Internet Wayback Machine's archive, to which a capture's timestamp and
+// URL are appended to fetch it
+const DefaultWaybackArchive = "https://web.archive.org/web/"
+
+// The headers of a request for a page which are also sent to an archive.
+// The others, such as credentials, cookies and the headers configured for
+// the page's host, are meant for the page's host alone.
+var archiveHeaders = []string{"User-Agent", "Accept", "Accept-Encoding"}
+
+// The format of the timestamps used by web archives
+const waybackTimestamp = "20060102150405"
+
+// How to choose which capture of each page to crawl
+type CaptureStrategy int
+
+const (
+	// The latest capture in the date range
+	CaptureLatest CaptureStrategy = iota
+
+	// The earliest capture in the date range
+	CaptureEarliest
+
+	// The capture closest to a target time
+	CaptureClosest
+
+	// The capture closest to that of the page which first linked to it, so
+	// the crawl is a consistent snapshot of the site. The seeds use the
+	// capture closest to the target time, if any, or else the latest.
+	CaptureCoherent
+)
+
+// Parse a capture strategy name, as produced by CaptureStrategy.String()
+func ParseCaptureStrategy(name string) (CaptureStrategy, error) {
+	for _, strategy := range []CaptureStrategy{CaptureLatest, CaptureEarliest,
+		CaptureClosest, CaptureCoherent} {
+		if strategy.String() == name {
+			return strategy, nil
+		}
+	}
+	return CaptureLatest, fmt.Errorf("Unknown capture strategy %q", name)
+}
+
+// Get the name of a capture strategy
+func (strategy CaptureStrategy) String() string {
+	switch strategy {
+	case CaptureEarliest:
+		return "earliest"
+	case CaptureClosest:
+		return "closest"
+	case CaptureCoherent:
+		return "coherent"
+	default:
+		return "latest"
+	}
+}
+
+// Fetches pages from a web archive instead of the live site. The capture of
+// each page is chosen by the Strategy from those within the date range,
+// which are listed by the CDX server unless they were added with
+// AddCapture, or by the TimeMaps of the Memento Archives. Each response's
+// Memento-Datetime header gives the time of the capture served.
+type WaybackFetcher struct {
+
+	// The archive's URL prefix, to which a capture's timestamp and URL are
+	// appended (default DefaultWaybackArchive)
+	Archive string
+
+	// The CDX server which lists the captures of a page (default
+	// DefaultCDXEndpoint)
+	CDX string
+
+	// Memento archives to fetch from instead of Archive, in order of
+	// preference. Each is asked in turn until one has a capture of the page
+	// in the date range.
+	Archives []MementoArchive
+
+	// Fetch captures within this date range
+	Before, After time.Time
+
+	// How to choose among the captures in the date range
+	Strategy CaptureStrategy
+
+	// The target time for CaptureClosest and CaptureCoherent
+	At time.Time
+
+	// Sends the requests to the archive (default http.DefaultClient)
+	Fetcher Fetcher
+
+	mu sync.Mutex
+
+	// The captures of each page, keyed by URL
+	captures map[string][]time.Time
+
+	// The capture fetched for each page, for CaptureCoherent
+	fetched map[string]time.Time
+
+	// The capture of the first page to link to each page, for CaptureCoherent
+	referrers map[string]time.Time
+}
+
+// Add a capture of a page to those the fetcher chooses from, so the CDX
+// server isn't asked for the page's captures
+func (fetcher *WaybackFetcher) AddCapture(capture Capture) {
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	if fetcher.captures == nil {
+		fetcher.captures = make(map[string][]time.Time)
+	}
+	key := capture.URL.String()
+	fetcher.captures[key] = append(fetcher.captures[key], capture.Timestamp)
+}
+
+// Record that one page links to another, so that with CaptureCoherent the
+// capture of the target closest to that of the source is fetched
+func (fetcher *WaybackFetcher) Linked(source, target *url.URL) {
+	if fetcher.Strategy != CaptureCoherent {
+		return
+	}
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	ts, ok := fetcher.fetched[source.String()]
+	if !ok {
+		return
+	}
+	if fetcher.referrers == nil {
+		fetcher.referrers = make(map[string]time.Time)
+	}
+	if _, seen := fetcher.referrers[target.String()]; !seen {
+		fetcher.referrers[target.String()] = ts
+	}
+}
+
+// Get the URL of a page's capture at a time, in the "id_" form which serves
+// the page as it was captured, without rewriting its links
+func (fetcher *WaybackFetcher) ArchiveURL(site *url.URL, ts time.Time) (*url.URL, error) {
+	return url.Parse(fetcher.archive() + ts.Format(waybackTimestamp) + "id_/" + site.String())
+}
+
+// Get the archive's URL prefix
+func (fetcher *WaybackFetcher) archive() string {
+	if fetcher.Archive == "" {
+		return DefaultWaybackArchive
+	}
+	return fetcher.Archive
+}
+
+// Send a request for a page to the archive, or to the Memento Archives, with
+// only its archiveHeaders. A page with no capture in the date range is not found.
+func (fetcher *WaybackFetcher) Do(req *http.Request) (*http.Response, error) {
+	if len(fetcher.Archives) > 0 {
+		return fetcher.doMemento(req)
+	}
+	ts, ok, err := fetcher.choose(req)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return newResponse(req, http.StatusNotFound, nil, nil), nil
+	}
+	archived, err := fetcher.ArchiveURL(req.URL, ts)
+	if err != nil {
+		return nil, err
+	}
+	areq, err := archiveRequest(req, archived)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fetcher.client().Do(areq)
+	if err != nil {
+		return nil, err
+	}
+
+	// Note which capture the archive served: it may redirect to the capture
+	// nearest the one requested
+	captured := capturedAt(resp)
+	if captured.IsZero() {
+		captured = ts
+		if resp.Request != nil {
+			if served, ok := fetcher.timestampOf(resp.Request.URL); ok {
+				captured = served
+			}
+		}
+		resp.Header.Set("Memento-Datetime", captured.Format(http.TimeFormat))
+	}
+	fetcher.noteFetched(req.URL, captured)
+	return resp, nil
+}
+
+// Create a request to an archive for a page, with the same method and
+// context as the request for the page but only its archiveHeaders
+func archiveRequest(req *http.Request, archived *url.URL) (*http.Request, error) {
+	areq, err := http.NewRequestWithContext(req.Context(), req.Method, archived.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range archiveHeaders {
+		if values, ok := req.Header[name]; ok {
+			areq.Header[name] = append([]string(nil), values...)
+		}
+	}
+	return areq, nil
+}
+
+// Record the capture fetched for a page, for CaptureCoherent
+func (fetcher *WaybackFetcher) noteFetched(site *url.URL, captured time.Time) {
+	if fetcher.Strategy != CaptureCoherent {
+		return
+	}
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	if fetcher.fetched == nil {
+		fetcher.fetched = make(map[string]time.Time)
+	}
+	fetcher.fetched[site.String()] = captured
+}
+
+// Get the fetcher which sends requests to the archive
+func (fetcher *WaybackFetcher) client() Fetcher {
+	if fetcher.Fetcher != nil {
+		return fetcher.Fetcher
+	}
+	return http.DefaultClient
+}
+
+// Get the timestamp in the URL of a capture
+func (fetcher *WaybackFetcher) timestampOf(archived *url.URL) (time.Time, bool) {
+	rest := strings.TrimPrefix(archived.String(), fetcher.archive())
+	if i := strings.Index(rest, "id_/"); i == len(waybackTimestamp) {
+		if ts, err := time.Parse(waybackTimestamp, rest[:i]); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Choose the time of the capture to fetch for a page, or return false if it
+// has no capture in the date range. Without a date range, the archive is
+// left to serve the capture closest to the target time.
+func (fetcher *WaybackFetcher) choose(req *http.Request) (time.Time, bool, error) {
+	target := fetcher.target(req.URL)
+	if fetcher.Before.IsZero() && fetcher.After.IsZero() && fetcher.Strategy != CaptureEarliest {
+		return target, true, nil
+	}
+	captures, err := fetcher.lookup(req)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	chosen := fetcher.pick(captures, target)
+	if chosen < 0 {
+		return time.Time{}, false, nil
+	}
+	return captures[chosen], true, nil
+}
+
+// Get the index of the capture the Strategy prefers among those in the date
+// range, or -1 if none are
+func (fetcher *WaybackFetcher) pick(captures []time.Time, target time.Time) int {
+	chosen := -1
+	for i, ts := range captures {
+		if !fetcher.inRange(ts) {
+			continue
+		}
+		var better bool
+		switch {
+		case chosen < 0:
+			better = true
+		case fetcher.Strategy == CaptureLatest:
+			better = ts.After(captures[chosen])
+		case fetcher.Strategy == CaptureEarliest:
+			better = ts.Before(captures[chosen])
+		default:
+			better = absDuration(ts.Sub(target)) < absDuration(captures[chosen].Sub(target))
+		}
+		if better {
+			chosen = i
+		}
+	}
+	return chosen
+}
+
+// Ask whether a capture is within the date range
+func (fetcher *WaybackFetcher) inRange(ts time.Time) bool {
+	return (fetcher.After.IsZero() || !ts.Before(fetcher.After)) &&
+		(fetcher.Before.IsZero() || !ts.After(fetcher.Before))
+}
+
+// Get the time to which a page's chosen capture should be closest
+func (fetcher *WaybackFetcher) target(site *url.URL) time.Time {
+	if fetcher.Strategy == CaptureCoherent {
+		fetcher.mu.Lock()
+		ts, ok := fetcher.referrers[site.String()]
+		fetcher.mu.Unlock()
+		if ok {
+			return ts
+		}
+	}
+	switch {
+	case fetcher.Strategy == CaptureEarliest:
+		return fetcher.After
+	case fetcher.Strategy != CaptureLatest && !fetcher.At.IsZero():
+		return fetcher.At
+	case !fetcher.Before.IsZero():
+		return fetcher.Before
+	default:
+		return time.Now().UTC()
+	}
+}
+
+// Get the captures of a page, asking the CDX server for them if they
+// aren't already known
+func (fetcher *WaybackFetcher) lookup(req *http.Request) ([]time.Time, error) {
+	key := req.URL.String()
+	fetcher.mu.Lock()
+	captures, ok := fetcher.captures[key]
+	fetcher.mu.Unlock()
+	if ok {
+		return captures, nil
+	}
+
+	query := cdxQuery(key, "exact", fetcher.After, fetcher.Before)
+	listed, err := queryCDX(fetcher.client(), fetcher.CDX, query, req.Header.Get("User-Agent"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not list the captures of %s - %v", key, err)
+	}
+	captures = []time.Time{}
+	for _, capture := range listed {
+		if capture.Status < 400 {
+			captures = append(captures, capture.Timestamp)
+		}
+	}
+	fetcher.mu.Lock()
+	if fetcher.captures == nil {
+		fetcher.captures = make(map[string][]time.Time)
+	}
+	fetcher.captures[key] = captures
+	fetcher.mu.Unlock()
+	return captures, nil
+}
+
+// Get the time a response was captured by an archive, from its
+// Memento-Datetime header, or the zero time for a live response
+func capturedAt(resp *http.Response) time.Time {
+	ts, err := http.ParseTime(resp.Header.Get("Memento-Datetime"))
+	if err != nil {
+		return time.Time{}
+	}
+	return ts.UTC()
+}
+
+// Get the absolute value of a duration
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Set up the fetcher for a crawl from the Wayback Machine
+func (crawler *Crawler) newWaybackFetcher() *WaybackFetcher {
+	return &WaybackFetcher{
+		Archive:  crawler.WaybackArchive,
+		CDX:      crawler.WaybackCDX,
+		Archives: crawler.Archives,
+		Before:   crawler.WaybackBefore,
+		After:    crawler.WaybackAfter,
+		Strategy: crawler.WaybackStrategy,
+		At:       crawler.WaybackAt,
+		Fetcher:  crawler.liveFetcher(),
+	}
+}
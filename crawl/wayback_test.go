@@ -0,0 +1,351 @@
+package crawl
+
+import (
+	"bufio"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake web archive, with a CDX server at /cdx and captures under /web/.
+// Like the Wayback Machine, a request for a time without a capture is
+// redirected to the closest capture.
+type fakeArchive struct {
+	*httptest.Server
+
+	// The rows listed by the CDX server, after the row of field names
+	rows [][]string
+
+	// The captured pages, keyed by original URL, or by timestamp and URL for
+	// a single capture
+	pages map[string]string
+
+	mu       sync.Mutex
+	queries  []url.Values
+	requests []string
+	headers  []http.Header
+}
+
+// The fields of the fake archive's CDX rows
+var CDX_FIELDS = []string{"original", "timestamp", "statuscode", "mimetype", "digest"}
+
+func newFakeArchive(rows [][]string, pages map[string]string) *fakeArchive {
+	archive := &fakeArchive{rows: rows, pages: pages}
+	archive.Server = httptest.NewServer(http.HandlerFunc(archive.serve))
+	return archive
+}
+
+// Get a URL as the CDX server matches it, without its scheme or default port
+func cdxKey(site string) string {
+	site = strings.TrimPrefix(strings.TrimPrefix(site, "http://"), "https://")
+	return strings.Replace(site, ":80/", "/", 1)
+}
+
+// List the captures matching a CDX query
+func (archive *fakeArchive) list(query url.Values) [][]string {
+	rows := [][]string{CDX_FIELDS}
+	for _, row := range archive.rows {
+		key, target := cdxKey(row[0]), cdxKey(query.Get("url"))
+		if key == target || (query.Get("matchType") == "prefix" && strings.HasPrefix(key, target)) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// Serve the CDX server and the captures
+func (archive *fakeArchive) serve(w http.ResponseWriter, r *http.Request) {
+	archive.mu.Lock()
+	defer archive.mu.Unlock()
+	if r.URL.Path == "/cdx" {
+		archive.queries = append(archive.queries, r.URL.Query())
+		json.NewEncoder(w).Encode(archive.list(r.URL.Query()))
+		return
+	}
+	archive.requests = append(archive.requests, r.URL.Path)
+	archive.headers = append(archive.headers, r.Header)
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/web/"), "id_/", 2)
+	want, err := time.Parse(waybackTimestamp, parts[0])
+	if len(parts) != 2 || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var closest string
+	for _, row := range archive.list(url.Values{"url": {parts[1]}})[1:] {
+		ts, _ := time.Parse(waybackTimestamp, row[1])
+		best, _ := time.Parse(waybackTimestamp, closest)
+		if closest == "" || absDuration(ts.Sub(want)) < absDuration(best.Sub(want)) {
+			closest = row[1]
+		}
+	}
+	if closest == "" {
+		http.NotFound(w, r)
+	} else if closest != parts[0] {
+		w.Header().Set("Location", "/web/"+closest+"id_/"+parts[1])
+		w.WriteHeader(http.StatusFound)
+	} else if page, ok := archive.pages[closest+" "+parts[1]]; ok {
+		w.Write([]byte(page))
+	} else {
+		w.Write([]byte(archive.pages[parts[1]]))
+	}
+}
+
+// Parse a date in an archive's timestamp format
+func archiveTime(ts string) time.Time {
+	t, _ := time.Parse(waybackTimestamp, ts)
+	return t
+}
+
+func TestWayback(t *testing.T) {
+	Convey("Given an archived site with a page no other page links to", t, func() {
+		folder, err := ioutil.TempDir("", "webcp")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(folder)
+		})
+		archive := newFakeArchive([][]string{
+			{"http://example.com/docs/", "20140101000000", "200", "text/html", "A"},
+			{"http://example.com/docs/linked.html", "20140101000000", "200", "text/html", "B"},
+			{"http://example.com:80/docs/orphan.html", "20130101000000", "200", "text/html", "C"},
+			{"http://example.com/docs/orphan.html", "20140301000000", "200", "text/html", "D"},
+			{"http://example.com/other/page.html", "20140101000000", "200", "text/html", "E"},
+		}, map[string]string{
+			"http://example.com/docs/":            `<a href="linked.html">Linked</a>`,
+			"http://example.com/docs/linked.html": "linked",
+			"http://example.com/docs/orphan.html": "orphan",
+		})
+		Reset(func() {
+			archive.Close()
+		})
+		seed, _ := url.Parse("http://example.com/docs/")
+		crawler := Crawler{
+			Seeds:            []Seed{{URL: seed}},
+			FolderScope:      true,
+			Folder:           folder,
+			Manifest:         filepath.Join(folder, "manifest.jsonl"),
+			MaxDepth:         2,
+			Source:           SourceWayback,
+			WaybackAfter:     time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC),
+			WaybackBefore:    time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+			WaybackArchive:   archive.URL + "/web/",
+			WaybackCDX:       archive.URL + "/cdx",
+			WaybackEnumerate: true,
+			WaybackFilter: CDXFilter{
+				Status:         "200",
+				MIMEType:       "text/html",
+				CollapseDigest: true,
+			},
+		}
+
+		Convey("When I crawl it within rate limits", func() {
+			crawler.FetchDelay = time.Millisecond
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then the limits apply to the archive, not the site", func() {
+				archiveURL, _ := url.Parse(archive.URL)
+				status := crawler.limiter.Status()
+				So(status, ShouldContainKey, archiveURL.Host)
+				So(status, ShouldNotContainKey, "example.com")
+			})
+		})
+
+		Convey("When I crawl it with credentials and headers for the site", func() {
+			crawler.UserAgent = "webcp-test"
+			crawler.Credentials = map[string]Credential{"example.com": {Token: "site-token"}}
+			crawler.HostHeaders = map[string]http.Header{"example.com": {"X-Api-Key": {"site-key"}}}
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then they never reach the archive", func() {
+				So(archive.headers, ShouldNotBeEmpty)
+				for _, header := range archive.headers {
+					So(header.Get("X-Api-Key"), ShouldEqual, "")
+					So(header.Get("Authorization"), ShouldEqual, "")
+					So(header.Get("User-Agent"), ShouldEqual, "webcp-test")
+				}
+			})
+		})
+
+		Convey("When I crawl it from the archive", func() {
+			stats, err := crawler.Run()
+			So(err, ShouldBeNil)
+			So(stats, ShouldNotBeNil)
+
+			Convey("Then I ask the CDX server for the seed's scope and date range", func() {
+				So(len(archive.queries), ShouldBeGreaterThan, 0)
+				query := archive.queries[0]
+				So(query.Get("url"), ShouldEqual, "example.com/docs/")
+				So(query.Get("matchType"), ShouldEqual, "prefix")
+				So(query.Get("from"), ShouldEqual, "20130101000000")
+				So(query.Get("to"), ShouldEqual, "20150101000000")
+				So(query["filter"], ShouldResemble, []string{"statuscode:200", "mimetype:text/html"})
+				So(query.Get("collapse"), ShouldEqual, "digest")
+			})
+
+			Convey("Then the enumerated and linked pages are saved", func() {
+				for name, content := range map[string]string{
+					"linked.html": "linked",
+					"orphan.html": "orphan",
+				} {
+					data, err := ioutil.ReadFile(filepath.Join(folder, "example.com", "docs", name))
+					So(err, ShouldBeNil)
+					So(string(data), ShouldEqual, content)
+				}
+			})
+
+			Convey("Then pages outside the seeds' scope are not queued", func() {
+				_, err := os.Stat(filepath.Join(folder, "example.com", "other"))
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+
+			Convey("Then each page is fetched from its latest capture", func() {
+				So(archive.requests, ShouldContain,
+					"/web/20140301000000id_/http://example.com/docs/orphan.html")
+				So(archive.requests, ShouldContain,
+					"/web/20140101000000id_/http://example.com/docs/linked.html")
+			})
+
+			Convey("Then each saved page records when it was captured", func() {
+				info, err := os.Stat(filepath.Join(folder, "example.com", "docs", "orphan.html"))
+				So(err, ShouldBeNil)
+				So(info.ModTime().UTC(), ShouldResemble, archiveTime("20140301000000"))
+
+				file, err := os.Open(crawler.Manifest)
+				So(err, ShouldBeNil)
+				defer file.Close()
+				entries := make(map[string]ManifestEntry)
+				scanner := bufio.NewScanner(file)
+				for scanner.Scan() {
+					var entry ManifestEntry
+					So(json.Unmarshal(scanner.Bytes(), &entry), ShouldBeNil)
+					entries[entry.URL] = entry
+				}
+				So(entries["http://example.com/docs/orphan.html"], ShouldResemble, ManifestEntry{
+					Path:     filepath.Join("example.com", "docs", "orphan.html"),
+					URL:      "http://example.com/docs/orphan.html",
+					Source:   SourceNameWayback,
+					Captured: "20140301000000",
+				})
+			})
+		})
+	})
+
+	Convey("Given a page captured several times", t, func() {
+		archive := newFakeArchive([][]string{
+			{"http://example.com/", "20100101000000", "200", "text/html", "A"},
+			{"http://example.com/", "20120101000000", "200", "text/html", "B"},
+			{"http://example.com/", "20140101000000", "200", "text/html", "C"},
+			{"http://example.com/", "20150101000000", "404", "text/html", "D"},
+		}, map[string]string{"http://example.com/": "home"})
+		Reset(func() {
+			archive.Close()
+		})
+		site, _ := url.Parse("http://example.com/")
+		fetcher := &WaybackFetcher{
+			Archive: archive.URL + "/web/",
+			CDX:     archive.URL + "/cdx",
+			After:   time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC),
+			Before:  time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		captured := func() (int, string) {
+			req, err := http.NewRequest("GET", site.String(), nil)
+			So(err, ShouldBeNil)
+			resp, err := fetcher.Do(req)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			if ts := capturedAt(resp); !ts.IsZero() {
+				return resp.StatusCode, ts.Format(waybackTimestamp)
+			}
+			return resp.StatusCode, ""
+		}
+
+		Convey("Then the latest capture in the range is fetched by default", func() {
+			status, ts := captured()
+			So(status, ShouldEqual, http.StatusOK)
+			So(ts, ShouldEqual, "20140101000000")
+		})
+
+		Convey("Then the earliest capture in the range can be fetched", func() {
+			fetcher.Strategy = CaptureEarliest
+			_, ts := captured()
+			So(ts, ShouldEqual, "20120101000000")
+		})
+
+		Convey("Then the capture closest to a time can be fetched", func() {
+			fetcher.Strategy = CaptureClosest
+			fetcher.At = time.Date(2013, 3, 1, 0, 0, 0, 0, time.UTC)
+			_, ts := captured()
+			So(ts, ShouldEqual, "20140101000000")
+			fetcher.At = time.Date(2012, 3, 1, 0, 0, 0, 0, time.UTC)
+			fetcher.captures = nil
+			_, ts = captured()
+			So(ts, ShouldEqual, "20120101000000")
+		})
+
+		Convey("Then without a date range the archive picks the closest capture", func() {
+			fetcher.After, fetcher.Before = time.Time{}, time.Time{}
+			fetcher.Strategy = CaptureClosest
+			fetcher.At = time.Date(2010, 6, 1, 0, 0, 0, 0, time.UTC)
+			status, ts := captured()
+			So(status, ShouldEqual, http.StatusOK)
+			So(ts, ShouldEqual, "20100101000000")
+			So(len(archive.queries), ShouldEqual, 0)
+		})
+
+		Convey("Then a page with no capture in the range is not found", func() {
+			fetcher.After = time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+			status, _ := captured()
+			So(status, ShouldEqual, http.StatusNotFound)
+			So(archive.requests, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given an archived site whose pages were captured at different times", t, func() {
+		archive := newFakeArchive([][]string{
+			{"http://example.com/", "20120101000000", "200", "text/html", "A"},
+			{"http://example.com/", "20140101000000", "200", "text/html", "B"},
+			{"http://example.com/page.html", "20111201000000", "200", "text/html", "C"},
+			{"http://example.com/page.html", "20140201000000", "200", "text/html", "D"},
+		}, map[string]string{
+			"http://example.com/":          `<a href="page.html">Page</a>`,
+			"http://example.com/page.html": "page",
+		})
+		Reset(func() {
+			archive.Close()
+		})
+		seed, _ := url.Parse("http://example.com/")
+		crawler := Crawler{
+			Seeds:           []Seed{{URL: seed}},
+			MaxDepth:        2,
+			Source:          SourceWayback,
+			WaybackAfter:    time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC),
+			WaybackBefore:   time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+			WaybackStrategy: CaptureCoherent,
+			WaybackAt:       time.Date(2012, 2, 1, 0, 0, 0, 0, time.UTC),
+			WaybackArchive:  archive.URL + "/web/",
+			WaybackCDX:      archive.URL + "/cdx",
+		}
+
+		Convey("When I crawl a coherent snapshot", func() {
+			_, err := crawler.Run()
+			So(err, ShouldBeNil)
+
+			Convey("Then each page's capture is closest to that of the page linking to it", func() {
+				So(archive.requests, ShouldResemble, []string{
+					"/web/20120101000000id_/http://example.com/",
+					"/web/20111201000000id_/http://example.com/page.html",
+				})
+			})
+		})
+	})
+}
@@ -1,129 +1,266 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"github.com/docopt/docopt-go"
 	"github.com/jesand/webcp/crawl"
-	"net/url"
+	"io"
+	"io/ioutil"
 	"os"
-	"strconv"
-	"time"
 )
 
 const (
 	SW         = "webcp"
 	VERSION    = "0.1.0"
 	SW_VERSION = SW + " (v. " + VERSION + ")"
+	USER_AGENT = SW_VERSION + " (+https://github.com/jesand/webcp)"
 	USAGE      = SW_VERSION + ` - Smart site crawling
 
 Usage:
-  ` + SW + ` [-hw] <url> <dest> [--delay=<secs>] [--max-depth=<num>]
-    [--resume=<path>] [--wayback-after=<date>] [--wayback-before=<date>]
+  ` + SW + ` config dump [options] [--header=<hdr>]... [--quota=<spec>]... [<url>...]
+  ` + SW + ` check [options] [--header=<hdr>]... [--quota=<spec>]... [<url>...]
+  ` + SW + ` [options] [--header=<hdr>]... [--quota=<spec>]... [<url>...]
+
+The arguments are any number of seed URLs followed by the destination folder:
+
+  ` + SW + ` [options] <url>... <dest>
+
+The check command crawls the pages in the seeds' scope without saving them,
+checks the links which leave the scope without crawling them, and reports the
+broken links. It exits with status 1 if any links are broken:
+
+  ` + SW + ` check [options] <url>...
+
+The config dump command prints the settings that a crawl with the same options
+would use, in the format of the --config file. Secrets are left out, including
+Authorization, Cookie and Proxy-Authorization headers.
 
 All dates are in YYYY, YYYYMM, YYYYMMDD, or YYYYMMDDHHMMSS format.
 
 Options:
-  <url>                    The seed URL from which crawling should begin.
+  <url>                    A seed URL from which crawling should begin. Only
+                           pages on the seeds' hosts are crawled.
   <dest>                   The folder to which the crawl should be saved.
-  --delay=<secs>           Time to wait between requests to a single domain [default: 5].
+  --bandwidth=<bytes>      Maximum bytes per second to download across all
+                           hosts, or 0 for no limit (default 0).
+  --ca-cert=<path>         Also trust the PEM certificate authorities in a file.
+  --client-cert=<path>     Present the PEM certificate in a file to servers
+                           which require a client certificate.
+  --client-key=<path>      The PEM private key for --client-cert.
+  --config=<path>          Load settings from a TOML or YAML file. Other
+                           options override the settings in the file.
+  --connect-timeout=<secs>
+                           Time allowed to connect to a server (default 30, or
+                           0 for no limit).
+  --control-addr=<addr>    Serve the crawl's status, and controls to pause,
+                           resume, stop, add seeds and change limits, over
+                           HTTP at this address, such as 127.0.0.1:7070.
+  --cookies=<path>         Send the cookies in a Netscape cookies.txt file, such
+                           as one exported from a browser, to start the crawl
+                           logged in.
+  --dedup=<mode>           Save pages whose content was already saved as a
+                           link to the first copy: none, hardlink, or
+                           symlink (default none).
+  --dedup-skip-parse       Don't look for links in pages with duplicate content.
+  --delay=<secs>           Time to wait between requests to a single domain
+                           (default 5).
+  --folder-scope           Only crawl the pages in the folders containing the
+                           seeds, rather than their whole hosts.
+  --graph=<path>           Write the links found during the crawl to this file
+                           when it finishes.
+  --graph-format=<fmt>     The format of the --graph file: csv, dot, or
+                           graphml (default from the file extension, or csv).
+  --header-timeout=<secs>  Time allowed from sending a request to receiving the
+                           response headers (default 60, or 0 for no limit).
+  --header=<hdr>           Send a header, written as "Name: value", with every
+                           request. May be repeated.
   -h --help                Show these usage notes.
-  --max-depth=<num>        Stop at this tree depth [default: 5].
+  --host-concurrency=<n>   Maximum simultaneous requests to a single host, or
+                           0 for no limit (default 1).
+  --host-limits=<path>     Load per-host limits from a file. Each line holds a
+                           host followed by any of rate=<requests/sec>,
+                           burst=<num>, and concurrency=<num>.
+  --insecure               Don't verify servers' TLS certificates.
+  --manifest=<path>        Add a line of JSON to this file for each page saved,
+                           giving its path, its URL and, for a page from the
+                           Wayback Machine, when it was archived.
+  --max-bytes=<bytes>      Stop the crawl after downloading this many bytes, or
+                           0 for no limit (default 0). The page being
+                           downloaded at the limit is cut off, and it and the
+                           pages left to crawl are kept with --resume.
+  --max-depth=<num>        Stop at this tree depth (default 5). A page's depth
+                           is counted from the seed it was first found from.
+  --max-hops=<num>         Also fetch pages up to this many links outside the
+                           seeds' scopes (default 0).
+  --max-pages=<num>        Stop the crawl after fetching this many pages, or 0
+                           for no limit (default 0). The pages left to crawl
+                           are kept with --resume.
+  --max-query-variants=<n>
+                           Skip URLs once this many query strings have been
+                           queued for their path, as with an endless calendar,
+                           or 0 for no limit (default 500).
+  --max-segment-repeats=<n>
+                           Skip URLs in which any path segment appears more
+                           than this many times, as in /a/b/a/b/a/b, or 0 for
+                           no limit (default 2).
+  --max-similar-pages=<n>  Don't follow the links in HTML pages nearly
+                           identical to this many pages already crawled from
+                           their host, such as pages which only differ by a
+                           session ID, or 0 for no limit (default 0).
+  --max-url-length=<n>     Skip URLs longer than this, or 0 for no limit
+                           (default 2048).
+  --memento=<urls>         With --wayback, crawl from these Memento TimeGates,
+                           such as pywb or OpenWayback collections, instead of
+                           the Wayback Machine. Separate the URLs with commas,
+                           in order of preference.
+  --metrics-file=<path>    Write the crawl's metrics in the Prometheus text
+                           format to this file every 15 seconds, for a
+                           textfile collector.
+  --min-rate=<bytes>       Abort downloads slower than this many bytes per
+                           second (default 100, or 0 for no limit).
+  --min-rate-window=<secs>
+                           The period over which --min-rate is measured
+                           (default 30).
+  --no-proxy=<hosts>       A comma-separated list of hosts, and their subdomains,
+                           to connect to directly instead of through the proxy.
+  --offline=<path>         Crawl the pages saved in a folder by an earlier
+                           crawl, or in a WARC file, instead of fetching them.
+                           Rate limits don't apply to offline crawls.
+  --proxy=<url>            Send requests through an HTTP, HTTPS or SOCKS5 proxy,
+                           such as socks5://127.0.0.1:1080. By default, the
+                           proxy in the environment is used, if any.
+  --quota=<spec>           Crawl at most a number of pages from part of the
+                           web, written as <match>=<pages>. The match is a
+                           host, a host and path prefix such as
+                           example.com/calendar/, a path prefix on any host
+                           such as /search/, or ~ followed by a regular
+                           expression matched against the URL. Pages over
+                           the quota are skipped. May be repeated.
+  --report=<path>          Write the check command's report to this file
+                           instead of standard output.
+  --report-format=<fmt>    The format of the check command's report: text,
+                           json, or junit (default text).
+  --requisites             Also fetch the images, stylesheets and scripts used
+                           by the crawled pages, whatever their depth.
   --resume=<path>          Save ongoing status, and resume any previous crawls.
+  --retries=<num>          Times to retry a request when the server asks us to
+                           slow down, waiting at least --delay (or a second)
+                           before the first retry and twice as long before
+                           each later one (default 2).
+  --seeds-file=<path>      Also crawl the seeds in a file, with one URL per
+                           line. A URL may be followed by the maximum depth to
+                           crawl from it.
+  --source=<order>         Where to fetch pages from: live, wayback, or both in
+                           order of preference, as live,wayback or wayback,live
+                           (default live, or wayback with --wayback).
+  --stats-json=<path>      Save the crawl statistics to a JSON file.
+  --timeout=<secs>         Time allowed for a whole request, including the
+                           download (default 0, for no limit).
+  --tls-timeout=<secs>     Time allowed for the TLS handshake (default 10, or
+                           0 for no limit).
+  --user-agent=<ua>        The User-Agent header to send (default
+                           "` + USER_AGENT + `").
   --version                Show the version number.
   -w --wayback             Crawl from the Internet Wayback Machine instead.
   --wayback-after=<date>   Crawl pages archived on or after this date.
+  --wayback-archive=<url>  The archive to crawl from with --wayback (default
+                           ` + crawl.DefaultWaybackArchive + `).
+  --wayback-at=<date>      Crawl the capture of each page closest to this date.
   --wayback-before=<date>  Crawl pages archived on or before this date.
+  --wayback-cdx=<url>      The CDX server which lists the archived pages to
+                           enumerate (default
+                           ` + crawl.DefaultCDXEndpoint + `).
+  --wayback-collapse       With --wayback-enumerate, skip captures with the same
+                           content as the previous capture of a page.
+  --wayback-enumerate      Also crawl every page archived in the seeds' scopes
+                           within the date range, even if no page links to it.
+  --wayback-mime=<regex>   With --wayback-enumerate, only crawl pages whose
+                           archived MIME type matches (default any).
+  --wayback-status=<regex>
+                           With --wayback-enumerate, only crawl pages whose
+                           archived HTTP status matches (default 200).
+  --wayback-strategy=<name>
+                           Which capture of each page in the date range to
+                           crawl: latest, earliest, closest to --wayback-at,
+                           or coherent, closest to the page that linked to it
+                           (default latest, or closest with --wayback-at).
+  --workers=<num>          Number of pages to fetch at once (default 1).
 `
 )
 
 func main() {
-	crawler, err := ParseArgs(nil)
+	args := parseUsage(nil)
+	config, err := LoadConfig(args)
+	var broken bool
+	if err == nil && args["dump"] == true {
+		path, _ := args["--config"].(string)
+		err = config.Dump(os.Stdout, configFormat(path))
+	} else if err == nil {
+		var (
+			crawler crawl.Crawler
+			stats   *crawl.Stats
+		)
+		if crawler, err = buildCrawler(config); err == nil {
+			stats, err = runCrawl(crawler, config)
+		}
+		if err == nil && config.Check {
+			err = writeCheckReport(stats, config)
+			broken = len(stats.BrokenLinks()) > 0
+		}
+	}
 	if err != nil {
 		os.Stderr.WriteString(err.Error() + "\n")
-	} else {
-		crawler.Run()
+		os.Exit(1)
+	} else if broken {
+		os.Exit(1)
 	}
 }
 
+// Parse the command line, and build the crawler it describes
 func ParseArgs(argv []string) (crawler crawl.Crawler, reterr error) {
-
-	// Parse the command line and print usage
-	args, _ := docopt.Parse(USAGE, argv, true, SW_VERSION, false)
-
-	// Get the command line arguments
-	var (
-		delay, _               = args["--delay"].(string)
-		delaySecs, delayErr    = strconv.ParseFloat(delay, 64)
-		folder, _              = args["<dest>"].(string)
-		depthStr, _            = args["--max-depth"].(string)
-		depth, depthErr        = strconv.Atoi(depthStr)
-		resume, _              = args["--resume"].(string)
-		urlRaw, urlOk          = args["<url>"].(string)
-		urlParsed, urlErr      = url.Parse(urlRaw)
-		wayback, _             = args["--wayback"].(bool)
-		wbAfter, _             = args["--wayback-after"].(string)
-		wbAfterDate, wbAftErr  = ParseDate(wbAfter)
-		wbBefore, _            = args["--wayback-before"].(string)
-		wbBeforeDate, wbBefErr = ParseDate(wbBefore)
-	)
-
-	// Validate the command line arguments
-	if delay != "" && (delayErr != nil || delaySecs < 0) {
-		reterr = fmt.Errorf("Invalid --delay %q - %v", delay, delayErr)
+	config, reterr := LoadConfig(parseUsage(argv))
+	if reterr != nil {
 		return
 	}
+	return buildCrawler(config)
+}
 
-	if depthStr != "" && (depthErr != nil || depth < 0) {
-		reterr = fmt.Errorf("Invalid --max-depth %q", depthStr)
-		return
-	}
+// Parse the command line and print usage
+func parseUsage(argv []string) map[string]interface{} {
+	args, _ := docopt.Parse(USAGE, argv, true, SW_VERSION, false)
+	return args
+}
 
-	if folder == "" {
-		reterr = fmt.Errorf("<dest> is required")
-		return
-	} else if reterr = os.MkdirAll(folder, 0777); reterr != nil {
+// Build a crawler from validated settings, and create its destination folder
+func buildCrawler(config Config) (crawler crawl.Crawler, reterr error) {
+	if crawler, reterr = config.Crawler(); reterr != nil || crawler.Folder == "" {
 		return
 	}
+	reterr = os.MkdirAll(crawler.Folder, 0777)
+	return
+}
 
-	if !urlOk || urlRaw == "" {
-		reterr = fmt.Errorf("<url> is required")
-		return
-	} else if urlErr != nil {
-		reterr = fmt.Errorf("Invalid URL %q - %v", urlRaw, urlErr)
-		return
-	} else if !urlParsed.IsAbs() {
-		reterr = fmt.Errorf("Can't fetch non-absolute URL %s", urlRaw)
-		return
+// Run a crawl, and report its statistics
+func runCrawl(crawler crawl.Crawler, config Config) (*crawl.Stats, error) {
+	if closer, ok := crawler.Fetcher.(io.Closer); ok {
+		defer closer.Close()
 	}
-
-	if wayback {
-		if wbBefore != "" && wbBefErr != nil {
-			reterr = fmt.Errorf("Invalid --wayback-before date %q", wbBefore)
-			return
-		}
-		if wbAfter != "" && wbAftErr != nil {
-			reterr = fmt.Errorf("Invalid --wayback-after date %q", wbAfter)
-			return
+	display := NewProgressDisplay(os.Stderr)
+	crawler.OnProgress = display.Show
+	crawler.ProgressInterval = display.Interval()
+	stats, err := crawler.Run()
+	if err != nil {
+		return nil, err
+	}
+	stats.WriteReport(os.Stderr)
+	if config.StatsJSON != "" {
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return nil, err
 		}
-		if wbBefore != "" && wbAfter != "" && !wbBeforeDate.After(wbAfterDate) {
-			reterr = fmt.Errorf("--wayback-after is after --wayback-before", wbAfter)
-			return
+		if err = ioutil.WriteFile(config.StatsJSON, data, 0644); err != nil {
+			return nil, err
 		}
-	} else {
-		wbAfterDate = time.Time{}
-		wbBeforeDate = time.Time{}
 	}
-
-	// Build and run the crawler
-	crawler = crawl.Crawler{
-		FetchDelay:    time.Duration(float64(time.Second) * delaySecs),
-		Folder:        folder,
-		MaxDepth:      depth,
-		Resume:        resume,
-		Seed:          urlParsed,
-		UseWayback:    wayback,
-		WaybackAfter:  wbAfterDate,
-		WaybackBefore: wbBeforeDate,
-	}
-	return
+	return stats, nil
 }
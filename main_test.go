@@ -4,6 +4,7 @@ import (
 	"github.com/jesand/webcp/crawl"
 	. "github.com/smartystreets/goconvey/convey"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -11,6 +12,21 @@ import (
 	"time"
 )
 
+// The timeouts used when no options change them
+var DEFAULT_TIMEOUTS = crawl.Timeouts{
+	Connect:       30 * time.Second,
+	TLSHandshake:  10 * time.Second,
+	Header:        60 * time.Second,
+	MinRate:       100,
+	MinRateWindow: 30 * time.Second,
+}
+
+var DEFAULT_TRAPS = crawl.TrapLimits{
+	URLLength:      2048,
+	SegmentRepeats: 2,
+	QueryVariants:  500,
+}
+
 func TestParseArgs(t *testing.T) {
 	const (
 		URL = "http://www.noplace.com/path/to/file.html"
@@ -25,14 +41,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The correct defaults are applied", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      5,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    false,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
@@ -56,14 +78,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    1 * time.Second,
-				Folder:        ".",
-				MaxDepth:      5,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    false,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      1 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
@@ -83,7 +111,7 @@ func TestParseArgs(t *testing.T) {
 	})
 
 	Convey("Given a valid new folder", t, func() {
-		tmp = os.TempDir()
+		tmp, _ = ioutil.TempDir("", "webcp")
 		Reset(func() {
 			os.RemoveAll(tmp)
 		})
@@ -93,14 +121,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        folder,
-				MaxDepth:      5,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    false,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          folder,
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 
@@ -111,7 +145,7 @@ func TestParseArgs(t *testing.T) {
 	})
 
 	Convey("Given a valid existing folder", t, func() {
-		tmp = os.TempDir()
+		tmp, _ = ioutil.TempDir("", "webcp")
 		Reset(func() {
 			os.RemoveAll(tmp)
 		})
@@ -122,20 +156,26 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        folder,
-				MaxDepth:      5,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    false,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          folder,
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
 
 	Convey("Given an invalid folder", t, func() {
-		tmp = os.TempDir()
+		tmp, _ = ioutil.TempDir("", "webcp")
 		Reset(func() {
 			os.RemoveAll(tmp)
 		})
@@ -151,14 +191,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      1,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    false,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        1,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
@@ -168,14 +214,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      0,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    false,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        0,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
@@ -194,8 +246,70 @@ func TestParseArgs(t *testing.T) {
 		})
 	})
 
+	Convey("Given limits on hops, pages and bytes, and page requisites", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--max-hops=1", "--max-pages=100",
+			"--max-bytes=1000000", "--requisites"})
+		Convey("The crawler is correct", func() {
+			So(err, ShouldBeNil)
+			So(crawler.MaxHops, ShouldEqual, 1)
+			So(crawler.MaxPages, ShouldEqual, 100)
+			So(crawler.MaxBytes, ShouldEqual, 1000000)
+			So(crawler.Requisites, ShouldBeTrue)
+		})
+	})
+
+	Convey("Given negative limits on hops, pages or bytes", t, func() {
+		for _, arg := range []string{"--max-hops=-1", "--max-pages=-1", "--max-bytes=-1"} {
+			_, err := ParseArgs([]string{URL, ".", arg})
+			Convey("An error is returned for "+arg, func() {
+				So(err, ShouldNotBeNil)
+			})
+		}
+	})
+
+	Convey("Given limits which keep the crawl out of traps", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--max-url-length=100", "--max-segment-repeats=0",
+			"--max-query-variants=20", "--max-similar-pages=10"})
+		Convey("The crawler is correct", func() {
+			So(err, ShouldBeNil)
+			So(crawler.Traps, ShouldResemble, crawl.TrapLimits{
+				URLLength:      100,
+				SegmentRepeats: 0,
+				QueryVariants:  20,
+				SimilarPages:   10,
+			})
+		})
+	})
+
+	Convey("Given a negative limit on crawler traps", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--max-similar-pages=-1"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given quotas", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--quota=example.com=1000",
+			"--quota=/search/=10"})
+		Convey("The crawler is correct", func() {
+			So(err, ShouldBeNil)
+			So(len(crawler.Quotas), ShouldEqual, 2)
+			So(crawler.Quotas[0].Match, ShouldEqual, "example.com")
+			So(crawler.Quotas[0].Pages, ShouldEqual, 1000)
+			So(crawler.Quotas[1].Match, ShouldEqual, "/search/")
+			So(crawler.Quotas[1].Pages, ShouldEqual, 10)
+		})
+	})
+
+	Convey("Given an invalid quota", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--quota=example.com"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
 	Convey("Given a non-existing resume file", t, func() {
-		tmp = os.TempDir()
+		tmp, _ = ioutil.TempDir("", "webcp")
 		Reset(func() {
 			os.RemoveAll(tmp)
 		})
@@ -204,20 +318,26 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      5,
-				Resume:        resume,
-				Seed:          URL_URL,
-				UseWayback:    false,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          resume,
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
 
 	Convey("Given an existing resume file", t, func() {
-		tmp = os.TempDir()
+		tmp, _ = ioutil.TempDir("", "webcp")
 		Reset(func() {
 			os.RemoveAll(tmp)
 		})
@@ -229,14 +349,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      5,
-				Resume:        resume.Name(),
-				Seed:          URL_URL,
-				UseWayback:    false,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          resume.Name(),
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
@@ -246,14 +372,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      5,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    true,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceWayback,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
@@ -263,14 +395,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      5,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    true,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceWayback,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
@@ -280,14 +418,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      5,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    true,
-				WaybackAfter:  time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceWayback,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
@@ -297,14 +441,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The argument is ignored", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      5,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    false,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Time{},
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
 			})
 		})
 	})
@@ -314,14 +464,20 @@ func TestParseArgs(t *testing.T) {
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
-				MaxDepth:      5,
-				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    true,
-				WaybackAfter:  time.Time{},
-				WaybackBefore: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceWayback,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+				Workers:         1,
 			})
 		})
 	})
@@ -331,37 +487,429 @@ func TestParseArgs(t *testing.T) {
 		Convey("The argument is ignored", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
-				Folder:        ".",
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
+			})
+		})
+	})
+
+	Convey("Given --wayback-before and --wayback-after and --wayback", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-after=2013", "--wayback-before=2014"})
+		Convey("The crawler is correct", func() {
+			So(err, ShouldBeNil)
+			So(crawler, ShouldResemble, crawl.Crawler{
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceWayback,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC),
+				WaybackBefore:   time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+				Workers:         1,
+			})
+		})
+	})
+
+	Convey("Given --wayback-before and --wayback-after out of order", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-after=2014", "--wayback-before=2013"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given --wayback-enumerate", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-enumerate",
+			"--wayback-after=2014", "--wayback-mime=text/html", "--wayback-collapse",
+			"--wayback-cdx=http://localhost:8080/cdx", "--wayback-archive=http://localhost:8080/web/"})
+		Convey("The crawler lists the archived pages", func() {
+			So(err, ShouldBeNil)
+			So(crawler.Source, ShouldEqual, crawl.SourceWayback)
+			So(crawler.WaybackEnumerate, ShouldBeTrue)
+			So(crawler.WaybackAfter, ShouldResemble, time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+			So(crawler.WaybackCDX, ShouldEqual, "http://localhost:8080/cdx")
+			So(crawler.WaybackArchive, ShouldEqual, "http://localhost:8080/web/")
+			So(crawler.WaybackFilter, ShouldResemble, crawl.CDXFilter{
+				Status:         "200",
+				MIMEType:       "text/html",
+				CollapseDigest: true,
+			})
+		})
+	})
+
+	Convey("Given --wayback-at", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-at=20120315",
+			"--manifest=manifest.jsonl"})
+		Convey("The crawler fetches the closest captures", func() {
+			So(err, ShouldBeNil)
+			So(crawler.WaybackStrategy, ShouldEqual, crawl.CaptureClosest)
+			So(crawler.WaybackAt, ShouldResemble, time.Date(2012, 3, 15, 0, 0, 0, 0, time.UTC))
+			So(crawler.Manifest, ShouldEqual, "manifest.jsonl")
+		})
+	})
+
+	Convey("Given --wayback-strategy", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-strategy=coherent",
+			"--wayback-at=2012"})
+		Convey("The crawler uses the strategy", func() {
+			So(err, ShouldBeNil)
+			So(crawler.WaybackStrategy, ShouldEqual, crawl.CaptureCoherent)
+			So(crawler.WaybackAt, ShouldResemble, time.Date(2012, 1, 1, 0, 0, 0, 0, time.UTC))
+		})
+	})
+
+	Convey("Given an invalid --wayback-strategy", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-strategy=newest"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given --wayback-strategy=closest without --wayback-at", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-strategy=closest"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given --source", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--source=live,wayback", "--wayback-after=2013"})
+		Convey("The crawler falls back to the archive", func() {
+			So(err, ShouldBeNil)
+			So(crawler.Source, ShouldEqual, crawl.SourceLiveThenWayback)
+			So(crawler.WaybackAfter, ShouldResemble, time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC))
+		})
+	})
+
+	Convey("Given --source with --wayback", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--wayback", "--source=wayback,live"})
+		Convey("The --source order is used", func() {
+			So(err, ShouldBeNil)
+			So(crawler.Source, ShouldEqual, crawl.SourceWaybackThenLive)
+		})
+	})
+
+	Convey("Given an invalid --source", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--source=archive"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given --wayback-status without --wayback-enumerate", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-status=2.."})
+		Convey("The argument is ignored", func() {
+			So(err, ShouldBeNil)
+			So(crawler.WaybackFilter, ShouldResemble, crawl.CDXFilter{})
+		})
+	})
+
+	Convey("Given --dedup and --dedup-skip-parse", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--dedup=symlink", "--dedup-skip-parse"})
+		Convey("The crawler is correct", func() {
+			So(err, ShouldBeNil)
+			So(crawler, ShouldResemble, crawl.Crawler{
+				Dedup:           crawl.DedupSymlink,
+				DedupSkipParse:  true,
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
+			})
+		})
+	})
+
+	Convey("Given --folder-scope", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--folder-scope"})
+		Convey("The crawler is correct", func() {
+			So(err, ShouldBeNil)
+			So(crawler, ShouldResemble, crawl.Crawler{
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				FolderScope:     true,
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
+			})
+		})
+	})
+
+	Convey("Given an invalid --dedup", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--dedup=monkey"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given rate limit options", t, func() {
+		tmp, _ = ioutil.TempDir("", "webcp")
+		Reset(func() {
+			os.RemoveAll(tmp)
+		})
+		limits := filepath.Join(tmp, "limits.txt")
+		So(ioutil.WriteFile(limits, []byte("slow.com rate=0.1\n"), 0644), ShouldBeNil)
+		crawler, err := ParseArgs([]string{URL, ".", "--workers=4", "--host-concurrency=2",
+			"--host-limits=" + limits, "--bandwidth=1000", "--retries=0",
+			"--control-addr=127.0.0.1:7070", "--metrics-file=webcp.prom"})
+		Convey("The crawler is correct", func() {
+			So(err, ShouldBeNil)
+			So(crawler, ShouldResemble, crawl.Crawler{
+				Bandwidth:       1000,
+				ControlAddr:     "127.0.0.1:7070",
+				FetchDelay:      5 * time.Second,
+				Folder:          ".",
+				HostConcurrency: 2,
+				HostLimits: map[string]crawl.HostLimit{
+					"slow.com": crawl.HostLimit{Rate: 0.1},
+				},
 				MaxDepth:      5,
+				MetricsFile:   "webcp.prom",
 				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    false,
+				Retries:       0,
+				Seeds:         []crawl.Seed{{URL: URL_URL}},
+				Timeouts:      DEFAULT_TIMEOUTS,
+				Traps:         DEFAULT_TRAPS,
+				Source:        crawl.SourceLive,
+				UserAgent:     USER_AGENT,
 				WaybackAfter:  time.Time{},
 				WaybackBefore: time.Time{},
+				Workers:       4,
 			})
 		})
 	})
 
-	Convey("Given --wayback-before and --wayback-after and --wayback", t, func() {
-		crawler, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-after=2013", "--wayback-before=2014"})
+	Convey("Given header options", t, func() {
+		crawler, err := ParseArgs([]string{"--user-agent=MyBot/1.0", "--header=Accept-Language: en-US",
+			URL, "--header=X-Team: docs", "."})
+		Convey("The crawler sends the headers", func() {
+			So(err, ShouldBeNil)
+			So(crawler.UserAgent, ShouldEqual, "MyBot/1.0")
+			So(crawler.Headers, ShouldResemble, http.Header{
+				"Accept-Language": {"en-US"},
+				"X-Team":          {"docs"},
+			})
+			So(crawler.Seeds, ShouldResemble, []crawl.Seed{{URL: URL_URL}})
+			So(crawler.Folder, ShouldEqual, ".")
+		})
+	})
+
+	Convey("Given an invalid --header", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--header=en-US"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given proxy and TLS options", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--proxy=socks5://127.0.0.1:1080",
+			"--no-proxy=intranet.corp,localhost", "--insecure"})
+		Convey("The crawler connects through the proxy", func() {
+			So(err, ShouldBeNil)
+			So(crawler.Connection.Proxy.String(), ShouldEqual, "socks5://127.0.0.1:1080")
+			So(crawler.Connection.TLS.InsecureSkipVerify, ShouldBeTrue)
+			So(crawler.NoProxy, ShouldResemble, []string{"intranet.corp", "localhost"})
+		})
+	})
+
+	Convey("Given an invalid --proxy", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--proxy=ftp://proxy.corp"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a --ca-cert which doesn't exist", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--ca-cert=/no/such/ca.pem"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given timeout options", t, func() {
+		crawler, err := ParseArgs([]string{URL, ".", "--connect-timeout=5", "--tls-timeout=2.5",
+			"--header-timeout=0", "--timeout=600", "--min-rate=2048", "--min-rate-window=10"})
+		Convey("The crawler enforces the timeouts", func() {
+			So(err, ShouldBeNil)
+			So(crawler.Timeouts, ShouldResemble, crawl.Timeouts{
+				Connect:       5 * time.Second,
+				TLSHandshake:  2500 * time.Millisecond,
+				Header:        0,
+				Total:         10 * time.Minute,
+				MinRate:       2048,
+				MinRateWindow: 10 * time.Second,
+			})
+		})
+	})
+
+	Convey("Given a negative timeout", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--timeout=-1"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an offline crawl", t, func() {
+		tmp, _ = ioutil.TempDir("", "webcp")
+		Reset(func() {
+			os.RemoveAll(tmp)
+		})
+		crawler, err := ParseArgs([]string{URL, ".", "--offline=" + tmp, "--delay=1"})
 		Convey("The crawler is correct", func() {
 			So(err, ShouldBeNil)
 			So(crawler, ShouldResemble, crawl.Crawler{
-				FetchDelay:    5 * time.Second,
+				FetchDelay:    0,
+				Fetcher:       &crawl.MirrorFetcher{Folder: tmp},
 				Folder:        ".",
 				MaxDepth:      5,
 				Resume:        "",
-				Seed:          URL_URL,
-				UseWayback:    true,
-				WaybackAfter:  time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC),
-				WaybackBefore: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+				Retries:       2,
+				Seeds:         []crawl.Seed{{URL: URL_URL}},
+				Timeouts:      DEFAULT_TIMEOUTS,
+				Traps:         DEFAULT_TRAPS,
+				Source:        crawl.SourceLive,
+				UserAgent:     USER_AGENT,
+				WaybackAfter:  time.Time{},
+				WaybackBefore: time.Time{},
+				Workers:       1,
 			})
 		})
 	})
 
-	Convey("Given --wayback-before and --wayback-after out of order", t, func() {
-		_, err := ParseArgs([]string{URL, ".", "--wayback", "--wayback-after=2014", "--wayback-before=2013"})
+	Convey("Given the check command", t, func() {
+		crawler, err := ParseArgs([]string{"check", URL, "--report-format=junit"})
+		Convey("The crawler checks links without saving pages", func() {
+			So(err, ShouldBeNil)
+			So(crawler, ShouldResemble, crawl.Crawler{
+				CheckLinks:      true,
+				FetchDelay:      5 * time.Second,
+				Folder:          "",
+				HostConcurrency: 1,
+				MaxDepth:        5,
+				Resume:          "",
+				Retries:         2,
+				Seeds:           []crawl.Seed{{URL: URL_URL}},
+				Timeouts:        DEFAULT_TIMEOUTS,
+				Traps:           DEFAULT_TRAPS,
+				Source:          crawl.SourceLive,
+				UserAgent:       USER_AGENT,
+				WaybackAfter:    time.Time{},
+				WaybackBefore:   time.Time{},
+				Workers:         1,
+			})
+		})
+	})
+
+	Convey("Given an invalid report format", t, func() {
+		_, err := ParseArgs([]string{"check", URL, "--report-format=html"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a missing offline crawl", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--offline=/no/such/folder"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given zero workers", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--workers=0"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a negative bandwidth", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--bandwidth=-1"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a missing host limits file", t, func() {
+		_, err := ParseArgs([]string{URL, ".", "--host-limits=/no/such/file"})
+		Convey("An error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given several URLs and a seeds file", t, func() {
+		tmp, _ = ioutil.TempDir("", "webcp")
+		Reset(func() {
+			os.RemoveAll(tmp)
+		})
+		seedsFile := filepath.Join(tmp, "seeds.txt")
+		So(ioutil.WriteFile(seedsFile, []byte("http://www.noplace.com/other/ 2\n"), 0644), ShouldBeNil)
+		crawler, err := ParseArgs([]string{URL, "http://www.noplace.com/more/", ".",
+			"--seeds-file=" + seedsFile})
+
+		Convey("The crawler is correct", func() {
+			So(err, ShouldBeNil)
+			So(len(crawler.Seeds), ShouldEqual, 3)
+			So(crawler.Seeds[0], ShouldResemble, crawl.Seed{URL: URL_URL})
+			So(crawler.Seeds[1].URL.String(), ShouldEqual, "http://www.noplace.com/more/")
+			So(crawler.Seeds[1].MaxDepth, ShouldEqual, 0)
+			So(crawler.Seeds[2].URL.String(), ShouldEqual, "http://www.noplace.com/other/")
+			So(crawler.Seeds[2].MaxDepth, ShouldEqual, 2)
+			So(crawler.Folder, ShouldEqual, ".")
+		})
+	})
+
+	Convey("Given just a seeds file", t, func() {
+		tmp, _ = ioutil.TempDir("", "webcp")
+		Reset(func() {
+			os.RemoveAll(tmp)
+		})
+		seedsFile := filepath.Join(tmp, "seeds.txt")
+		So(ioutil.WriteFile(seedsFile, []byte(URL+"\n"), 0644), ShouldBeNil)
+		crawler, err := ParseArgs([]string{".", "--seeds-file=" + seedsFile})
+
+		Convey("The crawler is correct", func() {
+			So(err, ShouldBeNil)
+			So(crawler.Seeds, ShouldResemble, []crawl.Seed{{URL: URL_URL}})
+			So(crawler.Folder, ShouldEqual, ".")
+		})
+	})
+
+	Convey("Given no URLs", t, func() {
+		_, err := ParseArgs([]string{"."})
 		Convey("An error is returned", func() {
 			So(err, ShouldNotBeNil)
 		})
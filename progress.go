@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jesand/webcp/crawl"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// How often to redraw the progress view on a terminal
+	TTY_PROGRESS_INTERVAL = 500 * time.Millisecond
+
+	// How often to log progress when stderr is not a terminal
+	LOG_PROGRESS_INTERVAL = 30 * time.Second
+
+	// The longest URL shown for a worker
+	MAX_URL_WIDTH = 100
+)
+
+// Shows a crawl's progress, either as a view redrawn in place on a terminal
+// or as single log lines
+type ProgressDisplay struct {
+	w     io.Writer
+	tty   bool
+	lines int
+}
+
+// Create a display which writes to a file, redrawing in place if it is a
+// terminal
+func NewProgressDisplay(file *os.File) *ProgressDisplay {
+	return &ProgressDisplay{
+		w:   file,
+		tty: isTerminal(file),
+	}
+}
+
+// Get how often the display should be updated
+func (display *ProgressDisplay) Interval() time.Duration {
+	if display.tty {
+		return TTY_PROGRESS_INTERVAL
+	}
+	return LOG_PROGRESS_INTERVAL
+}
+
+// Show the crawl's current progress
+func (display *ProgressDisplay) Show(progress crawl.Progress) {
+	if !display.tty {
+		fmt.Fprintln(display.w, progressSummary(progress))
+		return
+	}
+
+	// Move back to the top of the previous view, and draw over it
+	if display.lines > 0 {
+		fmt.Fprintf(display.w, "\x1b[%dA", display.lines)
+	}
+	fmt.Fprintf(display.w, "\x1b[2K%s\n", progressSummary(progress))
+	for i, page := range progress.Current {
+		current := "idle"
+		if page != nil {
+			current = truncate(page.String(), MAX_URL_WIDTH)
+		}
+		fmt.Fprintf(display.w, "\x1b[2K  [%d] %s\n", i+1, current)
+	}
+	display.lines = 1 + len(progress.Current)
+}
+
+// Summarize the crawl's progress in a single line
+func progressSummary(progress crawl.Progress) string {
+	eta := "unknown"
+	if progress.ETA > 0 {
+		eta = progress.ETA.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%v queued=%d in-flight=%d done=%d failed=%d bytes=%s eta=%s",
+		progress.Elapsed.Round(time.Second), progress.Queued, progress.InFlight,
+		progress.Done, progress.Failed, crawl.FormatBytes(float64(progress.Bytes)), eta)
+}
+
+// Shorten a string to at most max characters
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max-3] + "..."
+}
+
+// Ask whether a file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
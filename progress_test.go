@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jesand/webcp/crawl"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressDisplay(t *testing.T) {
+	Convey("Given a crawl in progress", t, func() {
+		page, _ := url.Parse("http://domain.com/page.html")
+		progress := crawl.Progress{
+			Queued:   10,
+			InFlight: 1,
+			Done:     5,
+			Failed:   2,
+			Bytes:    2048,
+			Current:  []*url.URL{page, nil},
+			Elapsed:  time.Minute,
+			ETA:      2 * time.Minute,
+		}
+		var buff bytes.Buffer
+
+		Convey("When I log its progress", func() {
+			display := &ProgressDisplay{w: &buff}
+			display.Show(progress)
+			display.Show(progress)
+
+			Convey("Then I write one line each time", func() {
+				line := "1m0s queued=10 in-flight=1 done=5 failed=2 bytes=2.0 KB eta=2m0s\n"
+				So(buff.String(), ShouldEqual, line+line)
+				So(display.Interval(), ShouldEqual, LOG_PROGRESS_INTERVAL)
+			})
+		})
+
+		Convey("When I show its progress on a terminal", func() {
+			display := &ProgressDisplay{w: &buff, tty: true}
+			display.Show(progress)
+			first := buff.String()
+			buff.Reset()
+			display.Show(progress)
+
+			Convey("Then I show each worker's page", func() {
+				So(first, ShouldContainSubstring, "  [1] http://domain.com/page.html\n")
+				So(first, ShouldContainSubstring, "  [2] idle\n")
+				So(strings.Count(first, "\n"), ShouldEqual, 3)
+			})
+
+			Convey("Then I redraw the view in place", func() {
+				So(first, ShouldNotStartWith, "\x1b[3A")
+				So(buff.String(), ShouldStartWith, "\x1b[3A")
+			})
+		})
+	})
+}